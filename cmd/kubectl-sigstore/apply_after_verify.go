@@ -33,6 +33,8 @@ func NewCmdApplyAfterVerify() *cobra.Command {
 	var imageRef string
 	var filename string
 	var keyPath string
+	var configPaths []string
+	var backendType string
 	cmd := &cobra.Command{
 		Use:   "apply-after-verify -f <YAMLFILE> [-i <IMAGE>]",
 		Short: "A command to apply Kubernetes YAML manifests only after verifying signature",
@@ -42,7 +44,7 @@ func NewCmdApplyAfterVerify() *cobra.Command {
 			if filename != "" {
 				kubeApplyArgs = append(kubeApplyArgs, []string{"--filename", filename}...)
 			}
-			err := applyAfterVerify(filename, imageRef, keyPath, kubeApplyArgs)
+			err := applyAfterVerify(filename, imageRef, keyPath, configPaths, backendType, kubeApplyArgs)
 			if err != nil {
 				return err
 			}
@@ -53,12 +55,14 @@ func NewCmdApplyAfterVerify() *cobra.Command {
 
 	cmd.PersistentFlags().StringVarP(&filename, "filename", "f", "", "file name which will be signed (if dir, all YAMLs inside it will be signed)")
 	cmd.PersistentFlags().StringVarP(&imageRef, "image", "i", "", "signed image name which bundles yaml files")
-	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your signing key (if empty, do key-less signing)")
+	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your public key, k8s://<namespace>/<secret> or env://<VAR> (if empty, do key-less verification)")
+	cmd.PersistentFlags().StringArrayVarP(&configPaths, "config", "c", nil, "path to verification config YAML file, directory, k8s://<namespace>/<configmap>[/<key>] or oci://<image> (can be repeated; later configs are merged onto earlier ones)")
+	cmd.PersistentFlags().StringVar(&backendType, "backend", "", "verifier backend (cosign, keyless, x509, gpg or external) which overrides the one in the config")
 
 	return cmd
 }
 
-func applyAfterVerify(filename, imageRef, keyPath string, kubeApplyArgs []string) error {
+func applyAfterVerify(filename, imageRef, keyPath string, configPaths []string, backendType string, kubeApplyArgs []string) error {
 	manifest, err := ioutil.ReadFile(filename)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -73,7 +77,13 @@ func applyAfterVerify(filename, imageRef, keyPath string, kubeApplyArgs []string
 	log.Debug("annotations", annotations)
 	log.Debug("imageRef", imageRef)
 
-	result, err := k8smanifest.Verify(manifest, imageRef, keyPath)
+	vo, err := loadVerifyOption(configPaths, backendType, keyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil
+	}
+
+	result, err := k8smanifest.Verify(manifest, imageRef, keyPath, vo)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return nil
@@ -121,6 +131,9 @@ func splitApplyArgs(args []string) ([]string, []string) {
 		"-i":         true,
 		"--key":      true,
 		"-k":         true,
+		"--config":   true,
+		"--backend":  true,
+		"-c":         true,
 	}
 	skipIndex := map[int]bool{}
 	for i, s := range args {
@@ -0,0 +1,90 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/k8smanifest"
+)
+
+func NewCmdConfig() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "A command to check verification config files",
+	}
+	cmd.AddCommand(newCmdConfigLint())
+	cmd.AddCommand(newCmdConfigSchema())
+	return cmd
+}
+
+func newCmdConfigLint() *cobra.Command {
+	var noWarnings bool
+	cmd := &cobra.Command{
+		Use:          "lint <CONFIGFILE> [<CONFIGFILE>...]",
+		Short:        "A command to find invalid patterns and rules which can never match in verification config files",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return lintConfigFiles(args, noWarnings)
+		},
+	}
+	cmd.PersistentFlags().BoolVar(&noWarnings, "no-warnings", false, "report only errors")
+	return cmd
+}
+
+func newCmdConfigSchema() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "A command to print the JSON Schema of verification config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schema, err := k8smanifest.VerifyOptionSchema()
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(schema))
+			return nil
+		},
+	}
+	return cmd
+}
+
+func lintConfigFiles(paths []string, noWarnings bool) error {
+	errNum := 0
+	for _, path := range paths {
+		cfgBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the config file")
+		}
+		for _, f := range k8smanifest.LintVerifyConfig(cfgBytes) {
+			if f.Level == k8smanifest.LintLevelError {
+				errNum++
+			} else if noWarnings {
+				continue
+			}
+			fmt.Fprintf(os.Stdout, "%s: %s\n", path, f.String())
+		}
+	}
+	if errNum > 0 {
+		return fmt.Errorf("found %v error(s) in the config", errNum)
+	}
+	return nil
+}
@@ -27,6 +27,9 @@ func init() {
 	rootCmd.AddCommand(NewCmdVerify())
 	rootCmd.AddCommand(NewCmdVerifyResource())
 	rootCmd.AddCommand(NewCmdApplyAfterVerify())
+	rootCmd.AddCommand(NewCmdScan())
+	rootCmd.AddCommand(NewCmdSuggestConfig())
+	rootCmd.AddCommand(NewCmdConfig())
 
 	log.SetLevel(log.InfoLevel)
 }
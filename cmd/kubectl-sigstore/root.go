@@ -17,8 +17,12 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/k8smanifest"
 )
 
 var rootCmd = &cobra.Command{
@@ -33,6 +37,30 @@ var rootCmd = &cobra.Command{
 		}
 		return nil
 	},
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		mirrors := []k8smanifest.RegistryMirror{}
+		for _, m := range registryMirrors {
+			parts := strings.Split(m, "=")
+			if len(parts) != 2 {
+				return errors.New(fmt.Sprintf("registry mirror must be `<registry>=<mirror>`, but `%s`", m))
+			}
+			mirrors = append(mirrors, k8smanifest.RegistryMirror{Registry: parts[0], Mirror: parts[1]})
+		}
+		registryOption.Mirrors = mirrors
+		return k8smanifest.ConfigureRegistry(&registryOption)
+	},
+}
+
+var registryOption k8smanifest.RegistryOption
+var registryMirrors []string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&registryOption.CACertPath, "registry-ca", "", "path to a CA bundle in PEM for registries")
+	rootCmd.PersistentFlags().StringArrayVar(&registryOption.InsecureRegistries, "insecure-registry", []string{}, "registry host whose TLS certificate is not verified, can be repeated")
+	rootCmd.PersistentFlags().StringArrayVar(&registryOption.PlainHTTPRegistries, "plain-http-registry", []string{}, "registry host which is connected with plain HTTP, can be repeated")
+	rootCmd.PersistentFlags().StringVar(&registryOption.DockerConfigPath, "docker-config", "", "path to a docker config.json for registry credentials")
+	rootCmd.PersistentFlags().StringArrayVar(&registryOption.ImagePullSecrets, "image-pull-secret", []string{}, "imagePullSecret in the form of <namespace>/<name> for registry credentials, can be repeated")
+	rootCmd.PersistentFlags().StringArrayVar(&registryMirrors, "registry-mirror", []string{}, "mirror of a registry in the form of <registry>=<mirror> for pulling bundles, can be repeated")
 }
 
 // TODO: set common flags for imageRef & key
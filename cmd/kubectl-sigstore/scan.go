@@ -0,0 +1,130 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/k8smanifest"
+)
+
+func NewCmdScan() *cobra.Command {
+
+	var imageRef string
+	var keyPath string
+	var configPaths []string
+	var namespace string
+	var kinds []string
+	var policyReport bool
+	var interval time.Duration
+	cmd := &cobra.Command{
+		Use:   "scan --kind <KIND> [-n <NAMESPACE>] [-i <IMAGE>]",
+		Short: "A command to scan resources on cluster and report their integrity status",
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			err := scan(kinds, namespace, imageRef, keyPath, configPaths, policyReport, interval)
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&imageRef, "image", "i", "", "signed image name which bundles yaml files")
+	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your public key, k8s://<namespace>/<secret> or env://<VAR> (if empty, do key-less verification)")
+	cmd.PersistentFlags().StringArrayVarP(&configPaths, "config", "c", nil, "path to verification config YAML file, directory, k8s://<namespace>/<configmap>[/<key>] or oci://<image> (can be repeated; later configs are merged onto earlier ones)")
+	cmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "namespace to be scanned (if empty, scan all namespaces)")
+	cmd.PersistentFlags().StringSliceVar(&kinds, "kind", []string{}, "kinds of resources to be scanned in the form of `[<group>/]<kind>` (e.g. ConfigMap, apps/Deployment)")
+	cmd.PersistentFlags().BoolVar(&policyReport, "policy-report", false, "whether to write the scan results as PolicyReports on cluster")
+	cmd.PersistentFlags().DurationVar(&interval, "interval", 0, "interval of periodic scan (if 0, scan only once)")
+
+	return cmd
+}
+
+func scan(kinds []string, namespace, imageRef, keyPath string, configPaths []string, policyReport bool, interval time.Duration) error {
+	ao := &k8smanifest.AuditOption{
+		Targets:   kindsToObjectReferences(kinds),
+		Namespace: namespace,
+		ImageRef:  imageRef,
+		KeyPath:   keyPath,
+	}
+	if len(configPaths) > 0 {
+		vo, err := k8smanifest.LoadVerifyConfigs(configPaths, keyPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return nil
+		}
+		ao.VerifyOption = vo
+	}
+
+	if interval > 0 {
+		if !policyReport {
+			fmt.Fprintln(os.Stderr, "`--interval` requires `--policy-report` to write the periodic scan results")
+			return nil
+		}
+		auditor := &k8smanifest.Auditor{
+			Interval:   interval,
+			LoadOption: func() (*k8smanifest.AuditOption, error) { return ao, nil },
+		}
+		return auditor.Start(context.Background())
+	}
+
+	auditResults, err := k8smanifest.Audit(ao)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil
+	}
+	results := []*k8smanifest.VerifyResourceResult{}
+	for _, r := range auditResults {
+		if r.Error != nil {
+			log.Error("failed to verify ", r.Object.GetKind(), " ", r.Object.GetName(), "; ", r.Error.Error())
+			continue
+		}
+		results = append(results, r.Result)
+	}
+	resultTable := makeResourceResultTable(results)
+	fmt.Println(string(resultTable))
+
+	if policyReport {
+		err = k8smanifest.WritePolicyReports(auditResults, namespace)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return nil
+		}
+		log.Info("policy reports are updated")
+	}
+	return nil
+}
+
+func kindsToObjectReferences(kinds []string) k8smanifest.ObjectReferenceList {
+	refs := k8smanifest.ObjectReferenceList{}
+	for _, k := range kinds {
+		ref := k8smanifest.ObjectReference{Kind: k}
+		if i := strings.LastIndex(k, "/"); i >= 0 {
+			ref.Group = k[:i]
+			ref.Kind = k[i+1:]
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
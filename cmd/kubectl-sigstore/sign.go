@@ -19,6 +19,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -32,12 +34,47 @@ func NewCmdSign() *cobra.Command {
 	var keyPath string
 	var output string
 	var updateAnnotation bool
+	var helmReleaseName string
+	var helmNamespace string
+	var helmValuesFiles []string
+	var parametersFile string
+	var fieldSelectors []string
+	var appendSignature bool
+	var backend k8smanifest.BackendOption
+	var signerCommand string
+	var keyPassword k8smanifest.KeyPasswordOption
+	var timestamp bool
+	var expiry time.Duration
+	var pinDigest bool
 	cmd := &cobra.Command{
 		Use:   "sign -f <YAMLFILE> [-i <IMAGE>]",
 		Short: "A command to sign Kubernetes YAML manifests",
 		RunE: func(cmd *cobra.Command, args []string) error {
 
-			err := sign(inputDir, imageRef, keyPath, output, updateAnnotation)
+			selectors, err := k8smanifest.ParseFieldSelectors(fieldSelectors)
+			if err != nil {
+				return err
+			}
+			so := &k8smanifest.SignOption{
+				Helm: &k8smanifest.HelmSignOption{
+					ReleaseName: helmReleaseName,
+					Namespace:   helmNamespace,
+					ValuesFiles: helmValuesFiles,
+				},
+				ParametersFile:  parametersFile,
+				FieldSelectors:  selectors,
+				AppendSignature: appendSignature,
+				Backend:         &backend,
+				Timestamp:       timestamp,
+				Expiry:          expiry,
+				UnpinnedRef:     !pinDigest,
+			}
+			if fields := strings.Fields(signerCommand); len(fields) > 0 {
+				backend.Command = fields[0]
+				backend.Args = fields[1:]
+			}
+			backend.KeyPassword = &keyPassword
+			err = sign(inputDir, imageRef, keyPath, output, updateAnnotation, so)
 			if err != nil {
 				return err
 			}
@@ -45,25 +82,45 @@ func NewCmdSign() *cobra.Command {
 		},
 	}
 
-	cmd.PersistentFlags().StringVarP(&inputDir, "filename", "f", "", "file name which will be signed (if dir, all YAMLs inside it will be signed)")
+	cmd.PersistentFlags().StringVarP(&inputDir, "filename", "f", "", "file name which will be signed (if dir, all YAMLs inside it will be signed; if kustomization dir or helm chart dir, the rendered manifests are also signed)")
 	cmd.PersistentFlags().StringVarP(&imageRef, "image", "i", "", "signed image name which bundles yaml files")
 	cmd.PersistentFlags().StringVarP(&output, "output", "o", "", "output file name (if empty, use `<input>.signed`)")
-	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your signing key (if empty, do key-less signing)")
+	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your signing key, k8s://<namespace>/<secret> or env://<VAR> (if empty, do key-less signing)")
 	cmd.PersistentFlags().BoolVarP(&updateAnnotation, "annotation", "a", true, "whether to update annotation and generate signed yaml file")
+	cmd.PersistentFlags().BoolVar(&pinDigest, "pin-digest", true, "write a digest-pinned image ref (e.g. bundle:dev@sha256:...) into annotation of signed YAML")
+	cmd.PersistentFlags().StringVar(&keyPassword.Env, "password-env", "", "name of an env var which has a password of the signing key")
+	cmd.PersistentFlags().StringVar(&keyPassword.File, "password-file", "", "path to a file which has a password of the signing key")
+	cmd.PersistentFlags().BoolVar(&keyPassword.Stdin, "password-stdin", false, "read a password of the signing key from stdin")
+	cmd.PersistentFlags().StringVar(&backend.Type, "backend", "", "signer backend (cosign, keyless, x509, gpg or external); if empty, cosign with `--key` or keyless")
+	cmd.PersistentFlags().StringVar(&backend.CertPath, "cert", "", "path to a signing certificate in PEM (for x509 backend)")
+	cmd.PersistentFlags().StringVar(&backend.ChainPath, "chain", "", "path to intermediate certificates in PEM (for x509 backend)")
+	cmd.PersistentFlags().StringVar(&signerCommand, "signer-command", "", "command line of an external signer (for external backend)")
+	cmd.PersistentFlags().BoolVar(&timestamp, "timestamp", false, "embed a signing time in the signed payload")
+	cmd.PersistentFlags().DurationVar(&expiry, "expiry", 0, "embed an expiry of the signature (e.g. 2160h) in the signed payload together with a signing time")
+	cmd.PersistentFlags().StringVar(&parametersFile, "parameters", "", "path to ManifestParameters YAML file which defines parameter fields and their constraints")
+	cmd.PersistentFlags().BoolVar(&appendSignature, "append-signature", false, "add a co-signature to an existing bundle image without uploading manifests (`-f` is not used)")
+	cmd.PersistentFlags().StringArrayVar(&fieldSelectors, "field-selector", []string{}, "fields to be signed for a kind in the form of `<kind>=<field>[,<field>...]` (e.g. `ConfigMap=data`), can be repeated")
+	cmd.PersistentFlags().StringVar(&helmReleaseName, "helm-release", "", "release name used for rendering a helm chart (only for a helm chart dir)")
+	cmd.PersistentFlags().StringVar(&helmNamespace, "helm-namespace", "", "namespace used for rendering a helm chart (only for a helm chart dir)")
+	cmd.PersistentFlags().StringArrayVar(&helmValuesFiles, "values", []string{}, "values file for rendering a helm chart, can be repeated (only for a helm chart dir)")
 
 	return cmd
 }
 
-func sign(inputDir, imageRef, keyPath, output string, updateAnnotation bool) error {
+func sign(inputDir, imageRef, keyPath, output string, updateAnnotation bool, so *k8smanifest.SignOption) error {
 	if output == "" {
 		output = inputDir + ".signed"
 	}
 
-	_, err := k8smanifest.Sign(inputDir, imageRef, keyPath, output, updateAnnotation)
+	_, err := k8smanifest.Sign(inputDir, imageRef, keyPath, output, updateAnnotation, so)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return nil
 	}
+	if so != nil && so.AppendSignature {
+		log.Info("a co-signature is added to ", imageRef)
+		return nil
+	}
 	log.Info("signed manifest generated at ", output)
 	return nil
 }
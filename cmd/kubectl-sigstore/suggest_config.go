@@ -0,0 +1,105 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/k8smanifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func NewCmdSuggestConfig() *cobra.Command {
+
+	var imageRef string
+	var keyPath string
+	var configPaths []string
+	var helmRelease string
+	var outputPath string
+	cmd := &cobra.Command{
+		Use:   "suggest-config -f <YAMLFILE> [-i <IMAGE>]",
+		Short: "A command to suggest ignoreFields of verification config from diffs of resources on cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fullArgs := getOriginalFullArgs("suggest-config")
+			_, kubeGetArgs := splitArgs(fullArgs)
+
+			err := suggestConfig(kubeGetArgs, imageRef, keyPath, configPaths, helmRelease, outputPath)
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+		FParseErrWhitelist: cobra.FParseErrWhitelist{UnknownFlags: true},
+	}
+
+	cmd.PersistentFlags().StringVarP(&imageRef, "image", "i", "", "signed image name which bundles yaml files")
+	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your public key, k8s://<namespace>/<secret> or env://<VAR> (if empty, do key-less verification)")
+	cmd.PersistentFlags().StringArrayVarP(&configPaths, "config", "c", nil, "path to the current verification config, whose ignoreFields are not suggested again (can be repeated)")
+	cmd.PersistentFlags().StringVar(&helmRelease, "helm-release", "", "name of helm release whose resources are verified (requires `--image`)")
+	cmd.PersistentFlags().StringVar(&outputPath, "output-file", "", "file name which the suggested config is written into (if empty, stdout)")
+
+	return cmd
+}
+
+func suggestConfig(kubeGetArgs []string, imageRef, keyPath string, configPaths []string, helmRelease, outputPath string) error {
+	var objs []unstructured.Unstructured
+	var err error
+	if helmRelease != "" {
+		// resources which are not in the signed render have no manifest to compare with
+		objs, _, err = k8smanifest.FindHelmReleaseResources(imageRef, helmRelease, getNamespaceInArgs(kubeGetArgs))
+	} else {
+		objs, err = getResourcesWithKubectl(kubeGetArgs)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil
+	}
+
+	vo, err := loadVerifyOption(configPaths, "", keyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil
+	}
+
+	results := []*k8smanifest.VerifyResourceResult{}
+	for _, obj := range objs {
+		result, err := k8smanifest.VerifyResource(obj, imageRef, keyPath, vo)
+		if err != nil {
+			// a resource which cannot be verified (e.g. no signature) has no diff to learn
+			log.Warn("failed to verify ", obj.GetKind(), " ", obj.GetName(), "; ", err.Error())
+			continue
+		}
+		results = append(results, result)
+	}
+
+	suggested := k8smanifest.SuggestConfig(results).ToYAML()
+	if outputPath == "" {
+		fmt.Print(string(suggested))
+		return nil
+	}
+	err = ioutil.WriteFile(outputPath, suggested, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil
+	}
+	log.Info("suggested config is written into ", outputPath)
+	return nil
+}
@@ -32,12 +32,14 @@ func NewCmdVerify() *cobra.Command {
 	var imageRef string
 	var filename string
 	var keyPath string
+	var configPaths []string
+	var backendType string
 	cmd := &cobra.Command{
 		Use:   "verify -f <YAMLFILE> [-i <IMAGE>]",
 		Short: "A command to verify Kubernetes YAML manifests",
 		RunE: func(cmd *cobra.Command, args []string) error {
 
-			err := verify(filename, imageRef, keyPath)
+			err := verify(filename, imageRef, keyPath, configPaths, backendType)
 			if err != nil {
 				return err
 			}
@@ -47,12 +49,14 @@ func NewCmdVerify() *cobra.Command {
 
 	cmd.PersistentFlags().StringVarP(&filename, "filename", "f", "", "file name which will be signed (if dir, all YAMLs inside it will be signed)")
 	cmd.PersistentFlags().StringVarP(&imageRef, "image", "i", "", "signed image name which bundles yaml files")
-	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your signing key (if empty, do key-less signing)")
+	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your public key, k8s://<namespace>/<secret> or env://<VAR> (if empty, do key-less verification)")
+	cmd.PersistentFlags().StringArrayVarP(&configPaths, "config", "c", nil, "path to verification config YAML file, directory, k8s://<namespace>/<configmap>[/<key>] or oci://<image> (can be repeated; later configs are merged onto earlier ones)")
+	cmd.PersistentFlags().StringVar(&backendType, "backend", "", "verifier backend (cosign, keyless, x509, gpg or external) which overrides the one in the config")
 
 	return cmd
 }
 
-func verify(filename, imageRef, keyPath string) error {
+func verify(filename, imageRef, keyPath string, configPaths []string, backendType string) error {
 	manifest, err := ioutil.ReadFile(filename)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -67,7 +71,13 @@ func verify(filename, imageRef, keyPath string) error {
 	log.Debug("annotations", annotations)
 	log.Debug("imageRef", imageRef)
 
-	result, err := k8smanifest.Verify(manifest, imageRef, keyPath)
+	vo, err := loadVerifyOption(configPaths, backendType, keyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil
+	}
+
+	result, err := k8smanifest.Verify(manifest, imageRef, keyPath, vo)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return nil
@@ -80,3 +90,27 @@ func verify(filename, imageRef, keyPath string) error {
 
 	return nil
 }
+
+// load and merge verification configs, and override the backend type with `--backend` option.
+// a config image is verified with the same key as bundles
+func loadVerifyOption(configPaths []string, backendType, keyPath string) (*k8smanifest.VerifyOption, error) {
+	vo := &k8smanifest.VerifyOption{}
+	if len(configPaths) > 0 {
+		var err error
+		vo, err = k8smanifest.LoadVerifyConfigs(configPaths, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		// registry options in the config take precedence over the flags
+		if err := k8smanifest.ConfigureRegistry(vo.Registry); err != nil {
+			return nil, err
+		}
+	}
+	if backendType != "" {
+		if vo.Backend == nil {
+			vo.Backend = &k8smanifest.BackendOption{}
+		}
+		vo.Backend.Type = backendType
+	}
+	return vo, nil
+}
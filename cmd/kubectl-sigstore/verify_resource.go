@@ -40,7 +40,10 @@ func NewCmdVerifyResource() *cobra.Command {
 
 	var imageRef string
 	var keyPath string
-	var configPath string
+	var configPaths []string
+	var backendType string
+	var helmRelease string
+	var helmValuesFiles []string
 	cmd := &cobra.Command{
 		Use:   "verify-resource -f <YAMLFILE> [-i <IMAGE>]",
 		Short: "A command to verify Kubernetes manifests of resources on cluster",
@@ -48,7 +51,7 @@ func NewCmdVerifyResource() *cobra.Command {
 			fullArgs := getOriginalFullArgs("verify-resource")
 			_, kubeGetArgs := splitArgs(fullArgs)
 
-			err := verifyResource(kubeGetArgs, imageRef, keyPath, configPath)
+			err := verifyResource(kubeGetArgs, imageRef, keyPath, configPaths, backendType, helmRelease, helmValuesFiles)
 			if err != nil {
 				return err
 			}
@@ -58,38 +61,45 @@ func NewCmdVerifyResource() *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVarP(&imageRef, "image", "i", "", "signed image name which bundles yaml files")
-	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your signing key (if empty, do key-less signing)")
-	cmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "path to verification config YAML file (for advanced verification)")
+	cmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to your public key, k8s://<namespace>/<secret> or env://<VAR> (if empty, do key-less verification)")
+	cmd.PersistentFlags().StringArrayVarP(&configPaths, "config", "c", nil, "path to verification config YAML file, directory, k8s://<namespace>/<configmap>[/<key>] or oci://<image> (can be repeated; later configs are merged onto earlier ones)")
+	cmd.PersistentFlags().StringVar(&backendType, "backend", "", "verifier backend (cosign, keyless, x509, gpg or external) which overrides the one in the config")
+	cmd.PersistentFlags().StringVar(&helmRelease, "helm-release", "", "name of helm release whose resources are verified (requires `--image`)")
+	cmd.PersistentFlags().StringArrayVar(&helmValuesFiles, "values", []string{}, "values file which the helm release must be rendered with, can be repeated in the same order as signing (only with `--helm-release`)")
 
 	return cmd
 }
 
-func verifyResource(kubeGetArgs []string, imageRef, keyPath, configPath string) error {
-	kArgs := []string{"get", "--output", "json"}
-	kArgs = append(kArgs, kubeGetArgs...)
-	log.Debug("kube get args", strings.Join(kArgs, " "))
-	resultJSON, err := k8ssigutil.CmdExec("kubectl", kArgs...)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+func verifyResource(kubeGetArgs []string, imageRef, keyPath string, configPaths []string, backendType, helmRelease string, helmValuesFiles []string) error {
+	var objs []unstructured.Unstructured
+	// resources of the helm release which are not in the signed render
+	var unsignedObjs []unstructured.Unstructured
+	var err error
+	if len(helmValuesFiles) > 0 && helmRelease == "" {
+		fmt.Fprintln(os.Stderr, "`--values` requires `--helm-release`")
 		return nil
 	}
-	var tmpObj unstructured.Unstructured
-	err = json.Unmarshal([]byte(resultJSON), &tmpObj)
+	if helmRelease != "" {
+		objs, unsignedObjs, err = k8smanifest.FindHelmReleaseResources(imageRef, helmRelease, getNamespaceInArgs(kubeGetArgs))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return nil
+		}
+	} else {
+		objs, err = getResourcesWithKubectl(kubeGetArgs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return nil
+		}
+	}
+
+	vo, err := loadVerifyOption(configPaths, backendType, keyPath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return nil
 	}
-	objs := []unstructured.Unstructured{}
-	if tmpObj.IsList() {
-		tmpList, _ := tmpObj.ToList()
-		objs = append(objs, tmpList.Items...)
-	} else {
-		objs = append(objs, tmpObj)
-	}
-
-	vo := &k8smanifest.VerifyOption{}
-	if configPath != "" {
-		vo, err = k8smanifest.LoadVerifyConfig(configPath)
+	if len(helmValuesFiles) > 0 {
+		vo.HelmValuesDigest, err = k8smanifest.HelmValuesDigest(helmValuesFiles)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			return nil
@@ -97,6 +107,10 @@ func verifyResource(kubeGetArgs []string, imageRef, keyPath, configPath string)
 	}
 
 	results := []*k8smanifest.VerifyResourceResult{}
+	for _, obj := range unsignedObjs {
+		log.Warn(obj.GetKind(), " ", obj.GetName(), " of the helm release has no signed manifest in the bundle")
+		results = append(results, &k8smanifest.VerifyResourceResult{Object: obj, InScope: true, Verified: false})
+	}
 	for _, obj := range objs {
 		result, err := k8smanifest.VerifyResource(obj, imageRef, keyPath, vo)
 		if err != nil {
@@ -113,16 +127,55 @@ func verifyResource(kubeGetArgs []string, imageRef, keyPath, configPath string)
 	return nil
 }
 
+func getResourcesWithKubectl(kubeGetArgs []string) ([]unstructured.Unstructured, error) {
+	kArgs := []string{"get", "--output", "json"}
+	kArgs = append(kArgs, kubeGetArgs...)
+	log.Debug("kube get args", strings.Join(kArgs, " "))
+	resultJSON, err := k8ssigutil.CmdExec("kubectl", kArgs...)
+	if err != nil {
+		return nil, err
+	}
+	var tmpObj unstructured.Unstructured
+	err = json.Unmarshal([]byte(resultJSON), &tmpObj)
+	if err != nil {
+		return nil, err
+	}
+	objs := []unstructured.Unstructured{}
+	if tmpObj.IsList() {
+		tmpList, _ := tmpObj.ToList()
+		objs = append(objs, tmpList.Items...)
+	} else {
+		objs = append(objs, tmpObj)
+	}
+	return objs, nil
+}
+
+func getNamespaceInArgs(kubeGetArgs []string) string {
+	for i, s := range kubeGetArgs {
+		if (s == "-n" || s == "--namespace") && i+1 < len(kubeGetArgs) {
+			return kubeGetArgs[i+1]
+		}
+		if strings.HasPrefix(s, "--namespace=") {
+			return strings.TrimPrefix(s, "--namespace=")
+		}
+	}
+	return ""
+}
+
 func splitArgs(args []string) ([]string, []string) {
 	mainArgs := []string{}
 	kubectlArgs := []string{}
 	mainArgsCondition := map[string]bool{
-		"--image":  true,
-		"-i":       true,
-		"--key":    true,
-		"-k":       true,
-		"--config": true,
-		"-c":       true,
+		"--image":        true,
+		"-i":             true,
+		"--key":          true,
+		"-k":             true,
+		"--config":       true,
+		"--backend":      true,
+		"-c":             true,
+		"--helm-release": true,
+		"--values":       true,
+		"--output-file":  true,
 	}
 	skipIndex := map[int]bool{}
 	for i, s := range args {
@@ -150,6 +203,9 @@ func makeResourceResultTable(results []*k8smanifest.VerifyResourceResult) []byte
 		resAge := getAge(resTime)
 		inscope := strconv.FormatBool(r.InScope)
 		signer := r.Signer
+		if len(r.Signers) > 1 {
+			signer = strings.Join(r.Signers, ",")
+		}
 		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t\n", resName, inscope, verified, signer, resAge)
 		tableResult = fmt.Sprintf("%s%s", tableResult, line)
 	}
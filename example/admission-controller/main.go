@@ -22,6 +22,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	k8smnfconfig "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/example/admission-controller/pkg/config"
@@ -29,14 +31,18 @@ import (
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
@@ -53,8 +59,12 @@ const defaultManifestIntegrityConfigMapName = "k8s-manifest-integrity-config"
 
 // +kubebuilder:webhook:path=/validate-resource,mutating=false,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=*,resources=*,verbs=create;update,versions=*,name=k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
 
+const eventReasonVerificationFailed = "ManifestVerificationFailed"
+const eventReasonDriftDetected = "ManifestDriftDetected"
+
 type k8sManifestHandler struct {
-	Client client.Client
+	Client   client.Client
+	Recorder record.EventRecorder
 }
 
 func getPodNamespace() string {
@@ -86,12 +96,18 @@ func (h *k8sManifestHandler) Handle(ctx context.Context, req admission.Request)
 	if config == nil {
 		config = &k8smnfconfig.ManifestIntegrityConfig{}
 	}
+	// the registry is reconfigured only when the option in the config is changed
+	if err := k8smanifest.ConfigureRegistry(config.Registry); err != nil {
+		log.Errorf("failed to configure registry; %s", err.Error())
+		return admission.Allowed("error but allow for development")
+	}
 
 	skipUserMatched := config.SkipUsers.Match(obj, req.AdmissionRequest.UserInfo.Username)
 	inScopeObjMatched := config.InScopeObjects.Match(obj)
 
 	allow := true
 	message := ""
+	eventReason := eventReasonVerificationFailed
 	if skipUserMatched {
 		allow = true
 		message = "ignore user config matched"
@@ -105,6 +121,11 @@ func (h *k8sManifestHandler) Handle(ctx context.Context, req admission.Request)
 			keyPath, _ = config.LoadKeySecret()
 		}
 		vo := &(config.VerifyOption)
+		// managedFields are written by clients, so they are trusted only for UPDATE and only when
+		// the allowlisted managers are not written by this request
+		if len(vo.AllowedFieldManagers) > 0 && !allowedFieldManagersTrusted(req, obj, vo.AllowedFieldManagers) {
+			vo.AllowedFieldManagers = nil
+		}
 		result, err := k8smanifest.VerifyResource(obj, imageRef, keyPath, vo)
 		if err != nil {
 			log.Errorf("failed to check a requested resource; %s", err.Error())
@@ -113,15 +134,26 @@ func (h *k8sManifestHandler) Handle(ctx context.Context, req admission.Request)
 		if result.InScope {
 			if result.Verified {
 				allow = true
-				message = fmt.Sprintf("singed by a valid signer: %s", result.Signer)
+				message = fmt.Sprintf("singed by a valid signer: %s", strings.Join(result.Signers, ", "))
+				if len(result.Mutations) > 0 {
+					mutators := []string{}
+					for _, m := range result.Mutations {
+						mutators = append(mutators, m.Mutator)
+					}
+					message = fmt.Sprintf("%s (mutated by known mutators: %s)", message, strings.Join(mutators, ", "))
+				}
 			} else {
 				allow = false
 				message = "no signature found"
 				if result.Diff != nil && result.Diff.Size() > 0 {
 					message = fmt.Sprintf("diff found: %s", result.Diff.String())
+					eventReason = eventReasonDriftDetected
 				}
 				if result.Signer != "" {
-					message = fmt.Sprintf("signer config not matched, this is signed by %s", result.Signer)
+					message = fmt.Sprintf("signer config not matched, this is signed by %s", strings.Join(result.Signers, ", "))
+				}
+				if result.Revoked != "" {
+					message = fmt.Sprintf("revoked: %s", result.Revoked)
 				}
 			}
 		} else {
@@ -135,10 +167,63 @@ func (h *k8sManifestHandler) Handle(ctx context.Context, req admission.Request)
 	if allow {
 		return admission.Allowed(message)
 	} else {
+		h.recordEvent(req, obj, eventReason, message)
 		return admission.Denied(message)
 	}
 }
 
+func allowedFieldManagersTrusted(req admission.Request, obj unstructured.Unstructured, managers k8smanifest.FieldManagerList) bool {
+	if req.AdmissionRequest.Operation != admissionv1.Update {
+		return false
+	}
+	var oldObj unstructured.Unstructured
+	if err := json.Unmarshal(req.AdmissionRequest.OldObject.Raw, &oldObj); err != nil {
+		log.Errorf("failed to Unmarshal an old object into %T; %s", oldObj, err.Error())
+		return false
+	}
+	return managers.UnchangedIn(obj, oldObj)
+}
+
+// a dry-run request must not have side effects (sideEffects=NoneOnDryRun), and an object being created
+// has no UID yet, so an event cannot refer to it
+func (h *k8sManifestHandler) recordEvent(req admission.Request, obj unstructured.Unstructured, reason, message string) {
+	if h.Recorder == nil {
+		return
+	}
+	if req.AdmissionRequest.DryRun != nil && *req.AdmissionRequest.DryRun {
+		return
+	}
+	if obj.GetUID() == "" {
+		log.Debugf("skip an event for %s `%s` without UID", obj.GetKind(), obj.GetName())
+		return
+	}
+	h.Recorder.Event(&obj, corev1.EventTypeWarning, reason, message)
+}
+
+// audit option is loaded from the same config as admission requests, and resources in `inScopeObjects` are audited
+func loadAuditOption() (*k8smanifest.AuditOption, error) {
+	config, err := k8smnfconfig.LoadConfig(getPodNamespace(), defaultManifestIntegrityConfigMapName)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+	if err := k8smanifest.ConfigureRegistry(config.Registry); err != nil {
+		return nil, err
+	}
+	keyPath := ""
+	if config.KeySecertName != "" {
+		keyPath, _ = config.LoadKeySecret()
+	}
+	return &k8smanifest.AuditOption{
+		Targets:      config.InScopeObjects,
+		ImageRef:     config.ImageRef,
+		KeyPath:      keyPath,
+		VerifyOption: &(config.VerifyOption),
+	}, nil
+}
+
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
@@ -146,32 +231,65 @@ func init() {
 	// +kubebuilder:scaffold:scheme
 }
 
+var _ manager.LeaderElectionRunnable = &k8smanifest.Auditor{}
+
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var auditInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
+	flag.DurationVar(&auditInterval, "audit-interval", 0, "The interval of background audit which writes PolicyReports. Audit is disabled if 0.")
+	flag.BoolVar(&enableLeaderElection, "enable-leader-election", true,
 		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
+			"Enabling this will ensure there is only one active auditor, while all replicas serve admission requests.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "22a603b9.sigstore.dev",
-		CertDir:            tlsDir,
+		Scheme:                  scheme,
+		MetricsBindAddress:      metricsAddr,
+		Port:                    9443,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "22a603b9.sigstore.dev",
+		LeaderElectionNamespace: getPodNamespace(),
+		CertDir:                 tlsDir,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// expose verification metrics on the metrics endpoint of the manager
+	metrics.Registry.MustRegister(k8smanifest.MetricsCollectors()...)
+
+	// configure registry connections before serving requests
+	if config, err := k8smnfconfig.LoadConfig(getPodNamespace(), defaultManifestIntegrityConfigMapName); err != nil {
+		setupLog.Error(err, "unable to load manifest integrity config")
+	} else if config != nil {
+		if err := k8smanifest.ConfigureRegistry(config.Registry); err != nil {
+			setupLog.Error(err, "unable to configure registry")
+		}
+	}
+
 	hookServer := mgr.GetWebhookServer()
-	hookServer.Register("/validate-resource", &webhook.Admission{Handler: &k8sManifestHandler{Client: mgr.GetClient()}})
+	handler := &k8sManifestHandler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("k8s-manifest-sigstore"),
+	}
+	hookServer.Register("/validate-resource", &webhook.Admission{Handler: handler})
+
+	if auditInterval > 0 {
+		// the auditor runs only in the leader, so PolicyReports are not written by every replica
+		auditor := &k8smanifest.Auditor{
+			Interval:   auditInterval,
+			LoadOption: loadAuditOption,
+		}
+		if err := mgr.Add(auditor); err != nil {
+			setupLog.Error(err, "unable to add auditor")
+			os.Exit(1)
+		}
+	}
 
 	// +kubebuilder:scaffold:builder
 
@@ -21,9 +21,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"strings"
 
-	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
 
 	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/k8smanifest"
@@ -34,6 +34,8 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+//go:generate go run gen_schema.go
+
 const configKeyInConfigMap = "config.yaml"
 
 type ManifestIntegrityConfig struct {
@@ -89,10 +91,15 @@ func LoadConfig(namespace, name string) (*ManifestIntegrityConfig, error) {
 		return nil, errors.New(fmt.Sprintf("`%s` is not found in configmap", configKeyInConfigMap))
 	}
 	var conf *ManifestIntegrityConfig
-	err = yaml.Unmarshal([]byte(cfgBytes), &conf)
+	err = k8smnfutil.StrictUnmarshalYAML([]byte(cfgBytes), &conf)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("failed to unmarshal config.yaml into %T", conf))
 	}
+	if conf != nil {
+		if err = conf.VerifyOption.Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid config.yaml")
+		}
+	}
 	return conf, nil
 }
 
@@ -126,3 +133,8 @@ func (c *ManifestIntegrityConfig) LoadKeySecret() (string, error) {
 
 	return keyPath, nil
 }
+
+// Schema returns a JSON Schema of config.yaml in the configmap
+func Schema() ([]byte, error) {
+	return k8smnfutil.GenerateJSONSchema(reflect.TypeOf(ManifestIntegrityConfig{}), "ManifestIntegrityConfig")
+}
@@ -0,0 +1,47 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build ignore
+// +build ignore
+
+// this generates the JSON Schema of ManifestIntegrityConfig into schemas dir
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/example/admission-controller/pkg/config"
+)
+
+func main() {
+	out := filepath.Join("..", "..", "schemas", "manifest-integrity-config.schema.json")
+	if len(os.Args) > 1 {
+		out = os.Args[1]
+	}
+	schema, err := config.Schema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err = ioutil.WriteFile(out, append(schema, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -3,6 +3,8 @@ module github.com/yuji-watanabe-jp/k8s-manifest-sigstore
 go 1.16
 
 require (
+	github.com/ProtonMail/go-crypto v0.0.0-20210428141323-04723f9f07d7
+	github.com/docker/cli v20.10.0-beta1.0.20201117192004-5cc239616494+incompatible
 	github.com/ghodss/yaml v1.0.0
 	github.com/google/go-containerregistry v0.5.1
 	github.com/jinzhu/copier v0.3.2
@@ -10,12 +12,14 @@ require (
 	github.com/onsi/ginkgo v1.15.0
 	github.com/onsi/gomega v1.11.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.10.0
 	github.com/r3labs/diff v1.1.0
 	github.com/sigstore/cosign v0.0.0-00010101000000-000000000000
 	github.com/sigstore/sigstore v0.0.0-20210530211317-99216b8b86a6
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.1.3
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	k8s.io/api v0.20.2
 	k8s.io/apimachinery v0.21.1
 	k8s.io/client-go v0.20.2
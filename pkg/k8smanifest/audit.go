@@ -0,0 +1,376 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	kubeutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/kubeutil"
+)
+
+const (
+	PolicyReportAPIVersion       = "wgpolicyk8s.io/v1alpha2"
+	PolicyReportKind             = "PolicyReport"
+	ClusterPolicyReportKind      = "ClusterPolicyReport"
+	PolicyReportName             = "k8s-manifest-sigstore"
+	PolicyReportSource           = "k8s-manifest-sigstore"
+	PolicyReportPolicyName       = "k8s-manifest-integrity"
+	PolicyReportRuleName         = "signed-manifest"
+	PolicyReportCategory         = "Manifest Integrity"
+	policyReportResultPass       = "pass"
+	policyReportResultFail       = "fail"
+	policyReportResultSkip       = "skip"
+	policyReportResultError      = "error"
+	policyReportSignerProperty   = "signer"
+	policyReportDiffProperty     = "diff"
+	policyReportImageRefProperty = "imageRef"
+	policyReportMutatorProperty  = "mutators"
+)
+
+// kinds which are never audited even if they match with the audit targets
+var auditExcludedKinds = []string{
+	"Event",
+	PolicyReportKind,
+	ClusterPolicyReportKind,
+}
+
+type AuditOption struct {
+	Targets      ObjectReferenceList `json:"targets,omitempty"`
+	Namespace    string              `json:"namespace,omitempty"`
+	ImageRef     string              `json:"imageRef,omitempty"`
+	KeyPath      string              `json:"keyPath,omitempty"`
+	VerifyOption *VerifyOption       `json:"verifyOption,omitempty"`
+}
+
+type AuditResult struct {
+	Object unstructured.Unstructured
+	Result *VerifyResourceResult
+	Error  error
+}
+
+// Auditor periodically verifies in-scope resources on cluster and writes the results as PolicyReports.
+// It implements `Start(ctx)`, so it can be added to a controller-runtime manager as a Runnable.
+// It also implements `NeedLeaderElection()`, so only the leader among replicas writes PolicyReports.
+type Auditor struct {
+	Interval time.Duration
+	// LoadOption is called at every audit so that the latest configuration is used
+	LoadOption func() (*AuditOption, error)
+}
+
+func (a *Auditor) Start(ctx context.Context) error {
+	if a.Interval <= 0 {
+		return errors.New("audit interval must be positive")
+	}
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		a.runOnce()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the manager start the auditor only in the elected leader
+func (a *Auditor) NeedLeaderElection() bool {
+	return true
+}
+
+func (a *Auditor) runOnce() {
+	ao, err := a.LoadOption()
+	if err != nil {
+		log.Errorf("failed to load audit option; %s", err.Error())
+		return
+	}
+	if ao == nil || len(ao.Targets) == 0 {
+		log.Debug("no audit targets are configured; skip this audit")
+		return
+	}
+	results, err := Audit(ao)
+	if err != nil {
+		log.Errorf("failed to audit resources; %s", err.Error())
+		return
+	}
+	err = WritePolicyReports(results, ao.Namespace)
+	if err != nil {
+		log.Errorf("failed to write policy reports; %s", err.Error())
+	}
+}
+
+// Audit verifies all resources on cluster which match with the audit targets
+func Audit(ao *AuditOption) ([]AuditResult, error) {
+	if ao == nil || len(ao.Targets) == 0 {
+		return nil, errors.New("at least one audit target must be specified")
+	}
+	objs, err := listAuditTargetObjects(ao.Targets, ao.Namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list audit target resources")
+	}
+	vo := ao.VerifyOption
+	if vo == nil {
+		vo = &VerifyOption{}
+	}
+	results := []AuditResult{}
+	for _, obj := range objs {
+		result, err := VerifyResource(obj, ao.ImageRef, ao.KeyPath, vo)
+		if err != nil {
+			log.Debug("failed to verify ", obj.GetKind(), " ", obj.GetName(), "; ", err.Error())
+		}
+		results = append(results, AuditResult{Object: obj, Result: result, Error: err})
+	}
+	return results, nil
+}
+
+func listAuditTargetObjects(targets ObjectReferenceList, namespace string) ([]unstructured.Unstructured, error) {
+	apiResources, err := kubeutil.GetAPIResources()
+	if err != nil {
+		return nil, err
+	}
+	objs := []unstructured.Unstructured{}
+	sumErr := []string{}
+	for _, r := range apiResources {
+		if strings.Contains(r.Name, "/") || !k8ssigutil.ExactMatchWithPatternArray("list", r.Verbs) {
+			continue
+		}
+		if k8ssigutil.ExactMatchWithPatternArray(r.Kind, auditExcludedKinds) {
+			continue
+		}
+		kindMatched := false
+		for _, t := range targets {
+			if k8ssigutil.MatchPattern(t.Group, r.Group) && k8ssigutil.MatchPattern(t.Version, r.Version) && k8ssigutil.MatchPattern(t.Kind, r.Kind) {
+				kindMatched = true
+				break
+			}
+		}
+		if !kindMatched {
+			continue
+		}
+		apiVersion := schema.GroupVersion{Group: r.Group, Version: r.Version}.String()
+		resNamespace := namespace
+		if !r.Namespaced {
+			resNamespace = ""
+		}
+		resources, err := kubeutil.ListResources(apiVersion, r.Kind, resNamespace)
+		if err != nil {
+			sumErr = append(sumErr, err.Error())
+			continue
+		}
+		for _, res := range resources {
+			if targets.Match(*res) {
+				objs = append(objs, *res)
+			}
+		}
+	}
+	if len(objs) == 0 && len(sumErr) > 0 {
+		return nil, errors.New(strings.Join(sumErr, "; "))
+	}
+	return objs, nil
+}
+
+// NewPolicyReports generates a PolicyReport for each namespace and a ClusterPolicyReport for cluster scope resources
+func NewPolicyReports(results []AuditResult) []*unstructured.Unstructured {
+	resultsByNamespace := map[string][]interface{}{}
+	namespaces := []string{}
+	for _, r := range results {
+		ns := r.Object.GetNamespace()
+		if _, ok := resultsByNamespace[ns]; !ok {
+			namespaces = append(namespaces, ns)
+		}
+		resultsByNamespace[ns] = append(resultsByNamespace[ns], newPolicyReportResult(r))
+	}
+
+	now := time.Now()
+	reports := []*unstructured.Unstructured{}
+	for _, ns := range namespaces {
+		reportResults := resultsByNamespace[ns]
+		summary := map[string]interface{}{
+			policyReportResultPass:  int64(0),
+			policyReportResultFail:  int64(0),
+			"warn":                  int64(0),
+			policyReportResultError: int64(0),
+			policyReportResultSkip:  int64(0),
+		}
+		for _, rr := range reportResults {
+			res := rr.(map[string]interface{})["result"].(string)
+			summary[res] = summary[res].(int64) + 1
+		}
+		kind := PolicyReportKind
+		if ns == "" {
+			kind = ClusterPolicyReportKind
+		}
+		report := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": PolicyReportAPIVersion,
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name": PolicyReportName,
+				"labels": map[string]interface{}{
+					"app.kubernetes.io/managed-by": PolicyReportSource,
+				},
+				"annotations": map[string]interface{}{
+					"k8s-manifest-sigstore/lastAuditTime": now.UTC().Format(time.RFC3339),
+				},
+			},
+			"summary": summary,
+			"results": reportResults,
+		}}
+		if ns != "" {
+			report.SetNamespace(ns)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func newPolicyReportResult(r AuditResult) map[string]interface{} {
+	obj := r.Object
+	result := policyReportResultFail
+	message := "no signature found"
+	properties := map[string]interface{}{}
+	if imageRef, ok := obj.GetAnnotations()[ImageRefAnnotationKey]; ok {
+		properties[policyReportImageRefProperty] = imageRef
+	}
+	if r.Error != nil {
+		result = policyReportResultError
+		message = r.Error.Error()
+	} else if r.Result != nil {
+		if r.Result.Signer != "" {
+			properties[policyReportSignerProperty] = r.Result.Signer
+		}
+		if len(r.Result.Mutations) > 0 {
+			properties[policyReportMutatorProperty] = strings.Join(mutatorNames(r.Result.Mutations), ",")
+		}
+		if !r.Result.InScope {
+			result = policyReportResultSkip
+			message = "not in scope of verification"
+		} else if r.Result.Verified {
+			result = policyReportResultPass
+			message = "signed by a valid signer"
+			if r.Result.Signer != "" {
+				message = fmt.Sprintf("signed by a valid signer: %s", r.Result.Signer)
+			}
+		} else if r.Result.Revoked != "" {
+			message = fmt.Sprintf("revoked: %s", r.Result.Revoked)
+		} else if r.Result.Diff != nil && r.Result.Diff.Size() > 0 {
+			message = "diff found between the resource and the signed manifest"
+			properties[policyReportDiffProperty] = strings.Join(r.Result.Diff.Keys(), ",")
+		} else if r.Result.Signer != "" {
+			message = fmt.Sprintf("signer config not matched, this is signed by %s", r.Result.Signer)
+		}
+	}
+	now := time.Now()
+	return map[string]interface{}{
+		"source":   PolicyReportSource,
+		"policy":   PolicyReportPolicyName,
+		"rule":     PolicyReportRuleName,
+		"category": PolicyReportCategory,
+		"result":   result,
+		"message":  message,
+		"scored":   true,
+		"timestamp": map[string]interface{}{
+			"seconds": now.Unix(),
+			"nanos":   int64(0),
+		},
+		"resources": []interface{}{
+			map[string]interface{}{
+				"apiVersion": obj.GetAPIVersion(),
+				"kind":       obj.GetKind(),
+				"name":       obj.GetName(),
+				"namespace":  obj.GetNamespace(),
+				"uid":        string(obj.GetUID()),
+			},
+		},
+		"properties": properties,
+	}
+}
+
+// WritePolicyReports creates or updates PolicyReports on cluster with the audit results.
+// results in a report are replaced with the ones of the current scan, and reports of namespaces
+// which have no result anymore (e.g. all the audited resources are deleted) are deleted.
+// `namespace` is the scope of the audit; reports in other namespaces are kept if it is not empty.
+func WritePolicyReports(results []AuditResult, namespace string) error {
+	reports := NewPolicyReports(results)
+	sumErr := []string{}
+	for _, report := range reports {
+		_, err := kubeutil.ApplyResource(report)
+		if err != nil {
+			sumErr = append(sumErr, fmt.Sprintf("%s `%s`; %s", report.GetKind(), report.GetNamespace(), err.Error()))
+		}
+	}
+	existing, err := listPolicyReports(namespace)
+	if err != nil {
+		sumErr = append(sumErr, fmt.Sprintf("failed to list existing reports; %s", err.Error()))
+	}
+	for _, report := range stalePolicyReports(existing, reports) {
+		err := kubeutil.DeleteResource(report.GetAPIVersion(), report.GetKind(), report.GetNamespace(), report.GetName())
+		if err != nil {
+			sumErr = append(sumErr, fmt.Sprintf("%s `%s`; %s", report.GetKind(), report.GetNamespace(), err.Error()))
+		}
+	}
+	if len(sumErr) > 0 {
+		return errors.New(strings.Join(sumErr, "; "))
+	}
+	return nil
+}
+
+func listPolicyReports(namespace string) ([]*unstructured.Unstructured, error) {
+	reports, err := kubeutil.ListResources(PolicyReportAPIVersion, PolicyReportKind, namespace)
+	if err != nil {
+		return nil, err
+	}
+	clusterReports, err := kubeutil.ListResources(PolicyReportAPIVersion, ClusterPolicyReportKind, "")
+	if err != nil {
+		return nil, err
+	}
+	return append(reports, clusterReports...), nil
+}
+
+// return reports written by previous scans which are not written by the current scan
+func stalePolicyReports(existing, current []*unstructured.Unstructured) []*unstructured.Unstructured {
+	written := map[string]bool{}
+	for _, r := range current {
+		written[r.GetKind()+"/"+r.GetNamespace()] = true
+	}
+	stale := []*unstructured.Unstructured{}
+	for _, r := range existing {
+		if r.GetName() != PolicyReportName || r.GetLabels()["app.kubernetes.io/managed-by"] != PolicyReportSource {
+			continue
+		}
+		if !written[r.GetKind()+"/"+r.GetNamespace()] {
+			stale = append(stale, r)
+		}
+	}
+	return stale
+}
+
+func mutatorNames(mutations []MutationResult) []string {
+	names := []string{}
+	for _, m := range mutations {
+		names = append(names, m.Mutator)
+	}
+	return names
+}
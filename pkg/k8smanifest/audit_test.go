@@ -0,0 +1,114 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+func newAuditTestObject(kind, namespace, name string) unstructured.Unstructured {
+	obj := unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion("v1")
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func TestNewPolicyReports(t *testing.T) {
+	results := []AuditResult{
+		{
+			Object: newAuditTestObject("ConfigMap", "ns1", "signed"),
+			Result: &VerifyResourceResult{InScope: true, Verified: true, Signer: "signer@example.com"},
+		},
+		{
+			Object: newAuditTestObject("ConfigMap", "ns1", "changed"),
+			Result: &VerifyResourceResult{InScope: true, Diff: &mapnode.DiffResult{Items: []mapnode.Difference{{Key: "data.key"}}}},
+		},
+		{
+			Object: newAuditTestObject("ConfigMap", "ns2", "broken"),
+			Error:  errors.New("failed to get bundle"),
+		},
+		{
+			Object: newAuditTestObject("Namespace", "", "ns1"),
+			Result: &VerifyResourceResult{},
+		},
+	}
+	reports := NewPolicyReports(results)
+	if len(reports) != 3 {
+		t.Fatalf("expected 3 reports, but got %d", len(reports))
+	}
+
+	ns1 := reports[0]
+	if ns1.GetKind() != PolicyReportKind || ns1.GetNamespace() != "ns1" || ns1.GetName() != PolicyReportName {
+		t.Errorf("unexpected report: %s %s/%s", ns1.GetKind(), ns1.GetNamespace(), ns1.GetName())
+	}
+	summary, _, _ := unstructured.NestedMap(ns1.Object, "summary")
+	if summary[policyReportResultPass] != int64(1) || summary[policyReportResultFail] != int64(1) {
+		t.Errorf("unexpected summary: %v", summary)
+	}
+	ns1Results, _, _ := unstructured.NestedSlice(ns1.Object, "results")
+	passed := ns1Results[0].(map[string]interface{})
+	if passed["result"] != policyReportResultPass || passed["properties"].(map[string]interface{})[policyReportSignerProperty] != "signer@example.com" {
+		t.Errorf("unexpected result for a signed resource: %v", passed)
+	}
+	failed := ns1Results[1].(map[string]interface{})
+	if failed["result"] != policyReportResultFail || failed["properties"].(map[string]interface{})[policyReportDiffProperty] != "data.key" {
+		t.Errorf("unexpected result for a changed resource: %v", failed)
+	}
+
+	ns2Results, _, _ := unstructured.NestedSlice(reports[1].Object, "results")
+	if r := ns2Results[0].(map[string]interface{}); r["result"] != policyReportResultError || r["message"] != "failed to get bundle" {
+		t.Errorf("unexpected result for an error: %v", r)
+	}
+
+	cluster := reports[2]
+	if cluster.GetKind() != ClusterPolicyReportKind || cluster.GetNamespace() != "" {
+		t.Errorf("cluster scope resources must be written into %s, but got %s", ClusterPolicyReportKind, cluster.GetKind())
+	}
+	clusterResults, _, _ := unstructured.NestedSlice(cluster.Object, "results")
+	if r := clusterResults[0].(map[string]interface{}); r["result"] != policyReportResultSkip {
+		t.Errorf("unexpected result for an out of scope resource: %v", r)
+	}
+}
+
+func TestStalePolicyReports(t *testing.T) {
+	current := NewPolicyReports([]AuditResult{
+		{Object: newAuditTestObject("ConfigMap", "ns1", "cm"), Result: &VerifyResourceResult{InScope: true, Verified: true}},
+	})
+	existing := NewPolicyReports([]AuditResult{
+		{Object: newAuditTestObject("ConfigMap", "ns1", "cm"), Result: &VerifyResourceResult{InScope: true}},
+		{Object: newAuditTestObject("ConfigMap", "ns2", "deleted"), Result: &VerifyResourceResult{InScope: true}},
+		{Object: newAuditTestObject("Namespace", "", "deleted"), Result: &VerifyResourceResult{InScope: true}},
+	})
+	// a report with the same name but written by another tool is kept
+	other := newAuditTestObject(PolicyReportKind, "ns3", PolicyReportName)
+	existing = append(existing, &other)
+
+	stale := stalePolicyReports(existing, current)
+	if len(stale) != 2 {
+		t.Fatalf("expected 2 stale reports, but got %d", len(stale))
+	}
+	if stale[0].GetNamespace() != "ns2" || stale[1].GetKind() != ClusterPolicyReportKind {
+		t.Errorf("unexpected stale reports: %s/%s, %s/%s", stale[0].GetKind(), stale[0].GetNamespace(), stale[1].GetKind(), stale[1].GetNamespace())
+	}
+}
@@ -0,0 +1,331 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/pkg/cosign"
+	cremote "github.com/sigstore/cosign/pkg/cosign/remote"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+)
+
+const (
+	// cosign with a key pair
+	BackendCosign = "cosign"
+	// cosign with a certificate from fulcio
+	BackendKeyless = "keyless"
+	// a private key and a certificate chain issued by your own CA
+	BackendX509 = "x509"
+	// GPG / OpenPGP key
+	BackendGPG = "gpg"
+	// an external executable which signs / verifies a payload (e.g. a tool for HSM)
+	BackendExternal = "external"
+)
+
+// SignerBackend signs a bundle image
+type SignerBackend interface {
+	Sign(imageRef string, annotations map[string]interface{}) error
+}
+
+// VerifierBackend verifies signatures of a bundle image and returns all of the valid signers
+type VerifierBackend interface {
+	Verify(imageRef string) (bool, []string, error)
+}
+
+// BackendOption selects a signer / verifier backend and configures it.
+// If Type is empty, cosign is used with KeyPath, or keyless if KeyPath is empty.
+type BackendOption struct {
+	Type string `json:"type,omitempty"`
+	// cosign: a private key (sign) / a public key (verify)
+	// x509: a private key in PEM (sign)
+	// gpg: an armored private key (sign) / an armored public keyring (verify)
+	KeyPath string `json:"key,omitempty"`
+	// x509: a signing certificate and its intermediate certificates in PEM (sign)
+	CertPath  string `json:"cert,omitempty"`
+	ChainPath string `json:"chain,omitempty"`
+	// x509: root CA certificates in PEM (verify)
+	RootsPath string `json:"roots,omitempty"`
+	// x509: extended key usages which a signing certificate must have, e.g. `codeSigning`, `any` (if empty, `codeSigning`)
+	KeyUsages []string `json:"keyUsages,omitempty"`
+	// external: an executable and its arguments. `sign` or `verify` is appended to the arguments
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// where a password of a private key is read from (sign)
+	KeyPassword *KeyPasswordOption `json:"-"`
+
+	// set by resolveKey()
+	passFunc cosign.PassFunc
+	// set by VerifyOption.backendOption()
+	checker *signatureChecker
+}
+
+func (o *BackendOption) backendType() string {
+	if o == nil || o.Type == "" {
+		if o != nil && o.KeyPath != "" {
+			return BackendCosign
+		}
+		return BackendKeyless
+	}
+	return o.Type
+}
+
+// return an option for container images, which are signed by cosign with the key of the image option,
+// so only the checks of signing time and revocation are taken over from the option for bundles
+func (o *BackendOption) imageBackendOption() *BackendOption {
+	if o == nil || o.checker == nil {
+		return nil
+	}
+	return &BackendOption{checker: &signatureChecker{TimePolicy: o.checker.TimePolicy, Revocation: o.checker.Revocation}}
+}
+
+// return a copy of the option with a key path, which is usually given by `--key` option
+func (o *BackendOption) withKeyPath(keyPath string) *BackendOption {
+	newOpt := &BackendOption{}
+	if o != nil {
+		*newOpt = *o
+	}
+	if keyPath != "" {
+		newOpt.KeyPath = keyPath
+	}
+	return newOpt
+}
+
+func NewSignerBackend(opt *BackendOption) (SignerBackend, error) {
+	if opt == nil {
+		opt = &BackendOption{}
+	}
+	switch t := opt.backendType(); t {
+	case BackendCosign, BackendKeyless:
+		return &cosignBackend{KeyPath: opt.KeyPath, Pf: opt.passFunc}, nil
+	case BackendX509:
+		return &x509Backend{option: *opt}, nil
+	case BackendGPG:
+		return &gpgBackend{option: *opt}, nil
+	case BackendExternal:
+		return &externalBackend{option: *opt}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown backend type `%s`", t))
+	}
+}
+
+func NewVerifierBackend(opt *BackendOption) (VerifierBackend, error) {
+	if opt == nil {
+		opt = &BackendOption{}
+	}
+	switch t := opt.backendType(); t {
+	case BackendCosign, BackendKeyless:
+		return &cosignBackend{KeyPath: opt.KeyPath, Checker: opt.checker}, nil
+	case BackendX509:
+		if opt.RootsPath == "" {
+			return nil, errors.New("root certificates are required for x509 backend")
+		}
+		return &x509Backend{option: *opt}, nil
+	case BackendGPG:
+		return &gpgBackend{option: *opt}, nil
+	case BackendExternal:
+		return &externalBackend{option: *opt}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown backend type `%s`", t))
+	}
+}
+
+// signPayloadFunc signs a payload and returns a signature with an optional certificate and chain in PEM
+type signPayloadFunc func(payload []byte) (sig []byte, cert, chain string, err error)
+
+// sign a payload of the bundle image digest in the same format as cosign, and upload the signature
+// so that signatures from any backends are stored in the same place
+func signAndUploadPayload(imageRef string, annotations map[string]interface{}, signFunc signPayloadFunc) error {
+	remoteOpts := k8ssigutil.RegistryRemoteOptions()
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse image ref")
+	}
+	desc, err := remote.Get(ref, remoteOpts...)
+	if err != nil {
+		return errors.Wrap(err, "failed to get remote image")
+	}
+	img := ref.Context().Digest(desc.Digest.String())
+	payloadBytes, err := (&payload.Cosign{Image: img, Annotations: annotations}).MarshalJSON()
+	if err != nil {
+		return errors.Wrap(err, "failed to generate payload")
+	}
+	sig, cert, chain, err := signFunc(payloadBytes)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign payload")
+	}
+	sigRef, err := cosign.SignaturesRef(img)
+	if err != nil {
+		return err
+	}
+	uo := cremote.UploadOpts{
+		Cert:       cert,
+		Chain:      chain,
+		RemoteOpts: remoteOpts,
+	}
+	_, err = cremote.UploadSignature(context.Background(), sig, payloadBytes, sigRef, uo)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload signature")
+	}
+	return nil
+}
+
+// annotations of a signature layer in the cosign format
+const (
+	cosignSignatureAnnotationKey   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotationKey = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotationKey       = "dev.sigstore.cosign/chain"
+)
+
+// same as cosign.FetchSignatures, but the registry options (transport, credentials and mirrors) are used
+func fetchSignatures(imageRef string) ([]cosign.SignedPayload, *v1.Descriptor, error) {
+	ref, err := name.ParseReference(k8ssigutil.MirrorImageRef(imageRef))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("failed to parse image ref `%s`", imageRef))
+	}
+	remoteOpts := k8ssigutil.RegistryRemoteOptions()
+	targetDesc, err := remote.Get(ref, remoteOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	sigRef, err := cosign.DestinationRef(ref, targetDesc)
+	if err != nil {
+		return nil, nil, err
+	}
+	sigImg, err := remote.Image(sigRef, remoteOpts...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get signature image")
+	}
+	m, err := sigImg.Manifest()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get manifest of signature image")
+	}
+	signatures := []cosign.SignedPayload{}
+	for _, layerDesc := range m.Layers {
+		base64sig, ok := layerDesc.Annotations[cosignSignatureAnnotationKey]
+		if !ok {
+			continue
+		}
+		layer, err := sigImg.LayerByDigest(layerDesc.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the raw bytes in the registry are the payload
+		r, err := layer.Compressed()
+		if err != nil {
+			return nil, nil, err
+		}
+		payloadBytes, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		sp := cosign.SignedPayload{Payload: payloadBytes, Base64Signature: base64sig}
+		if certPem := layerDesc.Annotations[cosignCertificateAnnotationKey]; certPem != "" {
+			certs, err := cosign.LoadCerts(certPem)
+			if err != nil {
+				return nil, nil, err
+			}
+			sp.Cert = certs[0]
+		}
+		if chainPem := layerDesc.Annotations[cosignChainAnnotationKey]; chainPem != "" {
+			certs, err := cosign.LoadCerts(chainPem)
+			if err != nil {
+				return nil, nil, err
+			}
+			sp.Chain = certs
+		}
+		if bundle := layerDesc.Annotations[cremote.BundleKey]; bundle != "" {
+			var b cremote.Bundle
+			if err := json.Unmarshal([]byte(bundle), &b); err != nil {
+				return nil, nil, errors.Wrap(err, "failed to unmarshal bundle")
+			}
+			sp.Bundle = &b
+		}
+		signatures = append(signatures, sp)
+	}
+	return signatures, &targetDesc.Descriptor, nil
+}
+
+// verifySignatureFunc verifies a signature of a payload and returns the signer name and the key fingerprint
+// (if empty, the fingerprint of the certificate in the signature is used for revocation)
+type verifySignatureFunc func(sp cosign.SignedPayload, sig []byte) (signerName, keyFingerprint string, err error)
+
+// fetch all signatures of the bundle image, and return signers of the signatures which are verified
+// and whose payloads have the digest of the image and pass the checker (signing time, revocation)
+func verifyFetchedSignatures(imageRef string, verifyFunc verifySignatureFunc, checker *signatureChecker) (bool, []string, error) {
+	signedPayloads, desc, err := fetchSignatures(imageRef)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to fetch signatures of image `%s`; %s", imageRef, err.Error())
+	}
+	signerNames := []string{}
+	found := map[string]bool{}
+	lastErr := ""
+	var revoked *RevokedError
+	for _, sp := range signedPayloads {
+		if sp.Base64Signature == "" {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(sp.Base64Signature)
+		if err != nil {
+			continue
+		}
+		signerName, keyFingerprint, err := verifyFunc(sp, sig)
+		if err != nil {
+			lastErr = err.Error()
+			continue
+		}
+		ss := payload.SimpleContainerImage{}
+		if err := json.Unmarshal(sp.Payload, &ss); err != nil {
+			continue
+		}
+		if err := sp.VerifyClaims(desc, &ss); err != nil {
+			lastErr = err.Error()
+			continue
+		}
+		if err := checker.check(sp, signerName, keyFingerprint, time.Now()); err != nil {
+			if revokedErr, ok := err.(*RevokedError); ok {
+				revoked = revokedErr
+			}
+			lastErr = err.Error()
+			continue
+		}
+		if found[signerName] {
+			continue
+		}
+		found[signerName] = true
+		signerNames = append(signerNames, signerName)
+	}
+	if len(signerNames) == 0 && revoked != nil {
+		return false, nil, revoked
+	}
+	if len(signerNames) == 0 {
+		return false, nil, fmt.Errorf("no verified signatures in the image `%s`; %s", imageRef, lastErr)
+	}
+	return true, signerNames, nil
+}
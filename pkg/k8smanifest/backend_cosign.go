@@ -0,0 +1,135 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+
+	cosigncli "github.com/sigstore/cosign/cmd/cosign/cli"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/cosign/pkg/cosign/fulcio"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+)
+
+// cosignBackend signs and verifies with cosign. If KeyPath is empty, keyless signing is used.
+type cosignBackend struct {
+	KeyPath string
+	// reads a password of a private key. if nil, `COSIGN_PASSWORD` env or a prompt is used
+	Pf cosign.PassFunc
+	// rejects verified signatures by their signing time or revocation
+	Checker *signatureChecker
+}
+
+func (b *cosignBackend) Sign(imageRef string, imageAnnotation map[string]interface{}) error {
+
+	// TODO: check sk (security key) and idToken (identity token for cert from fulcio)
+	sk := false
+	idToken := ""
+
+	// TODO: handle the case that COSIGN_EXPERIMENTAL env var is not set
+
+	opt := cosigncli.SignOpts{
+		Annotations: imageAnnotation,
+		Sk:          sk,
+		IDToken:     idToken,
+	}
+
+	if b.KeyPath != "" {
+		opt.KeyRef = b.KeyPath
+		opt.Pf = b.Pf
+		if opt.Pf == nil {
+			opt.Pf = cosigncli.GetPass
+		}
+	}
+
+	return cosigncli.SignCmd(context.Background(), opt, imageRef, true, "", false, false)
+}
+
+func (b *cosignBackend) Verify(imageRef string) (bool, []string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to parse image ref `%s`; %s", imageRef, err.Error())
+	}
+
+	co := &cosign.CheckOpts{
+		Claims: true,
+		Tlog:   true,
+		Roots:  fulcio.Roots,
+	}
+
+	keyFingerprint := ""
+	if b.KeyPath != "" {
+		tmpPubkey, err := cosign.LoadPublicKey(context.Background(), b.KeyPath)
+		if err != nil {
+			return false, nil, fmt.Errorf("error loading public key; %s", err.Error())
+		}
+		co.PubKey = tmpPubkey
+		if pub, err := tmpPubkey.PublicKey(context.Background()); err == nil {
+			keyFingerprint = publicKeyFingerprint(pub)
+		}
+	}
+
+	rekorSever := cosigncli.TlogServer()
+	verified, err := cosign.Verify(context.Background(), ref, co, rekorSever)
+	if err != nil {
+		return false, nil, fmt.Errorf("error occured while verifying image `%s`; %s", imageRef, err.Error())
+	}
+	if len(verified) == 0 {
+		return false, nil, fmt.Errorf("no verified signatures in the image `%s`", imageRef)
+	}
+	// collect all valid signers, as a policy may require signatures from multiple signers
+	signerNames := []string{}
+	found := map[string]bool{}
+	lastErr := ""
+	var revoked *RevokedError
+	for _, vp := range verified {
+		ss := payload.SimpleContainerImage{}
+		err := json.Unmarshal(vp.Payload, &ss)
+		if err != nil {
+			continue
+		}
+		signerName := "" // singerName could be empty in case of key-used verification
+		if vp.Cert != nil {
+			signerName = k8ssigutil.GetNameInfoFromCert(vp.Cert)
+		}
+		if err := b.Checker.check(vp, signerName, keyFingerprint, time.Now()); err != nil {
+			if revokedErr, ok := err.(*RevokedError); ok {
+				revoked = revokedErr
+			}
+			lastErr = err.Error()
+			continue
+		}
+		if found[signerName] {
+			continue
+		}
+		found[signerName] = true
+		signerNames = append(signerNames, signerName)
+	}
+	if len(signerNames) == 0 && revoked != nil {
+		return false, nil, revoked
+	}
+	if len(signerNames) == 0 {
+		return false, nil, fmt.Errorf("no valid signatures in the image `%s`; %s", imageRef, lastErr)
+	}
+	return true, signerNames, nil
+}
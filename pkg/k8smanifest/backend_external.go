@@ -0,0 +1,125 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/pkg/cosign"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+)
+
+const (
+	externalSignAction   = "sign"
+	externalVerifyAction = "verify"
+)
+
+// externalBackend calls an external executable for signing and verification.
+//
+// sign:   `<command> <args>... sign` reads a payload from stdin, and writes ExternalSignOutput in JSON to stdout.
+// verify: `<command> <args>... verify` reads ExternalVerifyInput in JSON from stdin, and writes ExternalVerifyOutput in JSON to stdout.
+// A non-zero exit code is regarded as an error.
+type externalBackend struct {
+	option BackendOption
+}
+
+type ExternalSignOutput struct {
+	// base64 encoded signature
+	Signature string `json:"signature"`
+	// optional certificate and chain in PEM
+	Certificate string `json:"certificate,omitempty"`
+	Chain       string `json:"chain,omitempty"`
+}
+
+type ExternalVerifyInput struct {
+	// base64 encoded payload and signature
+	Payload     string `json:"payload"`
+	Signature   string `json:"signature"`
+	Certificate string `json:"certificate,omitempty"`
+	Chain       string `json:"chain,omitempty"`
+}
+
+type ExternalVerifyOutput struct {
+	Verified bool   `json:"verified"`
+	Signer   string `json:"signer,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+func (b *externalBackend) Sign(imageRef string, annotations map[string]interface{}) error {
+	if b.option.Command == "" {
+		return errors.New("a command is required for external backend")
+	}
+	return signAndUploadPayload(imageRef, annotations, func(payload []byte) ([]byte, string, string, error) {
+		out, err := b.exec(externalSignAction, payload)
+		if err != nil {
+			return nil, "", "", err
+		}
+		var so ExternalSignOutput
+		if err := json.Unmarshal(out, &so); err != nil {
+			return nil, "", "", errors.Wrap(err, "failed to parse the output of external signer")
+		}
+		sig, err := base64.StdEncoding.DecodeString(so.Signature)
+		if err != nil {
+			return nil, "", "", errors.Wrap(err, "failed to decode the signature from external signer")
+		}
+		return sig, so.Certificate, so.Chain, nil
+	})
+}
+
+func (b *externalBackend) Verify(imageRef string) (bool, []string, error) {
+	if b.option.Command == "" {
+		return false, nil, errors.New("a command is required for external backend")
+	}
+	return verifyFetchedSignatures(imageRef, func(sp cosign.SignedPayload, sig []byte) (string, string, error) {
+		vi := ExternalVerifyInput{
+			Payload:   base64.StdEncoding.EncodeToString(sp.Payload),
+			Signature: sp.Base64Signature,
+		}
+		if sp.Cert != nil {
+			vi.Certificate = string(cosign.CertToPem(sp.Cert))
+		}
+		for _, c := range sp.Chain {
+			vi.Chain += string(cosign.CertToPem(c))
+		}
+		input, _ := json.Marshal(vi)
+		out, err := b.exec(externalVerifyAction, input)
+		if err != nil {
+			return "", "", err
+		}
+		var vo ExternalVerifyOutput
+		if err := json.Unmarshal(out, &vo); err != nil {
+			return "", "", errors.Wrap(err, "failed to parse the output of external verifier")
+		}
+		if !vo.Verified {
+			return "", "", errors.New("external verifier rejected the signature; " + vo.Message)
+		}
+		return vo.Signer, "", nil
+	}, b.option.checker)
+}
+
+func (b *externalBackend) exec(action string, input []byte) ([]byte, error) {
+	args := append([]string{}, b.option.Args...)
+	args = append(args, action)
+	out, err := k8ssigutil.CmdExecWithInput(input, b.option.Command, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run external command")
+	}
+	return []byte(out), nil
+}
@@ -0,0 +1,127 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/pkg/errors"
+	cosigncli "github.com/sigstore/cosign/cmd/cosign/cli"
+	"github.com/sigstore/cosign/pkg/cosign"
+)
+
+// gpgBackend signs with an armored OpenPGP private key, and verifies with an armored public keyring.
+// A signature is a binary detached signature of the payload.
+type gpgBackend struct {
+	option BackendOption
+}
+
+func (b *gpgBackend) Sign(imageRef string, annotations map[string]interface{}) error {
+	if b.option.KeyPath == "" {
+		return errors.New("a private key is required for gpg backend")
+	}
+	entity, err := loadGPGSigningEntity(b.option.KeyPath, b.option.passFunc)
+	if err != nil {
+		return err
+	}
+	return signAndUploadPayload(imageRef, annotations, func(payload []byte) ([]byte, string, string, error) {
+		var sig bytes.Buffer
+		err := openpgp.DetachSign(&sig, entity, bytes.NewReader(payload), nil)
+		return sig.Bytes(), "", "", err
+	})
+}
+
+func (b *gpgBackend) Verify(imageRef string) (bool, []string, error) {
+	if b.option.KeyPath == "" {
+		return false, nil, errors.New("a public keyring is required for gpg backend")
+	}
+	keyring, err := loadGPGKeyRing(b.option.KeyPath)
+	if err != nil {
+		return false, nil, err
+	}
+	return verifyFetchedSignatures(imageRef, func(sp cosign.SignedPayload, sig []byte) (string, string, error) {
+		entity, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(sp.Payload), bytes.NewReader(sig), nil)
+		if err != nil {
+			return "", "", errors.Wrap(err, "invalid gpg signature")
+		}
+		return gpgEntityName(entity), gpgFingerprint(entity), nil
+	}, b.option.checker)
+}
+
+func loadGPGKeyRing(fpath string) (openpgp.EntityList, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open gpg keyring")
+	}
+	defer f.Close()
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read gpg keyring")
+	}
+	return keyring, nil
+}
+
+func loadGPGSigningEntity(fpath string, pf cosign.PassFunc) (*openpgp.Entity, error) {
+	keyring, err := loadGPGKeyRing(fpath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			if pf == nil {
+				pf = cosigncli.GetPass
+			}
+			passphrase, err := pf(false)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read passphrase")
+			}
+			err = entity.PrivateKey.Decrypt(passphrase)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to decrypt gpg private key")
+			}
+		}
+		return entity, nil
+	}
+	return nil, errors.New("no private key is found in the gpg key file")
+}
+
+// return an email of the entity if found, otherwise its identity name
+func gpgEntityName(entity *openpgp.Entity) string {
+	for _, id := range entity.Identities {
+		if id.UserId != nil && id.UserId.Email != "" {
+			return id.UserId.Email
+		}
+	}
+	for name := range entity.Identities {
+		return name
+	}
+	return ""
+}
+
+// return the fingerprint of the primary key in hex, which is matched with `keyFingerprints` of revocations
+func gpgFingerprint(entity *openpgp.Entity) string {
+	if entity == nil || entity.PrimaryKey == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint)
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+)
+
+// push a random image to an in-memory registry, and return the registry host and the image
+func pushTestImage(t *testing.T) (string, v1.Image, func()) {
+	s := httptest.NewServer(registry.New())
+	u, _ := url.Parse(s.URL)
+	img, err := random.Image(128, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := name.ParseReference(u.Host + "/sample/manifest:v1")
+	if err := remote.Write(ref, img, k8ssigutil.RegistryRemoteOptions()...); err != nil {
+		t.Fatal(err)
+	}
+	return u.Host, img, s.Close
+}
+
+func TestFetchSignaturesWithMirror(t *testing.T) {
+	host, img, cleanup := pushTestImage(t)
+	defer cleanup()
+	mirrorRef := host + "/sample/manifest:v1"
+	signFunc := func(payload []byte) ([]byte, string, string, error) {
+		return []byte("signature"), "", "", nil
+	}
+	if err := signAndUploadPayload(mirrorRef, nil, signFunc); err != nil {
+		t.Fatal(err)
+	}
+
+	// the image is pulled from the mirror, because the original registry does not exist
+	k8ssigutil.SetRegistryMirrors(map[string]string{"registry.invalid": host})
+	defer k8ssigutil.SetRegistryMirrors(nil)
+	signedPayloads, desc, err := fetchSignatures("registry.invalid/sample/manifest:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := img.Digest()
+	if len(signedPayloads) != 1 || desc.Digest != digest {
+		t.Errorf("expected a signature of %s, got %d signatures of %v", digest, len(signedPayloads), desc.Digest)
+	}
+}
+
+func TestGPGBackendRevocationByFingerprint(t *testing.T) {
+	host, _, cleanup := pushTestImage(t)
+	defer cleanup()
+	imageRef := host + "/sample/manifest:v1"
+
+	entity, err := openpgp.NewEntity("signer", "", "signer@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privPath := filepath.Join(dir, "private.asc")
+	pubPath := filepath.Join(dir, "public.asc")
+	writeArmored := func(fpath, blockType string, serialize func(*bytes.Buffer) error) {
+		var buf bytes.Buffer
+		w, _ := armor.Encode(&buf, blockType, nil)
+		var raw bytes.Buffer
+		if err := serialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(raw.Bytes())
+		w.Close()
+		if err := ioutil.WriteFile(fpath, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeArmored(privPath, openpgp.PrivateKeyType, func(b *bytes.Buffer) error { return entity.SerializePrivate(b, nil) })
+	writeArmored(pubPath, openpgp.PublicKeyType, func(b *bytes.Buffer) error { return entity.Serialize(b) })
+
+	if err := (&gpgBackend{option: BackendOption{KeyPath: privPath}}).Sign(imageRef, nil); err != nil {
+		t.Fatal(err)
+	}
+	verified, signers, err := (&gpgBackend{option: BackendOption{KeyPath: pubPath}}).Verify(imageRef)
+	if err != nil || !verified || len(signers) != 1 || signers[0] != "signer@example.com" {
+		t.Fatalf("expected a verified gpg signature, got %v %v %v", verified, signers, err)
+	}
+
+	revocation := &RevocationList{Spec: RevocationListSpec{Revocations: []Revocation{{KeyFingerprints: []string{gpgFingerprint(entity)}}}}}
+	checker := &signatureChecker{Revocation: revocation}
+	verified, _, err = (&gpgBackend{option: BackendOption{KeyPath: pubPath, checker: checker}}).Verify(imageRef)
+	if _, ok := err.(*RevokedError); verified || !ok {
+		t.Errorf("expected a signature of the revoked gpg key to be rejected, got %v %v", verified, err)
+	}
+}
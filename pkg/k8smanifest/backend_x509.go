@@ -0,0 +1,189 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/pkg/cosign"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+)
+
+// x509Backend signs with a private key and a certificate issued by your own CA,
+// and verifies the certificate chain with the configured root certificates.
+type x509Backend struct {
+	option BackendOption
+}
+
+func (b *x509Backend) Sign(imageRef string, annotations map[string]interface{}) error {
+	if b.option.KeyPath == "" || b.option.CertPath == "" {
+		return errors.New("a private key and a certificate are required for x509 backend")
+	}
+	keyPEM, err := ioutil.ReadFile(b.option.KeyPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read private key")
+	}
+	signer, err := loadPrivateKeyPEM(keyPEM)
+	if err != nil {
+		return err
+	}
+	certPEM, err := ioutil.ReadFile(b.option.CertPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read certificate")
+	}
+	chainPEM := []byte{}
+	if b.option.ChainPath != "" {
+		chainPEM, err = ioutil.ReadFile(b.option.ChainPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to read certificate chain")
+		}
+	}
+	return signAndUploadPayload(imageRef, annotations, func(payload []byte) ([]byte, string, string, error) {
+		sig, err := signWithPrivateKey(signer, payload)
+		return sig, string(certPEM), string(chainPEM), err
+	})
+}
+
+func (b *x509Backend) Verify(imageRef string) (bool, []string, error) {
+	rootsPEM, err := ioutil.ReadFile(b.option.RootsPath)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to read root certificates")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootsPEM) {
+		return false, nil, errors.New("no root certificates are found")
+	}
+	keyUsages, err := parseExtKeyUsages(b.option.KeyUsages)
+	if err != nil {
+		return false, nil, err
+	}
+	return verifyFetchedSignatures(imageRef, func(sp cosign.SignedPayload, sig []byte) (string, string, error) {
+		if sp.Cert == nil {
+			return "", "", errors.New("signature has no certificate")
+		}
+		err := verifyCertificateChain(sp.Cert, sp.Chain, roots, keyUsages)
+		if err != nil {
+			return "", "", errors.Wrap(err, "failed to verify certificate chain")
+		}
+		err = verifyWithPublicKey(sp.Cert.PublicKey, sp.Payload, sig)
+		if err != nil {
+			return "", "", err
+		}
+		return k8ssigutil.GetNameInfoFromCert(sp.Cert), "", nil
+	}, b.option.checker)
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+}
+
+// return extended key usages of the names. code signing is required by default,
+// so that a certificate for e.g. TLS from the same CA cannot sign manifests
+func parseExtKeyUsages(names []string) ([]x509.ExtKeyUsage, error) {
+	if len(names) == 0 {
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, nil
+	}
+	usages := []x509.ExtKeyUsage{}
+	for _, name := range names {
+		usage, ok := extKeyUsageNames[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown key usage `%s`", name))
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// verify a signing certificate with the roots and the chain in the signature. the certificate must have one of the key usages
+func verifyCertificateChain(cert *x509.Certificate, chain []*x509.Certificate, roots *x509.CertPool, keyUsages []x509.ExtKeyUsage) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     keyUsages,
+	})
+	return err
+}
+
+func loadPrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to decode private key PEM")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported private key format `%s`", block.Type))
+}
+
+func signWithPrivateKey(signer crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func verifyWithPublicKey(pubKey crypto.PublicKey, payload, sig []byte) error {
+	digest := sha256.Sum256(payload)
+	switch k := pubKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.Wrap(err, "invalid RSA signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid ed25519 signature")
+		}
+	default:
+		return errors.New(fmt.Sprintf("unsupported public key type %T", pubKey))
+	}
+	return nil
+}
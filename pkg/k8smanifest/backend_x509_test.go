@@ -0,0 +1,110 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestX509SignAndVerifyPayload(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"sha256:0123"}}}`)
+
+	for _, key := range []crypto.Signer{ecKey, rsaKey, edKey} {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		signer, err := loadPrivateKeyPEM(keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := signWithPrivateKey(signer, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyWithPublicKey(key.Public(), payload, sig); err != nil {
+			t.Errorf("%T: expected a valid signature, but got %s", key, err.Error())
+		}
+		if err := verifyWithPublicKey(key.Public(), []byte("tampered"), sig); err == nil {
+			t.Errorf("%T: expected an invalid signature for a tampered payload", key)
+		}
+	}
+}
+
+func TestX509CertificateKeyUsages(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	caCert, _ := x509.ParseCertificate(caDER)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) *x509.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "signer"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, _ := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+	codeSigningCert := issue(2, x509.ExtKeyUsageCodeSigning)
+	serverCert := issue(3, x509.ExtKeyUsageServerAuth)
+
+	defaultUsages, _ := parseExtKeyUsages(nil)
+	if err := verifyCertificateChain(codeSigningCert, nil, roots, defaultUsages); err != nil {
+		t.Errorf("expected a code signing certificate to be verified, but got %s", err.Error())
+	}
+	if err := verifyCertificateChain(serverCert, nil, roots, defaultUsages); err == nil {
+		t.Errorf("expected a TLS server certificate to be rejected by default")
+	}
+	serverUsages, _ := parseExtKeyUsages([]string{"serverAuth"})
+	if err := verifyCertificateChain(serverCert, nil, roots, serverUsages); err != nil {
+		t.Errorf("expected a TLS server certificate to be verified with `serverAuth`, but got %s", err.Error())
+	}
+	if _, err := parseExtKeyUsages([]string{"codesigning"}); err == nil {
+		t.Errorf("expected an unknown key usage to be an error")
+	}
+}
@@ -0,0 +1,142 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+)
+
+const bundleCacheName = "bundle"
+
+// BundleCacheTTL is how long YAML manifests pulled from a bundle image are reused.
+// The cache is keyed by the image digest, so a tag pushed again is pulled again. Setting 0 disables the cache.
+var BundleCacheTTL = 1 * time.Minute
+
+// BundleCacheSize is the max number of bundles in the cache. The least recently used one is evicted when it is full.
+var BundleCacheSize = 64
+
+type bundleCacheEntry struct {
+	yamls    []byte
+	expires  time.Time
+	lastUsed time.Time
+}
+
+var bundleCache = &manifestCache{entries: map[string]*bundleCacheEntry{}}
+
+type manifestCache struct {
+	sync.Mutex
+	entries map[string]*bundleCacheEntry
+}
+
+func (c *manifestCache) get(key string, now time.Time) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	entry.lastUsed = now
+	return entry.yamls, true
+}
+
+func (c *manifestCache) put(key string, yamls []byte, ttl time.Duration, size int, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for len(c.entries) >= size && len(c.entries) > 0 {
+		oldestKey := ""
+		var oldest time.Time
+		for k, entry := range c.entries {
+			if oldestKey == "" || entry.lastUsed.Before(oldest) {
+				oldestKey, oldest = k, entry.lastUsed
+			}
+		}
+		delete(c.entries, oldestKey)
+	}
+	c.entries[key] = &bundleCacheEntry{yamls: yamls, expires: now.Add(ttl), lastUsed: now}
+}
+
+// get concatenated YAML manifests in a bundle image, pulling it only when no valid cache is found
+func getManifestsInImage(imageRef string) ([]byte, error) {
+	cacheEnabled := BundleCacheTTL > 0 && BundleCacheSize > 0
+	pullRef := imageRef
+	if cacheEnabled {
+		// a tag is resolved into a digest every time, and the cache is keyed by the digest
+		digestRef, err := bundleDigestRef(imageRef)
+		if err != nil {
+			return nil, err
+		}
+		if yamls, found := bundleCache.get(digestRef, time.Now()); found {
+			recordCacheLookup(bundleCacheName, true)
+			return yamls, nil
+		}
+		recordCacheLookup(bundleCacheName, false)
+		pullRef = digestRef
+	}
+
+	start := time.Now()
+	image, err := k8ssigutil.PullImage(pullRef)
+	if err != nil {
+		return nil, err
+	}
+	concatYAMLFromImage, err := k8ssigutil.GenerateConcatYAMLsFromImage(image)
+	if err != nil {
+		return nil, err
+	}
+	observeStageDuration(StageBundlePull, start)
+
+	if cacheEnabled {
+		bundleCache.put(pullRef, concatYAMLFromImage, BundleCacheTTL, BundleCacheSize, time.Now())
+	}
+	return concatYAMLFromImage, nil
+}
+
+// return an image ref pinned by digest; a ref by tag is resolved with the registry.
+// the repository is kept as it is written so that a registry mirror is applied in the same way.
+func bundleDigestRef(imageRef string) (string, error) {
+	if _, err := name.NewDigest(imageRef); err == nil {
+		return imageRef, nil
+	}
+	if _, err := name.ParseReference(imageRef); err != nil {
+		return "", err
+	}
+	digest, err := k8ssigutil.GetImageDigest(imageRef)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get digest of bundle image")
+	}
+	repo := imageRef
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	return fmt.Sprintf("%s@%s", repo, digest), nil
+}
@@ -0,0 +1,49 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManifestCache(t *testing.T) {
+	c := &manifestCache{entries: map[string]*bundleCacheEntry{}}
+	now := time.Now()
+	c.put("bundle@sha256:1", []byte("a"), time.Minute, 2, now)
+	c.put("bundle@sha256:2", []byte("b"), time.Minute, 2, now.Add(time.Second))
+
+	// the first one is used recently, so the second one is evicted
+	if _, found := c.get("bundle@sha256:1", now.Add(2*time.Second)); !found {
+		t.Errorf("a cached bundle is not found")
+	}
+	c.put("bundle@sha256:3", []byte("c"), time.Minute, 2, now.Add(3*time.Second))
+	if len(c.entries) != 2 {
+		t.Errorf("cache size must be bounded, but %v entries", len(c.entries))
+	}
+	if _, found := c.get("bundle@sha256:2", now.Add(4*time.Second)); found {
+		t.Errorf("the least recently used bundle must be evicted")
+	}
+
+	// expired entries are not returned and removed
+	if _, found := c.get("bundle@sha256:3", now.Add(2*time.Minute)); found {
+		t.Errorf("an expired bundle must not be returned")
+	}
+	if _, found := c.entries["bundle@sha256:3"]; found {
+		t.Errorf("an expired bundle must be removed")
+	}
+}
@@ -0,0 +1,281 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	kubeutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/kubeutil"
+)
+
+const (
+	// a config in a ConfigMap, e.g. `k8s://<namespace>/<name>[/<key>]`
+	configMapConfigRefPrefix = "k8s://"
+	// configs in an OCI artifact, e.g. `oci://<image>`
+	ociConfigRefPrefix = "oci://"
+	// a key in data of a config ConfigMap (same as the admission controller)
+	defaultConfigMapConfigKey = "config.yaml"
+)
+
+const (
+	SignersMergeUnion     = "union"
+	SignersMergeIntersect = "intersect"
+)
+
+// ConfigMergeOption is how a config is merged onto the configs loaded before it.
+// Lists are concatenated and the other fields set in the config override the previous ones.
+type ConfigMergeOption struct {
+	// `intersect` (default) or `union` with the signers of the previous configs.
+	// a later config can only narrow down the signers unless `union` is set explicitly
+	Signers string `json:"signers,omitempty"`
+	// top-level lists (e.g. `ignoreFields`) which replace the previous ones instead of being concatenated
+	Replace []string `json:"replace,omitempty"`
+}
+
+// a config and where it is loaded from
+type verifyConfigSource struct {
+	name string
+	data []byte
+}
+
+// LoadVerifyConfigs loads configs from files, directories, ConfigMaps and OCI artifacts, and merges them in order.
+// Files in a directory are loaded in the order of their names.
+// The signature of an OCI artifact is verified with keyPath before its configs are used.
+func LoadVerifyConfigs(refs []string, keyPath string) (*VerifyOption, error) {
+	sources := []verifyConfigSource{}
+	for _, ref := range refs {
+		tmpSources, err := loadVerifyConfigSources(ref, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, tmpSources...)
+	}
+	return mergeVerifyConfigSources(sources)
+}
+
+// MergeVerifyConfigs parses config YAMLs and merges them in order
+func MergeVerifyConfigs(cfgs [][]byte) (*VerifyOption, error) {
+	sources := []verifyConfigSource{}
+	for i, cfg := range cfgs {
+		sources = append(sources, verifyConfigSource{name: fmt.Sprintf("configs[%d]", i), data: cfg})
+	}
+	return mergeVerifyConfigSources(sources)
+}
+
+func loadVerifyConfigSources(ref, keyPath string) ([]verifyConfigSource, error) {
+	switch {
+	case strings.HasPrefix(ref, configMapConfigRefPrefix):
+		cfgBytes, err := loadVerifyConfigInConfigMap(strings.TrimPrefix(ref, configMapConfigRefPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return []verifyConfigSource{{name: ref, data: cfgBytes}}, nil
+	case strings.HasPrefix(ref, ociConfigRefPrefix):
+		concatYAMLs, err := loadVerifyConfigsInImage(strings.TrimPrefix(ref, ociConfigRefPrefix), keyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to get config image `%s`", ref))
+		}
+		sources := []verifyConfigSource{}
+		for i, cfgBytes := range k8ssigutil.SplitConcatYAMLs(concatYAMLs) {
+			sources = append(sources, verifyConfigSource{name: fmt.Sprintf("%s[%d]", ref, i), data: cfgBytes})
+		}
+		if len(sources) == 0 {
+			return nil, errors.New(fmt.Sprintf("no config is found in image `%s`", ref))
+		}
+		return sources, nil
+	}
+	info, err := os.Stat(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		cfgBytes, err := ioutil.ReadFile(ref)
+		if err != nil {
+			return nil, err
+		}
+		return []verifyConfigSource{{name: ref, data: cfgBytes}}, nil
+	}
+	files, err := ioutil.ReadDir(ref)
+	if err != nil {
+		return nil, err
+	}
+	sources := []verifyConfigSource{}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		fpath := filepath.Join(ref, f.Name())
+		cfgBytes, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, verifyConfigSource{name: fpath, data: cfgBytes})
+	}
+	if len(sources) == 0 {
+		return nil, errors.New(fmt.Sprintf("no config file is found in directory `%s`", ref))
+	}
+	return sources, nil
+}
+
+// verify the signature of a config image in the same way as a bundle image, and return the configs in it.
+// the image is pulled by the digest which is verified
+func loadVerifyConfigsInImage(imageRef, keyPath string) ([]byte, error) {
+	if keyPath == "" {
+		return nil, errors.New("a key is required to verify a config image")
+	}
+	digestRef, err := bundleDigestRef(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	verified, _, err := verifyImageSignatures(digestRef, keyPath, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to verify config image")
+	}
+	if !verified {
+		return nil, errors.New("config image is not verified")
+	}
+	return getManifestsInImage(digestRef)
+}
+
+func loadVerifyConfigInConfigMap(ref string) ([]byte, error) {
+	parts := strings.Split(ref, "/")
+	if (len(parts) != 2 && len(parts) != 3) || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("config configmap must be referred as `k8s://<namespace>/<name>[/<key>]`")
+	}
+	key := defaultConfigMapConfigKey
+	if len(parts) == 3 && parts[2] != "" {
+		key = parts[2]
+	}
+	cm, err := kubeutil.GetResource("v1", "ConfigMap", parts[0], parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get config configmap")
+	}
+	data, _ := cm.Object["data"].(map[string]interface{})
+	cfgStr, ok := data[key].(string)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("`%s` is not found in configmap `%s/%s`", key, parts[0], parts[1]))
+	}
+	return []byte(cfgStr), nil
+}
+
+func mergeVerifyConfigSources(sources []verifyConfigSource) (*VerifyOption, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no config is given")
+	}
+	var merged *VerifyOption
+	for _, s := range sources {
+		option, err := ParseVerifyConfig(s.data)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid config `%s`", s.name))
+		}
+		if merged == nil {
+			merged = option
+			continue
+		}
+		// only the fields written in the config override the previous ones
+		var keys map[string]interface{}
+		_ = yaml.Unmarshal(s.data, &keys)
+		if err = merged.merge(option, keys); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to merge config `%s`", s.name))
+		}
+	}
+	merged.Merge = nil
+	if err := merged.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid merged config")
+	}
+	return merged, nil
+}
+
+// merge an overlay onto the option; lists are concatenated and the other fields in keys are overwritten
+func (vo *VerifyOption) merge(overlay *VerifyOption, keys map[string]interface{}) error {
+	mo := overlay.Merge
+	if mo == nil {
+		mo = &ConfigMergeOption{}
+	}
+	base := reflect.ValueOf(vo).Elem()
+	over := reflect.ValueOf(overlay).Elem()
+	for i := 0; i < base.NumField(); i++ {
+		name := strings.Split(base.Type().Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := keys[name]; !ok || name == "merge" {
+			continue
+		}
+		switch {
+		case containsString(mo.Replace, name):
+			base.Field(i).Set(over.Field(i))
+		case name == "signers":
+			signers, err := mergeSigners(vo.Signers, overlay.Signers, mo.Signers)
+			if err != nil {
+				return err
+			}
+			vo.Signers = signers
+		case base.Field(i).Kind() == reflect.Slice:
+			base.Field(i).Set(reflect.AppendSlice(base.Field(i), over.Field(i)))
+		default:
+			base.Field(i).Set(over.Field(i))
+		}
+	}
+	return nil
+}
+
+// an empty list means signers are not configured yet, so the other list is used as it is.
+// by intersection, a signer (or a pattern) is kept if the other list matches it.
+func mergeSigners(base, overlay SignerList, mode string) (SignerList, error) {
+	if len(base) == 0 {
+		return overlay, nil
+	}
+	if len(overlay) == 0 {
+		return base, nil
+	}
+	candidates := SignerList{}
+	switch mode {
+	case SignersMergeUnion:
+		candidates = append(append(candidates, base...), overlay...)
+	case "", SignersMergeIntersect:
+		for _, s := range overlay {
+			if base.Match(s) {
+				candidates = append(candidates, s)
+			}
+		}
+		for _, s := range base {
+			if overlay.Match(s) {
+				candidates = append(candidates, s)
+			}
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown merge of signers `%s`", mode))
+	}
+	signers := SignerList{}
+	for _, s := range candidates {
+		if !containsString(signers, s) {
+			signers = append(signers, s)
+		}
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no signer is allowed by both of the configs")
+	}
+	return signers, nil
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testBaseConfig = `
+skipObjects:
+- kind: Secret
+signers:
+- alice@example.com
+- ci-*
+requireBundleDigest: true
+backend:
+  type: cosign
+`
+
+func TestMergeVerifyConfigs(t *testing.T) {
+	cases := []struct {
+		name          string
+		overlay       string
+		signers       SignerList
+		skipKinds     []string
+		requireDigest bool
+		errMsg        string
+	}{
+		{
+			name:          "lists are concatenated",
+			overlay:       "skipObjects:\n- kind: ConfigMap\n",
+			signers:       SignerList{"alice@example.com", "ci-*"},
+			skipKinds:     []string{"Secret", "ConfigMap"},
+			requireDigest: true,
+		},
+		{
+			name:          "explicit overrides win",
+			overlay:       "requireBundleDigest: false\n",
+			signers:       SignerList{"alice@example.com", "ci-*"},
+			skipKinds:     []string{"Secret"},
+			requireDigest: false,
+		},
+		{
+			name:          "replaced list",
+			overlay:       "merge:\n  replace:\n  - skipObjects\nskipObjects:\n- kind: ConfigMap\n",
+			signers:       SignerList{"alice@example.com", "ci-*"},
+			skipKinds:     []string{"ConfigMap"},
+			requireDigest: true,
+		},
+		{
+			name:          "union of signers",
+			overlay:       "merge:\n  signers: union\nsigners:\n- bob@sample.com\n",
+			signers:       SignerList{"alice@example.com", "ci-*", "bob@sample.com"},
+			skipKinds:     []string{"Secret"},
+			requireDigest: true,
+		},
+		{
+			name:          "intersection of signers",
+			overlay:       "merge:\n  signers: intersect\nsigners:\n- alice@example.com\n- ci-release\n- bob@sample.com\n",
+			signers:       SignerList{"alice@example.com", "ci-release"},
+			skipKinds:     []string{"Secret"},
+			requireDigest: true,
+		},
+		{
+			name:          "intersection of signers by default",
+			overlay:       "signers:\n- alice@example.com\n- bob@sample.com\n",
+			signers:       SignerList{"alice@example.com"},
+			skipKinds:     []string{"Secret"},
+			requireDigest: true,
+		},
+		{
+			name:    "empty intersection of signers",
+			overlay: "signers:\n- bob@sample.com\n",
+			errMsg:  "no signer is allowed by both of the configs",
+		},
+		{
+			name:    "invalid overlay",
+			overlay: "merge:\n  replace:\n  - backend\n",
+			errMsg:  "invalid config `configs[1]`",
+		},
+	}
+	for _, c := range cases {
+		vo, err := MergeVerifyConfigs([][]byte{[]byte(testBaseConfig), []byte(c.overlay)})
+		if c.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), c.errMsg) {
+				t.Errorf("%s: expected error `%s`, got %v", c.name, c.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err.Error())
+			continue
+		}
+		skipKinds := []string{}
+		for _, o := range vo.SkipObjects {
+			skipKinds = append(skipKinds, o.Kind)
+		}
+		if !reflect.DeepEqual(vo.Signers, c.signers) || !reflect.DeepEqual(skipKinds, c.skipKinds) || vo.RequireBundleDigest != c.requireDigest {
+			t.Errorf("%s: unexpected merged config; signers: %v, skipObjects: %v, requireBundleDigest: %v", c.name, vo.Signers, skipKinds, vo.RequireBundleDigest)
+		}
+		if vo.Backend == nil || vo.Backend.Type != "cosign" || vo.Merge != nil {
+			t.Errorf("%s: unexpected backend or merge option in merged config", c.name)
+		}
+	}
+}
+
+func TestLoadVerifyConfigsInDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"00-base.yaml": testBaseConfig,
+		"10-team.yml":  "skipObjects:\n- kind: ConfigMap\n",
+		"README.md":    "not a config",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overlay := filepath.Join(t.TempDir(), "ns.yaml")
+	if err := ioutil.WriteFile(overlay, []byte("skipObjects:\n- kind: Role\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vo, err := LoadVerifyConfigs([]string{dir, overlay}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vo.SkipObjects) != 3 || vo.SkipObjects[1].Kind != "ConfigMap" || vo.SkipObjects[2].Kind != "Role" {
+		t.Errorf("unexpected skipObjects: %v", vo.SkipObjects)
+	}
+}
+
+func TestLoadVerifyConfigsInImageRequiresKey(t *testing.T) {
+	_, err := LoadVerifyConfigs([]string{"oci://sample-registry/org-config@sha256:0123"}, "")
+	if err == nil || !strings.Contains(err.Error(), "a key is required") {
+		t.Errorf("expected an error for a config image without a key, got %v", err)
+	}
+}
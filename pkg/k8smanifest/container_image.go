@@ -0,0 +1,158 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// paths of pod spec in the built-in workload kinds
+var podSpecPaths = map[string]string{
+	"Pod":         "spec",
+	"Deployment":  "spec.template.spec",
+	"ReplicaSet":  "spec.template.spec",
+	"StatefulSet": "spec.template.spec",
+	"DaemonSet":   "spec.template.spec",
+	"Job":         "spec.template.spec",
+	"CronJob":     "spec.jobTemplate.spec.template.spec",
+}
+
+var containerImageKeysInPodSpec = []string{
+	"containers[].image",
+	"initContainers[].image",
+	"ephemeralContainers[].image",
+}
+
+// ContainerImageVerifyOption is a policy for the second stage verification of container images referenced by a manifest.
+// Images are verified with a separate key and signers from the manifest ones.
+type ContainerImageVerifyOption struct {
+	Enabled       bool       `json:"enabled,omitempty"`
+	KeyPath       string     `json:"key,omitempty"`
+	Signers       SignerList `json:"signers,omitempty"`
+	RequireDigest bool       `json:"requireDigest,omitempty"`
+	// images matched with these patterns are not verified
+	SkipImages []string `json:"skipImages,omitempty"`
+	// additional image fields for CRDs (e.g. `spec.image`, `spec.containers[].image`)
+	ImageFields ObjectFieldBindingList `json:"imageFields,omitempty"`
+}
+
+type ContainerImageVerifyResult struct {
+	Image    string `json:"image"`
+	Verified bool   `json:"verified"`
+	Signer   string `json:"signer,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+func (o *ContainerImageVerifyOption) enabled() bool {
+	return o != nil && o.Enabled
+}
+
+// extract all container images in pod specs and configured image fields of the object
+func ExtractContainerImages(obj unstructured.Unstructured, imageFields ObjectFieldBindingList) []string {
+	objBytes, _ := json.Marshal(obj.Object)
+	objNode, err := mapnode.NewFromBytes(objBytes)
+	if err != nil {
+		return []string{}
+	}
+	keys := []string{}
+	if podSpecPath, ok := podSpecPaths[obj.GetKind()]; ok {
+		for _, k := range containerImageKeysInPodSpec {
+			keys = append(keys, fmt.Sprintf("%s.%s", podSpecPath, k))
+		}
+	}
+	if ok, fields := imageFields.Match(obj); ok {
+		keys = append(keys, fields...)
+	}
+
+	found := map[string]bool{}
+	images := []string{}
+	for _, k := range keys {
+		for _, n := range objNode.FindNodes(k) {
+			if n == nil || !n.IsValue() || n.Value == nil {
+				continue
+			}
+			img, ok := n.Value.Value.(string)
+			if !ok || img == "" || found[img] {
+				continue
+			}
+			found[img] = true
+			images = append(images, img)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// signatures of images are checked with the signing time policy and the revocation list of the verify option as well as bundles
+func verifyContainerImages(obj unstructured.Unstructured, vo *VerifyOption) (bool, []ContainerImageVerifyResult) {
+	opt := vo.ContainerImages
+	images := ExtractContainerImages(obj, opt.ImageFields)
+	bo, boErr := vo.backendOption()
+	allVerified := true
+	results := []ContainerImageVerifyResult{}
+	for _, img := range images {
+		if k8ssigutil.MatchWithPatternArray(img, opt.SkipImages) {
+			continue
+		}
+		// fail closed if the checkers are not available
+		if boErr != nil {
+			allVerified = false
+			results = append(results, ContainerImageVerifyResult{Image: img, Message: boErr.Error()})
+			continue
+		}
+		r := verifyContainerImage(img, opt, bo.imageBackendOption())
+		if !r.Verified {
+			allVerified = false
+		}
+		results = append(results, r)
+	}
+	return allVerified, results
+}
+
+func verifyContainerImage(img string, opt *ContainerImageVerifyOption, bo *BackendOption) ContainerImageVerifyResult {
+	result := ContainerImageVerifyResult{Image: img}
+	ref, err := name.ParseReference(img)
+	if err != nil {
+		result.Message = fmt.Sprintf("failed to parse image reference; %s", err.Error())
+		return result
+	}
+	if _, isDigest := ref.(name.Digest); opt.RequireDigest && !isDigest {
+		result.Message = "image must be referenced by digest"
+		return result
+	}
+	verified, signerNames, err := verifyImageSignatures(img, opt.KeyPath, bo)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Signer = firstSigner(signerNames)
+	if verified && !opt.Signers.MatchAny(signerNames) {
+		result.Message = fmt.Sprintf("signer config not matched, this is signed by %s", strings.Join(signerNames, ", "))
+		return result
+	}
+	result.Verified = verified
+	return result
+}
@@ -0,0 +1,73 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testCronJob = `
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: sample-job
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          initContainers:
+          - name: init
+            image: busybox:1.33
+          containers:
+          - name: job
+            image: sample-job@sha256:4bcff63911fcb4448bd4fdacec207030997caf25e9bea4045fa6c8c44de311d1
+          - name: sidecar
+            image: busybox:1.33
+`
+
+const testCustomResource = `
+apiVersion: example.com/v1
+kind: SampleApp
+metadata:
+  name: sample-app
+spec:
+  image: sample-app:v1
+`
+
+func TestExtractContainerImages(t *testing.T) {
+	cronjob := loadTestObject(t, testCronJob)
+	images := ExtractContainerImages(cronjob, nil)
+	expected := []string{
+		"busybox:1.33",
+		"sample-job@sha256:4bcff63911fcb4448bd4fdacec207030997caf25e9bea4045fa6c8c44de311d1",
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected %v, but got %v", expected, images)
+	}
+
+	cr := loadTestObject(t, testCustomResource)
+	imageFields := ObjectFieldBindingList{
+		{Objects: ObjectReferenceList{{Kind: "SampleApp"}}, Fields: []string{"spec.image"}},
+	}
+	images = ExtractContainerImages(cr, imageFields)
+	expected = []string{"sample-app:v1"}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected %v, but got %v", expected, images)
+	}
+}
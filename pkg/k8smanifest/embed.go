@@ -0,0 +1,203 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// lineEdit replaces a line or inserts lines before a line of a YAML file (0-based)
+type lineEdit struct {
+	line    int
+	replace bool
+	lines   []string
+}
+
+// embed annotations into all documents in a YAML file without re-encoding it.
+// positions of metadata and annotations are found in the YAML AST, and only annotation lines are added
+// (or replaced if the keys already exist), so that comments, key order, quoting and document separators are kept.
+// an error is returned if a document cannot be edited in this way (e.g. flow style metadata).
+func embedAnnotationPreservingFormat(yamlBytes []byte, annotationMap map[string]interface{}) ([]byte, error) {
+	lines := strings.Split(string(yamlBytes), "\n")
+	dec := yamlv3.NewDecoder(bytes.NewReader(yamlBytes))
+	edits := []lineEdit{}
+	for {
+		var doc yamlv3.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		docEdits, err := annotationLineEdits(doc.Content[0], lines, annotationMap)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, docEdits...)
+	}
+
+	// apply from the bottom so that line numbers of the remaining edits are not changed
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].line != edits[j].line {
+			return edits[i].line > edits[j].line
+		}
+		return edits[i].replace && !edits[j].replace
+	})
+	lineEnd := ""
+	if bytes.Contains(yamlBytes, []byte("\r\n")) {
+		lineEnd = "\r"
+	}
+	for _, e := range edits {
+		newLines := []string{}
+		for _, l := range e.lines {
+			newLines = append(newLines, l+lineEnd)
+		}
+		tail := lines[e.line:]
+		if e.replace {
+			tail = lines[e.line+1:]
+		}
+		lines = append(append(append([]string{}, lines[:e.line]...), newLines...), tail...)
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func annotationLineEdits(root *yamlv3.Node, lines []string, annotationMap map[string]interface{}) ([]lineEdit, error) {
+	if root.Kind != yamlv3.MappingNode || root.Style&yamlv3.FlowStyle != 0 {
+		return nil, errors.New("a document is not a block mapping")
+	}
+	metaKey, meta := mappingValue(root, "metadata")
+	if meta == nil || meta.Kind != yamlv3.MappingNode || meta.Style&yamlv3.FlowStyle != 0 || len(meta.Content) == 0 {
+		return nil, errors.New("metadata is not a block mapping")
+	}
+	indentStep := meta.Content[0].Column - metaKey.Column
+
+	keys := []string{}
+	for k := range annotationMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	annoKey, anno := mappingValue(meta, "annotations")
+	switch {
+	case anno == nil:
+		// add `annotations:` at the top of metadata
+		indent := meta.Content[0].Column - 1
+		entries, err := annotationEntryLines(keys, annotationMap, indent+indentStep)
+		if err != nil {
+			return nil, err
+		}
+		newLines := append([]string{strings.Repeat(" ", indent) + "annotations:"}, entries...)
+		return []lineEdit{{line: lineBeforeComment(meta.Content[0]), lines: newLines}}, nil
+	case anno.Kind == yamlv3.MappingNode && anno.Style&yamlv3.FlowStyle == 0 && len(anno.Content) > 0:
+		edits := []lineEdit{}
+		remaining := []string{}
+		for _, k := range keys {
+			existingKey, existing := mappingValue(anno, k)
+			if existing == nil {
+				remaining = append(remaining, k)
+				continue
+			}
+			// an existing annotation is replaced only if it is in a single line
+			if existing.Kind != yamlv3.ScalarNode || existing.Style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) != 0 || existing.Line != existingKey.Line {
+				return nil, errors.New("an existing annotation is not a single line scalar")
+			}
+			entry, err := annotationEntryLines([]string{k}, annotationMap, existingKey.Column-1)
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, lineEdit{line: existingKey.Line - 1, replace: true, lines: entry})
+		}
+		if len(remaining) > 0 {
+			entries, err := annotationEntryLines(remaining, annotationMap, anno.Content[0].Column-1)
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, lineEdit{line: lineBeforeComment(anno.Content[0]), lines: entries})
+		}
+		return edits, nil
+	case isEmptyValue(anno) && anno.Line == annoKey.Line:
+		// `annotations:`, `annotations: {}` or `annotations: null`
+		indent := annoKey.Column - 1
+		entries, err := annotationEntryLines(keys, annotationMap, indent+indentStep)
+		if err != nil {
+			return nil, err
+		}
+		newLines := append([]string{lines[annoKey.Line-1][:indent] + "annotations:"}, entries...)
+		return []lineEdit{{line: annoKey.Line - 1, replace: true, lines: newLines}}, nil
+	}
+	return nil, errors.New("annotations is not a block mapping")
+}
+
+// return a key node and a value node in a mapping node
+func mappingValue(mapping *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func isEmptyValue(node *yamlv3.Node) bool {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		return len(node.Content) == 0
+	case yamlv3.ScalarNode:
+		return node.Tag == "!!null"
+	}
+	return false
+}
+
+// return a line index before head comment lines of a key, so that the comment stays with the key
+func lineBeforeComment(key *yamlv3.Node) int {
+	line := key.Line - 1
+	if key.HeadComment != "" {
+		line -= strings.Count(key.HeadComment, "\n") + 1
+	}
+	return line
+}
+
+// return `key: value` lines with indent. a value is quoted if necessary
+func annotationEntryLines(keys []string, annotationMap map[string]interface{}, indent int) ([]string, error) {
+	lines := []string{}
+	for _, k := range keys {
+		entry := &yamlv3.Node{Kind: yamlv3.MappingNode}
+		valNode := &yamlv3.Node{}
+		if err := valNode.Encode(annotationMap[k]); err != nil {
+			return nil, err
+		}
+		entry.Content = []*yamlv3.Node{{Kind: yamlv3.ScalarNode, Value: k}, valNode}
+		entryBytes, err := yamlv3.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range strings.Split(strings.TrimRight(string(entryBytes), "\n"), "\n") {
+			lines = append(lines, strings.Repeat(" ", indent)+l)
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,116 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"testing"
+)
+
+func TestEmbedAnnotationPreservingFormat(t *testing.T) {
+	annotations := map[string]interface{}{ImageRefAnnotationKey: "bundle-bar:dev@sha256:0123"}
+	testcases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "no annotations",
+			input: `# sample config
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  # the name is referred by app
+  name: sample-cm
+data:
+  script: |
+    echo "hello"
+  "quoted": 'value'
+`,
+			expected: `# sample config
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  annotations:
+    cosign.sigstore.dev/imageRef: bundle-bar:dev@sha256:0123
+  # the name is referred by app
+  name: sample-cm
+data:
+  script: |
+    echo "hello"
+  "quoted": 'value'
+`,
+		},
+		{
+			name: "multiple documents with existing annotations",
+			input: `---
+kind: ConfigMap
+metadata:
+    name: cm1
+    annotations:
+        owner: team-a  # comment
+---
+kind: ConfigMap
+metadata:
+    annotations:
+        cosign.sigstore.dev/imageRef: bundle-bar:old
+    name: cm2
+`,
+			expected: `---
+kind: ConfigMap
+metadata:
+    name: cm1
+    annotations:
+        cosign.sigstore.dev/imageRef: bundle-bar:dev@sha256:0123
+        owner: team-a  # comment
+---
+kind: ConfigMap
+metadata:
+    annotations:
+        cosign.sigstore.dev/imageRef: bundle-bar:dev@sha256:0123
+    name: cm2
+`,
+		},
+		{
+			name: "empty annotations",
+			input: `kind: ConfigMap
+metadata:
+  name: cm1
+  annotations: {}
+`,
+			expected: `kind: ConfigMap
+metadata:
+  name: cm1
+  annotations:
+    cosign.sigstore.dev/imageRef: bundle-bar:dev@sha256:0123
+`,
+		},
+	}
+	for _, tc := range testcases {
+		actual, err := embedAnnotationPreservingFormat([]byte(tc.input), annotations)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err.Error())
+			continue
+		}
+		if string(actual) != tc.expected {
+			t.Errorf("%s: unexpected result:\n%s", tc.name, string(actual))
+		}
+	}
+
+	if _, err := embedAnnotationPreservingFormat([]byte("{kind: ConfigMap, metadata: {name: cm1}}\n"), annotations); err == nil {
+		t.Errorf("flow style metadata must be an error")
+	}
+}
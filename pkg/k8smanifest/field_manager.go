@@ -0,0 +1,200 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// FieldManagerList is an allowlist of field managers in `metadata.managedFields` (e.g. `kube-controller-manager`).
+// A diff is accepted only when the field in the resource is owned by the allowlisted managers and no other manager.
+//
+// Note that a manager name is given by a client (`fieldManager` of a request), so any client can claim to be
+// an allowlisted manager. For admission requests, use UnchangedIn() to trust managedFields only when the
+// allowlisted managers are not written by the request itself.
+type FieldManagerList []string
+
+// managed fields of a field manager
+type fieldManagerEntry struct {
+	manager string
+	fields  map[string]interface{}
+}
+
+// filter out diffs whose fields are owned only by the allowlisted field managers.
+// a removed field is not filtered out because the resource has no owner of it.
+func (l FieldManagerList) FilterDiff(obj unstructured.Unstructured, diff *mapnode.DiffResult) *mapnode.DiffResult {
+	if diff == nil || len(l) == 0 {
+		return diff
+	}
+	entries := getFieldManagerEntries(obj)
+	if len(entries) == 0 {
+		return diff
+	}
+	items := []mapnode.Difference{}
+	for _, d := range diff.Items {
+		if d.Values["before"] == nil {
+			items = append(items, d)
+			continue
+		}
+		owners := fieldOwners(obj.Object, strings.Split(d.Key, "."), entries)
+		if len(owners) == 0 || !l.matchAll(owners) {
+			items = append(items, d)
+			continue
+		}
+		log.Debugf("diff in `%s` is accepted because it is owned by %s", d.Key, strings.Join(owners, ", "))
+	}
+	return &mapnode.DiffResult{Items: items}
+}
+
+// UnchangedIn checks that managedFields entries of the allowlisted managers in a requested object are the same as
+// the ones in the old object, i.e. the request is not made as one of the allowlisted managers.
+// the entries of the old object were written by earlier requests which have been admitted.
+func (l FieldManagerList) UnchangedIn(obj, oldObj unstructured.Unstructured) bool {
+	return reflect.DeepEqual(l.managedFieldsOf(obj), l.managedFieldsOf(oldObj))
+}
+
+func (l FieldManagerList) managedFieldsOf(obj unstructured.Unstructured) []metav1.ManagedFieldsEntry {
+	entries := []metav1.ManagedFieldsEntry{}
+	for _, mf := range obj.GetManagedFields() {
+		if k8ssigutil.MatchWithPatternArray(mf.Manager, l) {
+			entries = append(entries, mf)
+		}
+	}
+	return entries
+}
+
+func (l FieldManagerList) matchAll(managers []string) bool {
+	for _, m := range managers {
+		if !k8ssigutil.MatchWithPatternArray(m, l) {
+			return false
+		}
+	}
+	return true
+}
+
+func getFieldManagerEntries(obj unstructured.Unstructured) []fieldManagerEntry {
+	entries := []fieldManagerEntry{}
+	for _, mf := range obj.GetManagedFields() {
+		if mf.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(mf.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		entries = append(entries, fieldManagerEntry{manager: mf.Manager, fields: fields})
+	}
+	return entries
+}
+
+// return managers which own the field of the key, e.g. `spec.template.spec.containers.0.image`
+func fieldOwners(obj map[string]interface{}, keys []string, entries []fieldManagerEntry) []string {
+	owners := []string{}
+	found := map[string]bool{}
+	for _, e := range entries {
+		if !fieldOwned(obj, keys, e.fields) || found[e.manager] {
+			continue
+		}
+		found[e.manager] = true
+		owners = append(owners, e.manager)
+	}
+	return owners
+}
+
+// check if the field is in the managed fields (FieldsV1 format) by walking the object and the managed fields together.
+// a leaf of the managed fields (`{}`) owns all of the fields under it.
+func fieldOwned(val interface{}, keys []string, fields map[string]interface{}) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		// a key with dots (e.g. an annotation) is split into multiple keys in a diff key
+		for n := 1; n <= len(keys); n++ {
+			k := strings.Join(keys[:n], ".")
+			child, ok := v[k]
+			if !ok {
+				continue
+			}
+			sub, ok := fields["f:"+k].(map[string]interface{})
+			if !ok {
+				return false
+			}
+			if len(sub) == 0 {
+				return true
+			}
+			return fieldOwned(child, keys[n:], sub)
+		}
+	case []interface{}:
+		i, err := strconv.Atoi(keys[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return false
+		}
+		for fk, fv := range fields {
+			sub, ok := fv.(map[string]interface{})
+			if !ok || !matchListElement(fk, i, v[i]) {
+				continue
+			}
+			if len(sub) == 0 {
+				return true
+			}
+			return fieldOwned(v[i], keys[1:], sub)
+		}
+	}
+	return false
+}
+
+// check if a key of a list element in FieldsV1 (`i:<index>`, `v:<value>` or `k:<key fields>`) points the element
+func matchListElement(fieldKey string, index int, elem interface{}) bool {
+	switch {
+	case strings.HasPrefix(fieldKey, "i:"):
+		return strings.TrimPrefix(fieldKey, "i:") == strconv.Itoa(index)
+	case strings.HasPrefix(fieldKey, "v:"):
+		var value interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(fieldKey, "v:")), &value); err != nil {
+			return false
+		}
+		return fmt.Sprint(value) == fmt.Sprint(elem)
+	case strings.HasPrefix(fieldKey, "k:"):
+		var keyFields map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(fieldKey, "k:")), &keyFields); err != nil {
+			return false
+		}
+		elemMap, ok := elem.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, kv := range keyFields {
+			if fmt.Sprint(elemMap[k]) != fmt.Sprint(kv) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
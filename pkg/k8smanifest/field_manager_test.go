@@ -0,0 +1,108 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"testing"
+
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+const testManagedDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    example.com/owner: team-a
+  managedFields:
+  - manager: kubectl-client-side-apply
+    operation: Update
+    apiVersion: apps/v1
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:metadata:
+        f:annotations:
+          .: {}
+          f:example.com/owner: {}
+  - manager: hpa-controller
+    operation: Update
+    apiVersion: apps/v1
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:spec:
+        f:replicas: {}
+  - manager: kubectl-edit
+    operation: Update
+    apiVersion: apps/v1
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:spec:
+        f:template:
+          f:spec:
+            f:containers:
+              k:{"name":"app"}:
+                f:image: {}
+spec:
+  replicas: 5
+  template:
+    spec:
+      containers:
+      - name: app
+        image: evil:v1
+`
+
+func TestFieldManagerFilterDiff(t *testing.T) {
+	obj := loadTestObject(t, testManagedDeployment)
+	diff := &mapnode.DiffResult{Items: []mapnode.Difference{
+		{Key: "spec.replicas", Values: map[string]interface{}{"before": 5, "after": 2}},
+		{Key: "spec.template.spec.containers.0.image", Values: map[string]interface{}{"before": "evil:v1", "after": "app:v1"}},
+		{Key: "metadata.annotations.example.com/owner", Values: map[string]interface{}{"before": "team-a", "after": "team-b"}},
+		{Key: "spec.paused", Values: map[string]interface{}{"before": nil, "after": true}},
+	}}
+	remaining := FieldManagerList{"hpa-controller", "kubectl-client-side-apply"}.FilterDiff(obj, diff)
+	expected := map[string]bool{"spec.template.spec.containers.0.image": true, "spec.paused": true}
+	if remaining.Size() != len(expected) {
+		t.Errorf("unexpected diffs remain; %s", remaining)
+	}
+	for _, d := range remaining.Items {
+		if !expected[d.Key] {
+			t.Errorf("diff `%s` should be accepted", d.Key)
+		}
+	}
+}
+
+func TestFieldManagerUnchangedIn(t *testing.T) {
+	oldObj := loadTestObject(t, testManagedDeployment)
+	managers := FieldManagerList{"hpa-controller"}
+
+	// a request by another manager keeps the entry of the allowlisted manager
+	obj := loadTestObject(t, testManagedDeployment)
+	obj.SetAnnotations(map[string]string{"example.com/owner": "team-b"})
+	if !managers.UnchangedIn(obj, oldObj) {
+		t.Error("managedFields of the allowlisted manager are not changed")
+	}
+
+	// a client which claims to be the allowlisted manager
+	spoofed := loadTestObject(t, testManagedDeployment)
+	entries := spoofed.GetManagedFields()
+	entries[1].FieldsV1.Raw = []byte(`{"f:spec":{"f:replicas":{},"f:template":{}}}`)
+	spoofed.SetManagedFields(entries)
+	if managers.UnchangedIn(spoofed, oldObj) {
+		t.Error("managedFields written by the request must not be trusted")
+	}
+}
@@ -0,0 +1,186 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+)
+
+const (
+	HelmReleaseNameAnnotationKey      = "meta.helm.sh/release-name"
+	HelmReleaseNamespaceAnnotationKey = "meta.helm.sh/release-namespace"
+	HelmManagedByLabelKey             = "app.kubernetes.io/managed-by"
+	// annotation in the signature payload which records the digest of values files used for rendering
+	HelmValuesDigestAnnotationKey = "k8s-manifest-sigstore/helm-values-digest"
+)
+
+const (
+	helmChartDirName  = "chart"
+	helmValuesDirName = "values"
+)
+
+const defaultHelmReleaseName = "release-name"
+
+// fields which are added by helm on install / upgrade, but not in the output of `helm template`
+var HelmReleaseMaskKeys = []string{
+	fmt.Sprintf("metadata.annotations.\"%s\"", HelmReleaseNameAnnotationKey),
+	fmt.Sprintf("metadata.annotations.\"%s\"", HelmReleaseNamespaceAnnotationKey),
+	fmt.Sprintf("metadata.labels.\"%s\"", HelmManagedByLabelKey),
+}
+
+// HelmSignOption is how a helm chart is rendered on signing.
+// ReleaseName and Namespace should be the same as the ones of the release to be verified.
+type HelmSignOption struct {
+	ReleaseName string
+	Namespace   string
+	ValuesFiles []string
+}
+
+// HelmValuesDigest returns the digest of values files which is recorded in signatures of a helm chart
+func HelmValuesDigest(valuesFiles []string) (string, error) {
+	h := sha256.New()
+	for _, f := range valuesFiles {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+func isHelmManaged(obj unstructured.Unstructured) bool {
+	_, found := obj.GetAnnotations()[HelmReleaseNameAnnotationKey]
+	return found
+}
+
+// copy a chart as `chart`, values files into `values` and write the output of `helm template` into `rendered` dir.
+// the digest of the values files is also returned.
+func prepareHelmBundleDir(chartDir string, ho *HelmSignOption) (string, string, error) {
+	releaseName := ho.ReleaseName
+	if releaseName == "" {
+		releaseName = defaultHelmReleaseName
+	}
+	dir, err := ioutil.TempDir("", "kubectl-sigstore-helm-dir")
+	if err != nil {
+		return "", "", err
+	}
+	err = k8ssigutil.CopyDir(chartDir, filepath.Join(dir, helmChartDirName))
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	valuesDir := filepath.Join(dir, helmValuesDirName)
+	err = os.MkdirAll(valuesDir, 0755)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	for i, f := range ho.ValuesFiles {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", "", err
+		}
+		// keep the order of values files, as later ones override earlier ones
+		err = ioutil.WriteFile(filepath.Join(valuesDir, fmt.Sprintf("%02d-%s", i, filepath.Base(f))), data, 0644)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", "", err
+		}
+	}
+	valuesDigest, err := HelmValuesDigest(ho.ValuesFiles)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	rendered, err := k8ssigutil.HelmTemplate(chartDir, releaseName, ho.Namespace, ho.ValuesFiles)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	renderedDir := filepath.Join(dir, renderedDirName)
+	err = os.MkdirAll(renderedDir, 0755)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	err = ioutil.WriteFile(filepath.Join(renderedDir, "manifest.yaml"), rendered, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, valuesDigest, nil
+}
+
+// find resources of a helm release on cluster by their release annotations.
+// resources which have signed manifests in the bundle image are returned first, and resources of the release
+// which are not in the bundle (e.g. added to the release after signing) are returned second
+func FindHelmReleaseResources(imageRef, releaseName, namespace string) ([]unstructured.Unstructured, []unstructured.Unstructured, error) {
+	if imageRef == "" {
+		return nil, nil, errors.New("image reference is required to find resources of a helm release")
+	}
+	concatYAMLFromImage, err := getManifestsInImage(imageRef)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to pull image")
+	}
+	objs, err := listAuditTargetObjects(ObjectReferenceList{{Kind: "*"}}, namespace)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list resources on cluster")
+	}
+	signed, unsigned := filterHelmReleaseResources(objs, concatYAMLFromImage, releaseName, namespace)
+	return signed, unsigned, nil
+}
+
+func filterHelmReleaseResources(objs []unstructured.Unstructured, concatYAMLFromImage []byte, releaseName, namespace string) ([]unstructured.Unstructured, []unstructured.Unstructured) {
+	signed := []unstructured.Unstructured{}
+	unsigned := []unstructured.Unstructured{}
+	found := map[string]bool{}
+	for _, obj := range objs {
+		annotations := obj.GetAnnotations()
+		if annotations[HelmReleaseNameAnnotationKey] != releaseName {
+			continue
+		}
+		if namespace != "" && annotations[HelmReleaseNamespaceAnnotationKey] != namespace {
+			continue
+		}
+		// the same resource may be listed in multiple versions
+		key := fmt.Sprintf("%s/%s/%s/%s", obj.GroupVersionKind().Group, obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		if found[key] {
+			continue
+		}
+		found[key] = true
+		if manifestFoundInBundle(obj, concatYAMLFromImage) {
+			signed = append(signed, obj)
+		} else {
+			log.Debug("resource of the release is not found in the bundle: ", key)
+			unsigned = append(unsigned, obj)
+		}
+	}
+	return signed, unsigned
+}
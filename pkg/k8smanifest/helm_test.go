@@ -0,0 +1,135 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sigstore/cosign/pkg/cosign"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testHelmRenderedYAML = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myapp-config
+data:
+  key: value
+`
+
+func TestPrepareHelmBundleDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "helm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// a fake `helm` command which prints a rendered manifest
+	binDir := filepath.Join(tmpDir, "bin")
+	_ = os.MkdirAll(binDir, 0755)
+	script := fmt.Sprintf("#!/bin/sh\ncat <<'EOF'\n%sEOF\n", testHelmRenderedYAML)
+	if err := ioutil.WriteFile(filepath.Join(binDir, "helm"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+origPath)
+	defer os.Setenv("PATH", origPath)
+
+	chartDir := filepath.Join(tmpDir, "mychart")
+	_ = os.MkdirAll(filepath.Join(chartDir, "templates"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("apiVersion: v2\nname: mychart\nversion: 0.1.0\n"), 0644)
+	valuesA := filepath.Join(tmpDir, "values-a.yaml")
+	valuesB := filepath.Join(tmpDir, "values-b.yaml")
+	_ = ioutil.WriteFile(valuesA, []byte("replicas: 1\n"), 0644)
+	_ = ioutil.WriteFile(valuesB, []byte("replicas: 3\n"), 0644)
+
+	bundleDir, digest, err := prepareHelmBundleDir(chartDir, &HelmSignOption{ReleaseName: "myapp", ValuesFiles: []string{valuesA, valuesB}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundleDir)
+
+	for _, f := range []string{"chart/Chart.yaml", "values/00-values-a.yaml", "values/01-values-b.yaml", "rendered/manifest.yaml"} {
+		if _, err := os.Stat(filepath.Join(bundleDir, f)); err != nil {
+			t.Errorf("`%s` is not found in the bundle dir", f)
+		}
+	}
+	rendered, _ := ioutil.ReadFile(filepath.Join(bundleDir, "rendered/manifest.yaml"))
+	if string(rendered) != testHelmRenderedYAML {
+		t.Errorf("unexpected rendered manifest: %s", string(rendered))
+	}
+	expected := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("replicas: 1\nreplicas: 3\n")))
+	if digest != expected {
+		t.Errorf("expected values digest %s, but got %s", expected, digest)
+	}
+	// the order of values files matters, as later ones override earlier ones
+	if reversed, _ := HelmValuesDigest([]string{valuesB, valuesA}); reversed == digest {
+		t.Error("values digest must depend on the order of values files")
+	}
+}
+
+func newHelmTestObject(kind, name, release, releaseNamespace string) unstructured.Unstructured {
+	obj := unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion("v1")
+	obj.SetKind(kind)
+	obj.SetNamespace("ns1")
+	obj.SetName(name)
+	if release != "" {
+		obj.SetAnnotations(map[string]string{
+			HelmReleaseNameAnnotationKey:      release,
+			HelmReleaseNamespaceAnnotationKey: releaseNamespace,
+		})
+	}
+	return obj
+}
+
+func TestFilterHelmReleaseResources(t *testing.T) {
+	objs := []unstructured.Unstructured{
+		newHelmTestObject("ConfigMap", "myapp-config", "myapp", "ns1"),
+		// listed twice in different versions
+		newHelmTestObject("ConfigMap", "myapp-config", "myapp", "ns1"),
+		newHelmTestObject("Secret", "myapp-extra", "myapp", "ns1"),
+		newHelmTestObject("ConfigMap", "other-config", "other", "ns1"),
+		newHelmTestObject("ConfigMap", "not-helm", "", ""),
+		newHelmTestObject("ConfigMap", "myapp-config-ns2", "myapp", "ns2"),
+	}
+	signed, unsigned := filterHelmReleaseResources(objs, []byte(testHelmRenderedYAML), "myapp", "ns1")
+	if len(signed) != 1 || signed[0].GetName() != "myapp-config" {
+		t.Errorf("expected only `myapp-config` as a signed resource, but got %v", signed)
+	}
+	if len(unsigned) != 1 || unsigned[0].GetName() != "myapp-extra" {
+		t.Errorf("expected `myapp-extra` as a resource without signed manifest, but got %v", unsigned)
+	}
+}
+
+func TestCheckHelmValuesDigest(t *testing.T) {
+	sp := cosign.SignedPayload{Payload: []byte(`{"critical":{},"optional":{"k8s-manifest-sigstore/helm-values-digest":"sha256:0123"}}`)}
+	if err := (&signatureChecker{HelmValuesDigest: "sha256:0123"}).checkHelmValuesDigest(sp); err != nil {
+		t.Errorf("unexpected error; %s", err.Error())
+	}
+	if err := (&signatureChecker{HelmValuesDigest: "sha256:4567"}).checkHelmValuesDigest(sp); err == nil {
+		t.Error("expected an error for a signature with other values")
+	}
+	if err := (&signatureChecker{}).checkHelmValuesDigest(sp); err != nil {
+		t.Errorf("values digest must not be checked if not required; %s", err.Error())
+	}
+}
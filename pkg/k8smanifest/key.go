@@ -0,0 +1,178 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	cosigncli "github.com/sigstore/cosign/cmd/cosign/cli"
+	"github.com/sigstore/cosign/pkg/cosign"
+
+	kubeutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/kubeutil"
+)
+
+const (
+	// a key in a Secret, e.g. `k8s://<namespace>/<name>`
+	kubernetesKeyRefPrefix = "k8s://"
+	// a PEM (or base64 encoded PEM) in an environment variable, e.g. `env://COSIGN_KEY`
+	envKeyRefPrefix = "env://"
+)
+
+// data keys in a Secret, which are the same as the ones of `cosign generate-key-pair k8s://...`
+const (
+	privateKeySecretDataKey = "cosign.key"
+	publicKeySecretDataKey  = "cosign.pub"
+	passwordSecretDataKey   = "cosign.password"
+)
+
+// KeyPasswordOption is where a password of a private key is read from.
+// If all of them are empty, `COSIGN_PASSWORD` env or a prompt on a terminal is used.
+type KeyPasswordOption struct {
+	Env   string
+	File  string
+	Stdin bool
+}
+
+// return a function to read a password. a password found in a key Secret is used when no option is given.
+func (o *KeyPasswordOption) passFunc(secretPassword []byte) cosign.PassFunc {
+	return func(_ bool) ([]byte, error) {
+		switch {
+		case o != nil && o.Env != "":
+			pw, ok := os.LookupEnv(o.Env)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("env `%s` for key password is not set", o.Env))
+			}
+			return []byte(pw), nil
+		case o != nil && o.File != "":
+			pw, err := ioutil.ReadFile(o.File)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read key password file")
+			}
+			return []byte(strings.TrimRight(string(pw), "\r\n")), nil
+		case o != nil && o.Stdin:
+			pw, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read key password from stdin")
+			}
+			return []byte(strings.TrimRight(string(pw), "\r\n")), nil
+		case secretPassword != nil:
+			return secretPassword, nil
+		default:
+			return cosigncli.GetPass(false)
+		}
+	}
+}
+
+// resolve a key reference into a local key file.
+// `k8s://<namespace>/<name>` and `env://<VAR>` are written into a temp file which is removed by the returned cleanup function.
+func resolveKeyRef(keyRef, secretDataKey string) (string, []byte, func(), error) {
+	nop := func() {}
+	var keyBytes []byte
+	var password []byte
+	switch {
+	case strings.HasPrefix(keyRef, kubernetesKeyRefPrefix):
+		var err error
+		keyBytes, password, err = loadKeyFromSecret(strings.TrimPrefix(keyRef, kubernetesKeyRefPrefix), secretDataKey)
+		if err != nil {
+			return "", nil, nop, err
+		}
+	case strings.HasPrefix(keyRef, envKeyRefPrefix):
+		envName := strings.TrimPrefix(keyRef, envKeyRefPrefix)
+		val, ok := os.LookupEnv(envName)
+		if !ok || val == "" {
+			return "", nil, nop, errors.New(fmt.Sprintf("env `%s` for key is not set", envName))
+		}
+		keyBytes = decodePEMValue(val)
+	default:
+		return keyRef, nil, nop, nil
+	}
+	f, err := ioutil.TempFile("", "kubectl-sigstore-key")
+	if err != nil {
+		return "", nil, nop, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	_, err = f.Write(keyBytes)
+	f.Close()
+	if err != nil {
+		cleanup()
+		return "", nil, nop, err
+	}
+	return f.Name(), password, cleanup, nil
+}
+
+func loadKeyFromSecret(nsAndName, dataKey string) ([]byte, []byte, error) {
+	parts := strings.Split(nsAndName, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, nil, errors.New("key secret must be referred as `k8s://<namespace>/<name>`")
+	}
+	secret, err := kubeutil.GetResource("v1", "Secret", parts[0], parts[1])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get key secret")
+	}
+	data, _ := secret.Object["data"].(map[string]interface{})
+	encoded, ok := data[dataKey].(string)
+	if !ok && len(data) == 1 {
+		// a secret with a single key is also accepted
+		for _, v := range data {
+			encoded, ok = v.(string)
+		}
+	}
+	if !ok {
+		return nil, nil, errors.New(fmt.Sprintf("`%s` is not found in secret `%s`", dataKey, nsAndName))
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode key in secret")
+	}
+	var password []byte
+	if encodedPw, ok := data[passwordSecretDataKey].(string); ok && dataKey == privateKeySecretDataKey {
+		password, _ = base64.StdEncoding.DecodeString(encodedPw)
+	}
+	return keyBytes, password, nil
+}
+
+// a PEM in env can be set as it is, or base64 encoded to avoid newlines
+func decodePEMValue(val string) []byte {
+	if strings.Contains(val, "-----BEGIN") {
+		return []byte(val)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val)); err == nil {
+		return decoded
+	}
+	return []byte(val)
+}
+
+// return a copy of the option whose key reference is resolved into a local file, and a password function is set.
+// the returned cleanup function must be called after the backend is used.
+func (o *BackendOption) resolveKey(secretDataKey string) (*BackendOption, func(), error) {
+	newOpt := &BackendOption{}
+	if o != nil {
+		*newOpt = *o
+	}
+	keyPath, password, cleanup, err := resolveKeyRef(newOpt.KeyPath, secretDataKey)
+	if err != nil {
+		return nil, cleanup, errors.Wrap(err, "failed to load a key")
+	}
+	newOpt.KeyPath = keyPath
+	newOpt.passFunc = newOpt.KeyPassword.passFunc(password)
+	return newOpt, cleanup, nil
+}
@@ -0,0 +1,82 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPEM = "-----BEGIN PUBLIC KEY-----\nMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE\n-----END PUBLIC KEY-----\n"
+
+func TestResolveKeyRefFromEnv(t *testing.T) {
+	for _, val := range []string{testPEM, base64.StdEncoding.EncodeToString([]byte(testPEM))} {
+		os.Setenv("TEST_K8SMANIFEST_KEY", val)
+		keyPath, _, cleanup, err := resolveKeyRef("env://TEST_K8SMANIFEST_KEY", publicKeySecretDataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyBytes, err := ioutil.ReadFile(keyPath)
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(keyBytes) != testPEM {
+			t.Errorf("unexpected key: %s", string(keyBytes))
+		}
+	}
+	os.Unsetenv("TEST_K8SMANIFEST_KEY")
+	if _, _, _, err := resolveKeyRef("env://TEST_K8SMANIFEST_KEY", publicKeySecretDataKey); err == nil {
+		t.Errorf("an unset env must be an error")
+	}
+	// a file path is used as it is
+	if keyPath, _, _, _ := resolveKeyRef("cosign.pub", publicKeySecretDataKey); keyPath != "cosign.pub" {
+		t.Errorf("unexpected key path: %s", keyPath)
+	}
+}
+
+func TestKeyPasswordOption(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "kubectl-sigstore-test")
+	defer os.RemoveAll(dir)
+	pwFile := filepath.Join(dir, "password")
+	_ = ioutil.WriteFile(pwFile, []byte("from-file\n"), 0600)
+	os.Setenv("TEST_K8SMANIFEST_PASSWORD", "from-env")
+	defer os.Unsetenv("TEST_K8SMANIFEST_PASSWORD")
+
+	testcases := []struct {
+		opt            *KeyPasswordOption
+		secretPassword []byte
+		expected       string
+	}{
+		{opt: &KeyPasswordOption{Env: "TEST_K8SMANIFEST_PASSWORD"}, expected: "from-env"},
+		{opt: &KeyPasswordOption{File: pwFile}, expected: "from-file"},
+		{opt: &KeyPasswordOption{File: pwFile}, secretPassword: []byte("from-secret"), expected: "from-file"},
+		{opt: nil, secretPassword: []byte("from-secret"), expected: "from-secret"},
+	}
+	for i, tc := range testcases {
+		pw, err := tc.opt.passFunc(tc.secretPassword)(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(pw) != tc.expected {
+			t.Errorf("case %d: expected %s, got %s", i, tc.expected, string(pw))
+		}
+	}
+}
@@ -0,0 +1,297 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+const (
+	// a config with this problem is rejected on load
+	LintLevelError = "error"
+	// a rule which is valid but can never match or is redundant
+	LintLevelWarning = "warning"
+)
+
+// LintFinding is a problem in a verification config, e.g. `ignoreFields[0].fields[1]`
+type LintFinding struct {
+	Level    string `json:"level"`
+	Location string `json:"location"`
+	Message  string `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	if f.Location == "" {
+		return fmt.Sprintf("%s: %s", f.Level, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Level, f.Location, f.Message)
+}
+
+type lintFindings []LintFinding
+
+func (l *lintFindings) add(level, location, format string, args ...interface{}) {
+	*l = append(*l, LintFinding{Level: level, Location: location, Message: fmt.Sprintf(format, args...)})
+}
+
+// LintVerifyConfig parses a config YAML strictly and returns the problems in it
+func LintVerifyConfig(cfgBytes []byte) []LintFinding {
+	var option *VerifyOption
+	if err := k8ssigutil.StrictUnmarshalYAML(cfgBytes, &option); err != nil {
+		return []LintFinding{{Level: LintLevelError, Message: err.Error()}}
+	}
+	if option == nil {
+		return []LintFinding{{Level: LintLevelError, Message: "config is empty"}}
+	}
+	return option.Lint()
+}
+
+// Lint returns invalid paths and patterns as errors, and rules which can never match or overlap with others as warnings
+func (vo *VerifyOption) Lint() []LintFinding {
+	findings := &lintFindings{}
+	if vo == nil {
+		return *findings
+	}
+	findings.lintObjectReferences("skipObjects", vo.SkipObjects)
+	for i, f := range vo.IgnoreFields {
+		loc := fmt.Sprintf("ignoreFields[%d]", i)
+		findings.lintObjectReferences(loc+".objects", f.Objects)
+		findings.lintFields(loc, f.Fields)
+		if len(f.Fields) == 0 {
+			findings.add(LintLevelWarning, loc, "no fields are ignored")
+		}
+		if f.Constraint != nil && f.Constraint.Regex != "" {
+			if _, err := regexp.Compile(f.Constraint.Regex); err != nil {
+				findings.add(LintLevelError, loc+".constraint.regex", "invalid regex; %s", err.Error())
+			}
+		}
+		if j, ok := coveringObjectReferences(f.Objects, vo.SkipObjects); ok {
+			findings.add(LintLevelWarning, loc+".objects", "never applies because the objects are skipped by skipObjects[%d]", j)
+		}
+		for j := 0; j < i; j++ {
+			if !reflect.DeepEqual(f.Objects, vo.IgnoreFields[j].Objects) {
+				continue
+			}
+			for k, field := range f.Fields {
+				if containsString(vo.IgnoreFields[j].Fields, field) {
+					findings.add(LintLevelWarning, fmt.Sprintf("%s.fields[%d]", loc, k), "duplicates a field of ignoreFields[%d] for the same objects", j)
+				}
+			}
+		}
+	}
+	for i, n := range vo.Normalizers {
+		loc := fmt.Sprintf("normalizers[%d]", i)
+		findings.lintObjectReferences(loc, ObjectReferenceList{{Group: n.Group, Kind: n.Kind}})
+		findings.lintFields(loc, n.Fields)
+	}
+	if vo.ContainerImages != nil {
+		for i, f := range vo.ContainerImages.ImageFields {
+			loc := fmt.Sprintf("containerImages.imageFields[%d]", i)
+			findings.lintObjectReferences(loc+".objects", f.Objects)
+			findings.lintFields(loc, f.Fields)
+		}
+	}
+	for i, t := range vo.NameTransforms {
+		findings.lintObjectReferences(fmt.Sprintf("nameTransforms[%d].objects", i), t.Objects)
+	}
+	for i, m := range vo.KnownMutators {
+		loc := fmt.Sprintf("knownMutators[%d]", i)
+		findings.lintObjectReferences(loc+".objects", m.Objects)
+		for j, c := range m.Containers {
+			if strings.TrimSpace(c.Image) == "" {
+				findings.add(LintLevelError, fmt.Sprintf("%s.containers[%d].image", loc, j), "image is required, otherwise any image is tolerated")
+			}
+		}
+		for j, v := range m.Volumes {
+			vloc := fmt.Sprintf("%s.volumes[%d]", loc, j)
+			if strings.TrimSpace(v.Name) == "" {
+				findings.add(LintLevelError, vloc+".name", "name is required, otherwise any volume is tolerated")
+			} else {
+				findings.lintPattern(vloc+".name", v.Name)
+			}
+			if len(v.Sources) == 0 {
+				findings.add(LintLevelError, vloc+".sources", "sources are required, otherwise the volume is never tolerated")
+			}
+			for k, p := range v.SourceNames {
+				findings.lintPattern(fmt.Sprintf("%s.sourceNames[%d]", vloc, k), p)
+			}
+		}
+		for j, p := range m.Annotations {
+			findings.lintPattern(fmt.Sprintf("%s.annotations[%d]", loc, j), p)
+		}
+		for j, p := range m.Labels {
+			findings.lintPattern(fmt.Sprintf("%s.labels[%d]", loc, j), p)
+		}
+	}
+	findings.lintSigners("signers", vo.Signers)
+	if vo.Merge != nil {
+		if s := vo.Merge.Signers; s != "" && s != SignersMergeUnion && s != SignersMergeIntersect {
+			findings.add(LintLevelError, "merge.signers", "must be `%s` or `%s`", SignersMergeUnion, SignersMergeIntersect)
+		}
+		for i, name := range vo.Merge.Replace {
+			if !isVerifyOptionListField(name) {
+				findings.add(LintLevelError, fmt.Sprintf("merge.replace[%d]", i), "`%s` is not a list in the config", name)
+			}
+		}
+	}
+	if vo.SignerPolicy != nil {
+		findings.lintSigners("signerPolicy.signers", vo.SignerPolicy.Signers)
+		for i, r := range vo.SignerPolicy.Roles {
+			findings.lintSigners(fmt.Sprintf("signerPolicy.roles[%d].signers", i), r.Signers)
+		}
+		if err := vo.SignerPolicy.checkBackend(vo.Backend); err != nil {
+			findings.add(LintLevelError, "signerPolicy", "%s", err.Error())
+		}
+	}
+	if vo.Backend != nil {
+		if _, err := parseExtKeyUsages(vo.Backend.KeyUsages); err != nil {
+			findings.add(LintLevelError, "backend.keyUsages", "%s", err.Error())
+		}
+	}
+	if vo.Revocation != nil && vo.Revocation.RefreshInterval != "" {
+		if _, err := parseDuration(vo.Revocation.RefreshInterval); err != nil {
+			findings.add(LintLevelError, "revocation.refreshInterval", "invalid duration; %s", err.Error())
+		}
+	}
+	return *findings
+}
+
+func (l *lintFindings) lintFields(loc string, fields []string) {
+	for i, field := range fields {
+		fieldLoc := fmt.Sprintf("%s.fields[%d]", loc, i)
+		if _, err := mapnode.ParsePath(field); err != nil {
+			l.add(LintLevelError, fieldLoc, "%s", err.Error())
+			continue
+		}
+		for _, k := range CommonResourceMaskKeys {
+			if field == k || strings.HasPrefix(field, k+".") || strings.HasPrefix(field, k+"[") {
+				l.add(LintLevelWarning, fieldLoc, "`%s` never has a diff because `%s` is always masked", field, k)
+				break
+			}
+		}
+		for j, other := range fields {
+			if i != j && (strings.HasPrefix(field, other+".") || strings.HasPrefix(field, other+"[")) {
+				l.add(LintLevelWarning, fieldLoc, "`%s` is redundant because `%s` covers it", field, other)
+				break
+			}
+		}
+	}
+}
+
+func (l *lintFindings) lintObjectReferences(loc string, refs ObjectReferenceList) {
+	for i, r := range refs {
+		refLoc := fmt.Sprintf("%s[%d]", loc, i)
+		l.lintPattern(refLoc+".group", r.Group)
+		l.lintPattern(refLoc+".version", r.Version)
+		l.lintPattern(refLoc+".kind", r.Kind)
+		l.lintPattern(refLoc+".name", r.Name)
+		l.lintPattern(refLoc+".namespace", r.Namespace)
+		if r.Group == "core" || strings.HasPrefix(r.Group, "v1") {
+			l.add(LintLevelWarning, refLoc+".group", "group `%s` never matches; the core group is empty", r.Group)
+		} else if strings.Contains(r.Group, "/") {
+			l.add(LintLevelWarning, refLoc+".group", "group `%s` never matches; the version must be in `version`", r.Group)
+		}
+		if r.Kind != "" && r.Kind != "*" && unicode.IsLower([]rune(r.Kind)[0]) {
+			l.add(LintLevelWarning, refLoc+".kind", "kind `%s` never matches; kinds are case-sensitive (e.g. `Deployment`)", r.Kind)
+		}
+	}
+}
+
+func (l *lintFindings) lintSigners(loc string, signers SignerList) {
+	for i, s := range signers {
+		l.lintPattern(fmt.Sprintf("%s[%d]", loc, i), s)
+	}
+}
+
+// `*` is a wildcard only at the end of a pattern (see MatchPattern)
+func (l *lintFindings) lintPattern(loc, pattern string) {
+	for _, p := range k8ssigutil.SplitRule(pattern) {
+		if i := strings.Index(p, "*"); i >= 0 && i != len(p)-1 {
+			l.add(LintLevelWarning, loc, "`*` works only at the end of a pattern, so `%s` matches only the literal value", p)
+			return
+		}
+	}
+}
+
+// return the index of a skip entry if all of the objects are skipped by it
+func coveringObjectReferences(objects, skipObjects ObjectReferenceList) (int, bool) {
+	if len(skipObjects) == 0 {
+		return 0, false
+	}
+	if len(objects) == 0 {
+		objects = ObjectReferenceList{{}}
+	}
+	index := -1
+	for _, o := range objects {
+		covered := false
+		for j, s := range skipObjects {
+			if coversObjectReference(s, o) {
+				covered = true
+				index = j
+				break
+			}
+		}
+		if !covered {
+			return 0, false
+		}
+	}
+	return index, true
+}
+
+func coversObjectReference(s, o ObjectReference) bool {
+	covers := func(p1, p2 string) bool {
+		if p1 == "" || p1 == "*" {
+			return true
+		}
+		if p2 == "" || strings.Contains(p2, "*") || strings.Contains(p2, ",") {
+			return p1 == p2
+		}
+		return k8ssigutil.MatchPattern(p1, p2)
+	}
+	return covers(s.Group, o.Group) && covers(s.Version, o.Version) && covers(s.Kind, o.Kind) &&
+		covers(s.Name, o.Name) && covers(s.Namespace, o.Namespace)
+}
+
+func isVerifyOptionListField(name string) bool {
+	t := reflect.TypeOf(VerifyOption{})
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == name {
+			return t.Field(i).Type.Kind() == reflect.Slice
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyOptionSchema returns a JSON Schema of the verification config
+func VerifyOptionSchema() ([]byte, error) {
+	return k8ssigutil.GenerateJSONSchema(reflect.TypeOf(VerifyOption{}), "VerifyOption")
+}
@@ -0,0 +1,113 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLintVerifyConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		expected []string
+	}{
+		{
+			name:     "valid config",
+			config:   "ignoreFields:\n- objects:\n  - kind: Deployment\n  fields:\n  - spec.replicas\n",
+			expected: []string{},
+		},
+		{
+			name:     "unknown field with a hint",
+			config:   "ignoreField:\n- fields:\n  - spec.replicas\n",
+			expected: []string{"error: line 1: `ignoreField` is an unknown field of VerifyOption (did you mean `ignoreFields`?)"},
+		},
+		{
+			name:     "wrong type",
+			config:   "ignoreFields:\n- fields: spec.replicas\n",
+			expected: []string{"error: line 2: `ignoreFields[0].fields`"},
+		},
+		{
+			name:     "invalid path",
+			config:   "ignoreFields:\n- fields:\n  - spec.containers[x\n",
+			expected: []string{"error: ignoreFields[0].fields[0]:"},
+		},
+		{
+			name:   "rules which never match",
+			config: "skipObjects:\n- kind: Secret\nignoreFields:\n- objects:\n  - kind: Secret\n    name: sample\n  fields:\n  - data\n- objects:\n  - group: core\n    kind: configmap\n  fields:\n  - metadata.managedFields.manager\n",
+			expected: []string{
+				"warning: ignoreFields[0].objects: never applies because the objects are skipped by skipObjects[0]",
+				"warning: ignoreFields[1].objects[0].group:",
+				"warning: ignoreFields[1].objects[0].kind:",
+				"warning: ignoreFields[1].fields[0]: `metadata.managedFields.manager` never has a diff",
+			},
+		},
+		{
+			name:   "overlapping fields",
+			config: "ignoreFields:\n- fields:\n  - spec\n  - spec.replicas\n- fields:\n  - spec\n",
+			expected: []string{
+				"warning: ignoreFields[0].fields[1]: `spec.replicas` is redundant",
+				"warning: ignoreFields[1].fields[0]: duplicates a field of ignoreFields[0]",
+			},
+		},
+		{
+			name:     "wildcard in the middle",
+			config:   "skipObjects:\n- name: sample-*-config\n",
+			expected: []string{"warning: skipObjects[0].name:"},
+		},
+		{
+			name:     "mutator container without image",
+			config:   "knownMutators:\n- name: vault-agent\n  containers:\n  - name: vault-agent*\n",
+			expected: []string{"error: knownMutators[0].containers[0].image: image is required"},
+		},
+		{
+			name:     "mutator volume without sources",
+			config:   "knownMutators:\n- name: vault-agent\n  volumes:\n  - name: vault-secrets\n",
+			expected: []string{"error: knownMutators[0].volumes[0].sources: sources are required"},
+		},
+	}
+	for _, c := range cases {
+		findings := LintVerifyConfig([]byte(c.config))
+		if len(findings) != len(c.expected) {
+			t.Errorf("%s: expected %v findings, got %v", c.name, len(c.expected), findings)
+			continue
+		}
+		for i, f := range findings {
+			if !strings.HasPrefix(f.String(), c.expected[i]) {
+				t.Errorf("%s: expected `%s...`, got `%s`", c.name, c.expected[i], f.String())
+			}
+		}
+	}
+}
+
+// the published schema must be regenerated with `make schema` when VerifyOption is changed
+func TestVerifyOptionSchema(t *testing.T) {
+	schema, err := VerifyOptionSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := ioutil.ReadFile("../../schemas/verify-option.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(schema), bytes.TrimSpace(published)) {
+		t.Error("schemas/verify-option.schema.json is outdated; run `make schema`")
+	}
+}
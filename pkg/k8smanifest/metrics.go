@@ -0,0 +1,104 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const metricsNamespace = "k8s_manifest_sigstore"
+
+const (
+	StageBundlePull     = "bundle_pull"
+	StageSignatureCheck = "signature_check"
+	StageDryRun         = "dryrun"
+)
+
+const (
+	VerificationResultVerified = "verified"
+	VerificationResultFailed   = "failed"
+	VerificationResultSkipped  = "skipped"
+	VerificationResultError    = "error"
+)
+
+var (
+	verificationTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "verification_total",
+			Help:      "Number of resource verifications by result, kind and namespace",
+		},
+		[]string{"result", "kind", "namespace"},
+	)
+	verificationStageDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "verification_stage_duration_seconds",
+			Help:      "Latency of each verification stage (bundle pull, signature check and dryrun)",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		},
+		[]string{"stage"},
+	)
+	cacheRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_requests_total",
+			Help:      "Number of cache lookups by cache name and result (hit or miss)",
+		},
+		[]string{"cache", "result"},
+	)
+)
+
+// MetricsCollectors returns the prometheus collectors of this package.
+// The caller decides which registry they are exposed on
+// (e.g. `metrics.Registry` of controller-runtime in an admission controller).
+func MetricsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		verificationTotal,
+		verificationStageDuration,
+		cacheRequestTotal,
+	}
+}
+
+func observeStageDuration(stage string, start time.Time) {
+	verificationStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+func recordCacheLookup(cacheName string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequestTotal.WithLabelValues(cacheName, result).Inc()
+}
+
+func recordVerification(obj unstructured.Unstructured, result *VerifyResourceResult, err error) {
+	resultLabel := VerificationResultError
+	if err == nil && result != nil {
+		if !result.InScope {
+			resultLabel = VerificationResultSkipped
+		} else if result.Verified {
+			resultLabel = VerificationResultVerified
+		} else {
+			resultLabel = VerificationResultFailed
+		}
+	}
+	verificationTotal.WithLabelValues(resultLabel, obj.GetKind(), obj.GetNamespace()).Inc()
+}
@@ -0,0 +1,61 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRecordVerification(t *testing.T) {
+	obj := unstructured.Unstructured{}
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("metrics-test")
+
+	cases := []struct {
+		result   *VerifyResourceResult
+		err      error
+		expected string
+	}{
+		{result: &VerifyResourceResult{InScope: true, Verified: true}, expected: VerificationResultVerified},
+		{result: &VerifyResourceResult{InScope: true}, expected: VerificationResultFailed},
+		{result: &VerifyResourceResult{}, expected: VerificationResultSkipped},
+		{err: errors.New("failed"), expected: VerificationResultError},
+	}
+	for _, c := range cases {
+		counter := verificationTotal.WithLabelValues(c.expected, "ConfigMap", "metrics-test")
+		before := testutil.ToFloat64(counter)
+		recordVerification(obj, c.result, c.err)
+		if after := testutil.ToFloat64(counter); after != before+1 {
+			t.Errorf("verification_total of result `%s` must be incremented", c.expected)
+		}
+	}
+}
+
+func TestRecordCacheLookup(t *testing.T) {
+	hit := cacheRequestTotal.WithLabelValues("test", "hit")
+	miss := cacheRequestTotal.WithLabelValues("test", "miss")
+	recordCacheLookup("test", true)
+	recordCacheLookup("test", false)
+	recordCacheLookup("test", false)
+	if testutil.ToFloat64(hit) != 1 || testutil.ToFloat64(miss) != 2 {
+		t.Errorf("unexpected cache lookups; hit: %v, miss: %v", testutil.ToFloat64(hit), testutil.ToFloat64(miss))
+	}
+}
@@ -0,0 +1,321 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+)
+
+// KnownMutator is a mutating webhook (e.g. istio sidecar injection) whose additions to a resource are tolerated.
+// Containers, volumes, annotations and labels which match the patterns and are not in the signed manifest
+// are removed before matching, and reported in VerifyResourceResult.Mutations. Any other change still fails.
+type KnownMutator struct {
+	Name    string              `json:"name"`
+	Objects ObjectReferenceList `json:"objects,omitempty"`
+	// containers and initContainers added to a pod spec
+	Containers []ContainerPattern `json:"containers,omitempty"`
+	// volumes added to a pod spec
+	Volumes []VolumePattern `json:"volumes,omitempty"`
+	// keys of annotations / labels added to metadata of a resource and its pod template
+	Annotations []string `json:"annotations,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+}
+
+// ContainerPattern matches containers added by a mutator. Image is required, because any container
+// with a matched name would be tolerated otherwise
+type ContainerPattern struct {
+	Name  string `json:"name,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+// VolumePattern matches volumes added by a mutator. Sources is required, because a volume with a matched name
+// could mount anything (e.g. hostPath) otherwise
+type VolumePattern struct {
+	Name string `json:"name"`
+	// allowed source types of the volume, e.g. `emptyDir`, `configMap` (patterns are not accepted)
+	Sources []string `json:"sources,omitempty"`
+	// names of the configMap, secret or persistentVolumeClaim of the volume (if empty, any names)
+	SourceNames []string `json:"sourceNames,omitempty"`
+	// tolerate mounts of the volume in the signed containers. mounts in the containers added by the mutator
+	// are removed with the containers, so this is needed only if the mutator changes the signed containers
+	MountInSignedContainers bool `json:"mountInSignedContainers,omitempty"`
+}
+
+// fields of the referred object name for each volume source type
+var volumeSourceNameFields = map[string]string{
+	"configMap":             "name",
+	"secret":                "secretName",
+	"persistentVolumeClaim": "claimName",
+}
+
+type KnownMutatorList []KnownMutator
+
+// MutationResult is a set of fields added by a known mutator, e.g. `spec.containers[?(@.name=='istio-proxy')]`
+type MutationResult struct {
+	Mutator string   `json:"mutator"`
+	Fields  []string `json:"fields"`
+}
+
+// return a copy of the object without the additions of known mutators, and the removed fields
+func (l KnownMutatorList) removeAdditions(obj unstructured.Unstructured, manifestBytes []byte) (unstructured.Unstructured, []MutationResult) {
+	if len(l) == 0 {
+		return obj, nil
+	}
+	var mnfObj unstructured.Unstructured
+	if err := yaml.Unmarshal(manifestBytes, &mnfObj); err != nil || mnfObj.Object == nil {
+		return obj, nil
+	}
+	return l.removeAdditionsWithManifest(obj, mnfObj.Object)
+}
+
+// same as removeAdditions, but the manifest is given as a map (e.g. a pod template of a signed workload)
+func (l KnownMutatorList) removeAdditionsWithManifest(obj unstructured.Unstructured, mnf map[string]interface{}) (unstructured.Unstructured, []MutationResult) {
+	if len(l) == 0 {
+		return obj, nil
+	}
+	newObj := obj.DeepCopy()
+	results := []MutationResult{}
+	for _, m := range l {
+		if !m.Objects.Match(obj) {
+			continue
+		}
+		fields := m.removeAdditions(newObj.Object, mnf, obj.GetKind())
+		if len(fields) > 0 {
+			results = append(results, MutationResult{Mutator: m.Name, Fields: fields})
+		}
+	}
+	return *newObj, results
+}
+
+func (m KnownMutator) removeAdditions(obj, mnf map[string]interface{}, kind string) []string {
+	fields := []string{}
+	metadataPaths := []string{"metadata"}
+	podSpecPath, isWorkload := podSpecPaths[kind]
+	if isWorkload && podSpecPath != "spec" {
+		metadataPaths = append(metadataPaths, strings.TrimSuffix(podSpecPath, ".spec")+".metadata")
+	}
+	for _, mp := range metadataPaths {
+		fields = append(fields, removeMapAdditions(obj, mnf, mp+".annotations", m.Annotations)...)
+		fields = append(fields, removeMapAdditions(obj, mnf, mp+".labels", m.Labels)...)
+	}
+	if !isWorkload {
+		return fields
+	}
+	volumesPath := podSpecPath + ".volumes"
+	volumes, _, _ := unstructured.NestedSlice(obj, strings.Split(volumesPath, ".")...)
+	volumeFields := removeListAdditions(obj, mnf, volumesPath, m.matchVolume)
+	// only mounts of the removed volumes can be tolerated
+	remaining, _, _ := unstructured.NestedSlice(obj, strings.Split(volumesPath, ".")...)
+	removedVolumes := map[string]bool{}
+	for _, v := range volumes {
+		vMap, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(vMap, "name")
+		if findElementByName(remaining, name) == nil {
+			removedVolumes[name] = true
+		}
+	}
+	for _, c := range []string{"containers", "initContainers"} {
+		path := podSpecPath + "." + c
+		fields = append(fields, removeListAdditions(obj, mnf, path, m.matchContainer)...)
+		fields = append(fields, m.removeVolumeMounts(obj, mnf, path, removedVolumes)...)
+	}
+	fields = append(fields, volumeFields...)
+	return fields
+}
+
+func (m KnownMutator) matchContainer(c map[string]interface{}) bool {
+	name, _, _ := unstructured.NestedString(c, "name")
+	image, _, _ := unstructured.NestedString(c, "image")
+	for _, p := range m.Containers {
+		// an empty pattern matches any value, so a pattern without image never matches
+		if p.Image == "" {
+			continue
+		}
+		if k8ssigutil.MatchPattern(p.Name, name) && k8ssigutil.MatchPattern(p.Image, image) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m KnownMutator) matchVolume(v map[string]interface{}) bool {
+	name, _, _ := unstructured.NestedString(v, "name")
+	for _, p := range m.Volumes {
+		if k8ssigutil.MatchPattern(p.Name, name) && p.matchSource(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// check if the volume has only the allowed source type (and name)
+func (p VolumePattern) matchSource(v map[string]interface{}) bool {
+	sourceFound := false
+	for key, val := range v {
+		if key == "name" {
+			continue
+		}
+		if !containsString(p.Sources, key) {
+			return false
+		}
+		sourceFound = true
+		if len(p.SourceNames) == 0 {
+			continue
+		}
+		nameField, ok := volumeSourceNameFields[key]
+		if !ok {
+			continue
+		}
+		srcMap, _ := val.(map[string]interface{})
+		srcName, _, _ := unstructured.NestedString(srcMap, nameField)
+		if !k8ssigutil.MatchWithPatternArray(srcName, p.SourceNames) {
+			return false
+		}
+	}
+	return sourceFound
+}
+
+// check if a mount is of a removed volume whose mounts in the signed containers are tolerated
+func (m KnownMutator) matchSignedContainerMount(removedVolumes map[string]bool) func(map[string]interface{}) bool {
+	return func(vm map[string]interface{}) bool {
+		name, _, _ := unstructured.NestedString(vm, "name")
+		if !removedVolumes[name] {
+			return false
+		}
+		for _, p := range m.Volumes {
+			if p.MountInSignedContainers && k8ssigutil.MatchPattern(p.Name, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// remove mounts of the removed volumes from the signed containers in the list, if they are tolerated
+func (m KnownMutator) removeVolumeMounts(obj, mnf map[string]interface{}, listPath string, removedVolumes map[string]bool) []string {
+	keys := strings.Split(listPath, ".")
+	containers, found, _ := unstructured.NestedSlice(obj, keys...)
+	if !found || len(removedVolumes) == 0 {
+		return nil
+	}
+	mnfContainers, _, _ := unstructured.NestedSlice(mnf, keys...)
+	fields := []string{}
+	for i, c := range containers {
+		cMap, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(cMap, "name")
+		mnfContainer := map[string]interface{}{}
+		if mc := findElementByName(mnfContainers, name); mc != nil {
+			mnfContainer = mc
+		}
+		removed := removeListAdditions(cMap, mnfContainer, "volumeMounts", m.matchSignedContainerMount(removedVolumes))
+		for _, f := range removed {
+			fields = append(fields, fmt.Sprintf("%s[?(@.name=='%s')].%s", listPath, name, f))
+		}
+		containers[i] = cMap
+	}
+	_ = unstructured.SetNestedSlice(obj, containers, keys...)
+	return fields
+}
+
+// remove elements which match the condition and whose names are not in the same list of the manifest
+func removeListAdditions(obj, mnf map[string]interface{}, listPath string, match func(map[string]interface{}) bool) []string {
+	keys := strings.Split(listPath, ".")
+	list, found, _ := unstructured.NestedSlice(obj, keys...)
+	if !found {
+		return nil
+	}
+	mnfList, mnfFound, _ := unstructured.NestedSlice(mnf, keys...)
+	fields := []string{}
+	newList := []interface{}{}
+	for _, e := range list {
+		eMap, ok := e.(map[string]interface{})
+		if ok && match(eMap) {
+			name, _, _ := unstructured.NestedString(eMap, "name")
+			if findElementByName(mnfList, name) == nil {
+				fields = append(fields, fmt.Sprintf("%s[?(@.name=='%s')]", listPath, name))
+				continue
+			}
+		}
+		newList = append(newList, e)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	if len(newList) == 0 && !mnfFound {
+		unstructured.RemoveNestedField(obj, keys...)
+	} else {
+		_ = unstructured.SetNestedSlice(obj, newList, keys...)
+	}
+	return fields
+}
+
+// remove keys which match the patterns and are not in the same map of the manifest
+func removeMapAdditions(obj, mnf map[string]interface{}, mapPath string, patterns []string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+	keys := strings.Split(mapPath, ".")
+	m, found, _ := unstructured.NestedMap(obj, keys...)
+	if !found {
+		return nil
+	}
+	mnfMap, mnfFound, _ := unstructured.NestedMap(mnf, keys...)
+	fields := []string{}
+	for k := range m {
+		if _, ok := mnfMap[k]; ok || !k8ssigutil.MatchWithPatternArray(k, patterns) {
+			continue
+		}
+		delete(m, k)
+		fields = append(fields, fmt.Sprintf("%s['%s']", mapPath, k))
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	sort.Strings(fields)
+	if len(m) == 0 && !mnfFound {
+		unstructured.RemoveNestedField(obj, keys...)
+	} else {
+		_ = unstructured.SetNestedMap(obj, m, keys...)
+	}
+	return fields
+}
+
+func findElementByName(list []interface{}, name string) map[string]interface{} {
+	for _, e := range list {
+		eMap, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if n, _, _ := unstructured.NestedString(eMap, "name"); n == name {
+			return eMap
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSignedDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+`
+
+const testInjectedDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    metadata:
+      annotations:
+        sidecar.istio.io/status: injected
+      labels:
+        app: app
+    spec:
+      initContainers:
+      - name: istio-init
+        image: docker.io/istio/proxyv2:1.10.0
+      containers:
+      - name: app
+        image: %s
+        volumeMounts:
+        - name: istio-envoy
+          mountPath: /etc/istio/proxy
+      - name: istio-proxy
+        image: docker.io/istio/proxyv2:1.10.0
+      volumes:
+      - name: istio-envoy
+        emptyDir: {}
+`
+
+func TestKnownMutators(t *testing.T) {
+	mutators := KnownMutatorList{{
+		Name:        "istio",
+		Containers:  []ContainerPattern{{Name: "istio-*", Image: "docker.io/istio/proxyv2:*"}},
+		Volumes:     []VolumePattern{{Name: "istio-*", Sources: []string{"emptyDir"}, MountInSignedContainers: true}},
+		Annotations: []string{"sidecar.istio.io/*"},
+	}}
+
+	obj := loadTestObject(t, fmt.Sprintf(testInjectedDeployment, "app:v1"))
+	newObj, mutations := mutators.removeAdditions(obj, []byte(testSignedDeployment))
+	expectedFields := []string{
+		"spec.template.metadata.annotations['sidecar.istio.io/status']",
+		"spec.template.spec.containers[?(@.name=='istio-proxy')]",
+		"spec.template.spec.containers[?(@.name=='app')].volumeMounts[?(@.name=='istio-envoy')]",
+		"spec.template.spec.initContainers[?(@.name=='istio-init')]",
+		"spec.template.spec.volumes[?(@.name=='istio-envoy')]",
+	}
+	if len(mutations) != 1 || mutations[0].Mutator != "istio" || !reflect.DeepEqual(mutations[0].Fields, expectedFields) {
+		t.Errorf("unexpected mutations: %+v", mutations)
+	}
+	objBytes, _ := json.Marshal(newObj.Object)
+	if matched, diff, _ := directMatch(objBytes, []byte(testSignedDeployment), CommonResourceMaskKeys); !matched {
+		t.Errorf("a resource with known mutations should match; diff: %s", diff)
+	}
+
+	// a change of the signed content still fails
+	obj = loadTestObject(t, fmt.Sprintf(testInjectedDeployment, "evil:v1"))
+	newObj, _ = mutators.removeAdditions(obj, []byte(testSignedDeployment))
+	objBytes, _ = json.Marshal(newObj.Object)
+	if matched, _, _ := directMatch(objBytes, []byte(testSignedDeployment), CommonResourceMaskKeys); matched {
+		t.Errorf("a changed image should not match")
+	}
+}
+
+func TestKnownMutatorVolumes(t *testing.T) {
+	hostPathDeployment := strings.Replace(fmt.Sprintf(testInjectedDeployment, "app:v1"), "emptyDir: {}", "hostPath:\n          path: /", 1)
+	testcases := []struct {
+		name    string
+		volume  VolumePattern
+		objYAML string
+		matched bool
+	}{
+		{
+			name:    "allowed source and mount",
+			volume:  VolumePattern{Name: "istio-*", Sources: []string{"emptyDir"}, MountInSignedContainers: true},
+			objYAML: fmt.Sprintf(testInjectedDeployment, "app:v1"),
+			matched: true,
+		},
+		{
+			name:    "mount in a signed container is not allowed",
+			volume:  VolumePattern{Name: "istio-*", Sources: []string{"emptyDir"}},
+			objYAML: fmt.Sprintf(testInjectedDeployment, "app:v1"),
+			matched: false,
+		},
+		{
+			name:    "hostPath with a tolerated name",
+			volume:  VolumePattern{Name: "istio-*", Sources: []string{"emptyDir"}, MountInSignedContainers: true},
+			objYAML: hostPathDeployment,
+			matched: false,
+		},
+		{
+			name:    "no sources",
+			volume:  VolumePattern{Name: "istio-*", MountInSignedContainers: true},
+			objYAML: fmt.Sprintf(testInjectedDeployment, "app:v1"),
+			matched: false,
+		},
+	}
+	for _, tc := range testcases {
+		mutators := KnownMutatorList{{
+			Name:        "istio",
+			Containers:  []ContainerPattern{{Name: "istio-*", Image: "docker.io/istio/proxyv2:*"}},
+			Volumes:     []VolumePattern{tc.volume},
+			Annotations: []string{"sidecar.istio.io/*"},
+		}}
+		newObj, _ := mutators.removeAdditions(loadTestObject(t, tc.objYAML), []byte(testSignedDeployment))
+		objBytes, _ := json.Marshal(newObj.Object)
+		if matched, diff, _ := directMatch(objBytes, []byte(testSignedDeployment), CommonResourceMaskKeys); matched != tc.matched {
+			t.Errorf("%s: expected matched=%v, got %v; diff: %s", tc.name, tc.matched, matched, diff)
+		}
+	}
+}
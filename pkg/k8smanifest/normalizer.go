@@ -0,0 +1,134 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// normalizers registered for this GroupKind are applied to resources of any kind
+var AnyGroupKind = schema.GroupKind{Group: "*", Kind: "*"}
+
+// Normalizer returns fields of a resource which are populated by a server or a controller (e.g. `spec.clusterIP` of Service).
+// The fields are masked in both the resource and its signed manifest before diffing.
+type Normalizer interface {
+	MaskKeys(manifest *mapnode.Node) []string
+}
+
+// FieldNormalizer masks the fields unless the signed manifest has them, so a value given by the signer is still verified.
+// Group and Kind are used only for normalizers in a verification config, and an empty value matches any.
+type FieldNormalizer struct {
+	Group  string   `json:"group,omitempty"`
+	Kind   string   `json:"kind,omitempty"`
+	Fields []string `json:"fields"`
+	// if true, the fields are masked even if the signed manifest has them
+	Always bool `json:"always,omitempty"`
+}
+
+type FieldNormalizerList []FieldNormalizer
+
+var normalizerRegistry = struct {
+	sync.RWMutex
+	normalizers map[schema.GroupKind][]Normalizer
+}{normalizers: map[schema.GroupKind][]Normalizer{}}
+
+// built-in normalizers for known server-side mutations of core kinds
+var builtinNormalizers = map[schema.GroupKind][]string{
+	AnyGroupKind: {"metadata.finalizers"},
+	{Kind: "Service"}: {
+		"spec.clusterIP",
+		"spec.clusterIPs",
+		"spec.ipFamilies",
+		"spec.ipFamilyPolicy",
+		"spec.ports[*].nodePort",
+		"spec.healthCheckNodePort",
+	},
+	{Kind: "PersistentVolumeClaim"}: {
+		"spec.volumeName",
+		"metadata.annotations['pv.kubernetes.io/bind-completed']",
+		"metadata.annotations['pv.kubernetes.io/bound-by-controller']",
+	},
+	{Kind: "ServiceAccount"}: {"secrets"},
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"}:   {"webhooks[*].clientConfig.caBundle"},
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"}: {"webhooks[*].clientConfig.caBundle"},
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:               {"spec.conversion.webhook.clientConfig.caBundle", "status.acceptedNames"},
+	{Group: "apiregistration.k8s.io", Kind: "APIService"}:                           {"spec.caBundle"},
+}
+
+func init() {
+	for gk, fields := range builtinNormalizers {
+		RegisterNormalizer(gk, FieldNormalizer{Fields: fields})
+	}
+}
+
+// RegisterNormalizer adds a normalizer for resources of the GroupKind (AnyGroupKind for all resources)
+func RegisterNormalizer(gk schema.GroupKind, n Normalizer) {
+	normalizerRegistry.Lock()
+	defer normalizerRegistry.Unlock()
+	normalizerRegistry.normalizers[gk] = append(normalizerRegistry.normalizers[gk], n)
+}
+
+func (n FieldNormalizer) MaskKeys(manifest *mapnode.Node) []string {
+	if n.Always || manifest == nil {
+		return n.Fields
+	}
+	keys := []string{}
+	for _, f := range n.Fields {
+		if len(manifest.FindNodes(f)) == 0 {
+			keys = append(keys, f)
+		}
+	}
+	return keys
+}
+
+func (n FieldNormalizer) match(gk schema.GroupKind) bool {
+	return k8ssigutil.MatchPattern(n.Group, gk.Group) && k8ssigutil.MatchPattern(n.Kind, gk.Kind)
+}
+
+// return all normalizers for the object; registered ones and the ones in a verification config
+func (l FieldNormalizerList) normalizersFor(obj unstructured.Unstructured) []Normalizer {
+	gk := obj.GroupVersionKind().GroupKind()
+	normalizerRegistry.RLock()
+	normalizers := append([]Normalizer{}, normalizerRegistry.normalizers[AnyGroupKind]...)
+	normalizers = append(normalizers, normalizerRegistry.normalizers[gk]...)
+	normalizerRegistry.RUnlock()
+	for _, n := range l {
+		if n.match(gk) {
+			normalizers = append(normalizers, n)
+		}
+	}
+	return normalizers
+}
+
+// return mask keys for diffing the object and its signed manifest; CommonResourceMaskKeys and the keys of normalizers
+func (l FieldNormalizerList) maskKeys(obj unstructured.Unstructured, manifestBytes []byte) []string {
+	keys := append([]string{}, CommonResourceMaskKeys...)
+	mnfNode, err := mapnode.NewFromYamlBytes(manifestBytes)
+	if err != nil {
+		mnfNode = nil
+	}
+	for _, n := range l.normalizersFor(obj) {
+		keys = append(keys, n.MaskKeys(mnfNode)...)
+	}
+	return keys
+}
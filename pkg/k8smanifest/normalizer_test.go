@@ -0,0 +1,94 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testService = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: sample
+  finalizers:
+  - service.kubernetes.io/load-balancer-cleanup
+spec:
+  clusterIP: 10.96.0.10
+  ports:
+  - port: 80
+    nodePort: 30080
+`
+
+const testWidget = `
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: sample
+spec:
+  size: 1
+  endpoint: 10.0.0.1
+`
+
+func TestNormalizers(t *testing.T) {
+	cases := []struct {
+		name        string
+		obj         string
+		manifest    string
+		normalizers FieldNormalizerList
+		expected    bool
+	}{
+		{
+			name:     "server-populated fields of Service",
+			obj:      testService,
+			manifest: "apiVersion: v1\nkind: Service\nmetadata:\n  name: sample\nspec:\n  ports:\n  - port: 80\n",
+			expected: true,
+		},
+		{
+			name:     "clusterIP given by signer",
+			obj:      testService,
+			manifest: "apiVersion: v1\nkind: Service\nmetadata:\n  name: sample\nspec:\n  clusterIP: 10.96.0.20\n  ports:\n  - port: 80\n",
+			expected: false,
+		},
+		{
+			name:     "custom resource without normalizer",
+			obj:      testWidget,
+			manifest: "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: sample\nspec:\n  size: 1\n",
+			expected: false,
+		},
+		{
+			name:        "custom resource with normalizer in config",
+			obj:         testWidget,
+			manifest:    "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: sample\nspec:\n  size: 1\n",
+			normalizers: FieldNormalizerList{{Group: "example.com", Kind: "Widget", Fields: []string{"spec.endpoint"}}},
+			expected:    true,
+		},
+	}
+	for _, c := range cases {
+		obj := loadTestObject(t, c.obj)
+		objBytes, _ := json.Marshal(obj.Object)
+		maskKeys := c.normalizers.maskKeys(obj, []byte(c.manifest))
+		matched, diff, err := directMatch(objBytes, []byte(c.manifest), maskKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched != c.expected {
+			t.Errorf("%s: expected %v, but got %v; diff: %s", c.name, c.expected, matched, diff)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	kubeutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/kubeutil"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+const maxOwnerReferenceDepth = 5
+
+// ownerTemplate describes where the template of a generated resource is found in its ancestor,
+// and which part of the generated resource is compared with it.
+// An empty ancestorPath means the ancestor has no template for it (e.g. EndpointSlice of Service),
+// and then only the ownership and the ancestor verification are checked.
+type ownerTemplate struct {
+	ancestorKind string
+	childKind    string
+	ancestorPath string
+	childPath    string
+}
+
+var ownerTemplates = []ownerTemplate{
+	{ancestorKind: "Deployment", childKind: "ReplicaSet", ancestorPath: "spec.template", childPath: "spec.template"},
+	{ancestorKind: "Deployment", childKind: "Pod", ancestorPath: "spec.template", childPath: ""},
+	{ancestorKind: "ReplicaSet", childKind: "Pod", ancestorPath: "spec.template", childPath: ""},
+	{ancestorKind: "StatefulSet", childKind: "Pod", ancestorPath: "spec.template", childPath: ""},
+	{ancestorKind: "DaemonSet", childKind: "Pod", ancestorPath: "spec.template", childPath: ""},
+	{ancestorKind: "Job", childKind: "Pod", ancestorPath: "spec.template", childPath: ""},
+	{ancestorKind: "CronJob", childKind: "Job", ancestorPath: "spec.jobTemplate", childPath: ""},
+	{ancestorKind: "CronJob", childKind: "Pod", ancestorPath: "spec.jobTemplate.spec.template", childPath: ""},
+	{ancestorKind: "Service", childKind: "EndpointSlice", ancestorPath: "", childPath: ""},
+	{ancestorKind: "Service", childKind: "Endpoints", ancestorPath: "", childPath: ""},
+}
+
+// fields in a template which are never copied to generated resources as they are
+var templateMaskKeys = []string{
+	"metadata.creationTimestamp",
+}
+
+// a volume of a service account token which is added to pods on server side
+const serviceAccountTokenVolumePrefix = "kube-api-access-"
+
+// additions by defaulting on server side and by controllers to a pod template (e.g. `terminationMessagePath`).
+// each field must not be in the signed template, and its value must be one of the values if any.
+func podTemplateDefaults(prefix string) ObjectFieldBindingList {
+	defaults := []struct {
+		fields []string
+		values []string
+	}{
+		{fields: []string{
+			"metadata.labels['pod-template-hash']",
+			"metadata.labels['controller-revision-hash']",
+			"metadata.labels['pod-template-generation']",
+			"metadata.labels['statefulset.kubernetes.io/pod-name']",
+			"metadata.labels['apps.kubernetes.io/pod-index']",
+			"metadata.labels['controller-uid']",
+			"metadata.labels['job-name']",
+			"metadata.labels['batch.kubernetes.io/controller-uid']",
+			"metadata.labels['batch.kubernetes.io/job-name']",
+		}},
+		{fields: []string{"spec.dnsPolicy"}, values: []string{"ClusterFirst"}},
+		{fields: []string{"spec.restartPolicy"}, values: []string{"Always"}},
+		{fields: []string{"spec.schedulerName"}, values: []string{"default-scheduler"}},
+		{fields: []string{"spec.terminationGracePeriodSeconds"}, values: []string{"30"}},
+		{fields: []string{"spec.serviceAccountName", "spec.serviceAccount"}, values: []string{"default"}},
+		{fields: []string{"spec.enableServiceLinks"}, values: []string{"true"}},
+		{fields: []string{"spec.preemptionPolicy"}, values: []string{"PreemptLowerPriority"}},
+		{fields: []string{"spec.priority"}, values: []string{"0"}},
+		{fields: []string{"spec.containers[*].terminationMessagePath", "spec.initContainers[*].terminationMessagePath"}, values: []string{"/dev/termination-log"}},
+		{fields: []string{"spec.containers[*].terminationMessagePolicy", "spec.initContainers[*].terminationMessagePolicy"}, values: []string{"File"}},
+		{fields: []string{"spec.containers[*].imagePullPolicy", "spec.initContainers[*].imagePullPolicy"}, values: []string{"Always", "IfNotPresent"}},
+	}
+	bindings := ObjectFieldBindingList{}
+	for _, d := range defaults {
+		fields := []string{}
+		for _, f := range d.fields {
+			fields = append(fields, prefix+f)
+		}
+		bindings = append(bindings, ObjectFieldBinding{
+			Fields:     fields,
+			Constraint: &IgnoreConstraint{ParameterConstraint: ParameterConstraint{Enum: d.values}, AdditionsOnly: true},
+		})
+	}
+	return bindings
+}
+
+func additionsOf(fields ...string) ObjectFieldBinding {
+	return ObjectFieldBinding{Fields: fields, Constraint: &IgnoreConstraint{AdditionsOnly: true}}
+}
+
+// additions to generated resources which are tolerated, in the paths from the root of the generated resource
+var generatedResourceDefaults = map[string]ObjectFieldBindingList{
+	"Pod": append(podTemplateDefaults(""),
+		additionsOf("apiVersion", "kind", "metadata.name", "metadata.generateName", "metadata.ownerReferences"),
+		additionsOf("spec.nodeName", "spec.hostname", "spec.subdomain"),
+		additionsOf(
+			"spec.tolerations[?(@.key=='node.kubernetes.io/not-ready')]",
+			"spec.tolerations[?(@.key=='node.kubernetes.io/unreachable')]",
+		),
+	),
+	"ReplicaSet": podTemplateDefaults("spec.template."),
+	"Job": append(podTemplateDefaults("spec.template."),
+		additionsOf("apiVersion", "kind", "metadata.name", "metadata.ownerReferences"),
+		additionsOf(
+			"metadata.labels['controller-uid']",
+			"metadata.labels['job-name']",
+			"metadata.labels['batch.kubernetes.io/controller-uid']",
+			"metadata.labels['batch.kubernetes.io/job-name']",
+			"metadata.annotations['batch.kubernetes.io/job-tracking']",
+			"metadata.annotations['batch.kubernetes.io/cronjob-scheduled-timestamp']",
+			"spec.selector",
+		),
+		ObjectFieldBinding{
+			Fields:     []string{"spec.backoffLimit"},
+			Constraint: &IgnoreConstraint{ParameterConstraint: ParameterConstraint{Enum: []string{"6"}}, AdditionsOnly: true},
+		},
+		ObjectFieldBinding{
+			Fields:     []string{"spec.completions", "spec.parallelism"},
+			Constraint: &IgnoreConstraint{ParameterConstraint: ParameterConstraint{Enum: []string{"1"}}, AdditionsOnly: true},
+		},
+		ObjectFieldBinding{
+			Fields:     []string{"spec.completionMode"},
+			Constraint: &IgnoreConstraint{ParameterConstraint: ParameterConstraint{Enum: []string{"NonIndexed"}}, AdditionsOnly: true},
+		},
+		ObjectFieldBinding{
+			Fields:     []string{"spec.suspend"},
+			Constraint: &IgnoreConstraint{ParameterConstraint: ParameterConstraint{Enum: []string{"false"}}, AdditionsOnly: true},
+		},
+	),
+}
+
+func findOwnerTemplate(ancestorKind, childKind string) (*ownerTemplate, bool) {
+	for i := range ownerTemplates {
+		t := ownerTemplates[i]
+		if t.ancestorKind == ancestorKind && t.childKind == childKind {
+			return &t, true
+		}
+	}
+	return nil, false
+}
+
+func hasControllerOwner(obj unstructured.Unstructured) bool {
+	return metav1.GetControllerOf(&obj) != nil
+}
+
+// follow controller ownerReferences of the object and return the first ancestor whose manifest is found in a bundle image,
+// with the image ref and the manifests in the image
+func findSignedAncestor(obj unstructured.Unstructured, imageRef string) (*unstructured.Unstructured, string, []byte, error) {
+	current := obj
+	for i := 0; i < maxOwnerReferenceDepth; i++ {
+		ownerRef := metav1.GetControllerOf(&current)
+		if ownerRef == nil {
+			break
+		}
+		owner, err := kubeutil.GetResource(ownerRef.APIVersion, ownerRef.Kind, obj.GetNamespace(), ownerRef.Name)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, fmt.Sprintf("failed to get the owner %s `%s`", ownerRef.Kind, ownerRef.Name))
+		}
+		ownerImageRef := imageRef
+		if ownerImageRef == "" {
+			ownerImageRef = owner.GetAnnotations()[ImageRefAnnotationKey]
+		}
+		if ownerImageRef != "" {
+			concatYAMLFromImage, err := getManifestsInImage(ownerImageRef)
+			if err != nil {
+				return nil, "", nil, errors.Wrap(err, "failed to pull image")
+			}
+			if manifestFoundInBundle(*owner, concatYAMLFromImage) {
+				return owner, ownerImageRef, concatYAMLFromImage, nil
+			}
+		}
+		current = *owner
+	}
+	return nil, "", nil, nil
+}
+
+func manifestFoundInBundle(obj unstructured.Unstructured, concatYAMLFromImage []byte) bool {
+	found, _ := k8ssigutil.FindSingleYaml(concatYAMLFromImage, obj.GetAPIVersion(), obj.GetKind(), obj.GetName(), obj.GetNamespace())
+	return found
+}
+
+// verify a generated resource with the signed template in its ancestor
+func verifyGeneratedResource(obj unstructured.Unstructured, imageRef, keyPath string, vo *VerifyOption, mo *resourceMatchOption) (*VerifyResourceResult, bool, error) {
+	ancestor, ancestorImageRef, concatYAMLFromImage, err := findSignedAncestor(obj, imageRef)
+	if err != nil {
+		return nil, false, err
+	}
+	if ancestor == nil {
+		return nil, false, nil
+	}
+	ancestorRef := ObjectToReference(*ancestor)
+	log.Debug("signed ancestor is found: ", ancestor.GetKind(), " ", ancestor.GetName())
+
+	ancestorResult, err := verifyResource(*ancestor, ancestorImageRef, keyPath, vo)
+	if err != nil {
+		return nil, false, errors.Wrap(err, fmt.Sprintf("failed to verify the ancestor %s `%s`", ancestor.GetKind(), ancestor.GetName()))
+	}
+	result := &VerifyResourceResult{
+		Object:   obj,
+		Verified: false,
+		InScope:  true,
+		Signer:   ancestorResult.Signer,
+		Signers:  ancestorResult.Signers,
+		Ancestor: &ancestorRef,
+		Revoked:  ancestorResult.Revoked,
+	}
+	if !ancestorResult.Verified {
+		result.Diff = ancestorResult.Diff
+		return result, true, nil
+	}
+
+	tmpl, ok := findOwnerTemplate(ancestor.GetKind(), obj.GetKind())
+	if !ok {
+		return nil, false, errors.New(fmt.Sprintf("verification of %s generated by %s is not supported", obj.GetKind(), ancestor.GetKind()))
+	}
+	if tmpl.ancestorPath == "" {
+		result.Verified = true
+		return result, true, nil
+	}
+	// the template is taken from the signed manifest, so diffs allowed in the live ancestor (e.g. ignoreFields) are not inherited
+	_, manifestBytes := k8ssigutil.FindSingleYaml(concatYAMLFromImage, ancestor.GetAPIVersion(), ancestor.GetKind(), ancestor.GetName(), ancestor.GetNamespace())
+	diff, err := matchGeneratedResourceWithTemplate(obj, manifestBytes, tmpl, mo)
+	if err != nil {
+		return nil, false, err
+	}
+	result.Mutations = mo.mutations
+	diff = findParametersInBundle(concatYAMLFromImage).forGeneratedResource(*ancestor, tmpl).FilterDiff(obj, diff)
+	diff = mo.ignoreFields.FilterDiff(obj, diff)
+	diff = mo.fieldManagers.FilterDiff(obj, diff)
+	if diff != nil && diff.Size() > 0 {
+		result.Diff = diff
+		return result, true, nil
+	}
+	result.Verified = true
+	return result, true, nil
+}
+
+// compare the generated resource with the template in the signed manifest of its ancestor in both directions.
+// fields added by the server are tolerated only if they are listed in generatedResourceDefaults or normalizers,
+// and additions of known mutators (e.g. a sidecar injected into a pod) are removed before comparison.
+func matchGeneratedResourceWithTemplate(obj unstructured.Unstructured, manifestBytes []byte, tmpl *ownerTemplate, mo *resourceMatchOption) (*mapnode.DiffResult, error) {
+	obj = removeServiceAccountTokenVolume(obj)
+	if len(mo.knownMutators) > 0 {
+		var mnf map[string]interface{}
+		if err := yaml.Unmarshal(manifestBytes, &mnf); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal manifest")
+		}
+		obj, mo.mutations = mo.knownMutators.removeAdditionsWithManifest(obj, generatedObjectOfTemplate(mnf, tmpl))
+	}
+	objBytes, _ := json.Marshal(obj.Object)
+	objNode, err := mapnode.NewFromBytes(objBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize object node")
+	}
+	manifestNode, err := mapnode.NewFromYamlBytes(manifestBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize manifest node")
+	}
+	templateNode, ok := manifestNode.GetNode(tmpl.ancestorPath)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("`%s` is not found in the signed %s", tmpl.ancestorPath, tmpl.ancestorKind))
+	}
+	objNode = objNode.Mask(CommonResourceMaskKeys)
+	childNode := objNode
+	keyPrefix := ""
+	if tmpl.childPath != "" {
+		childNode, ok = objNode.GetNode(tmpl.childPath)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("`%s` is not found in %s `%s`", tmpl.childPath, obj.GetKind(), obj.GetName()))
+		}
+		keyPrefix = tmpl.childPath + "."
+	}
+	templateNode = templateNode.Mask(templateMaskKeys)
+
+	childMap := childNode.Ravel()
+	tmplMap := templateNode.Ravel()
+	items := []mapnode.Difference{}
+	for key, tmplVal := range tmplMap {
+		childVal, found := childMap[key]
+		if !found || !reflect.DeepEqual(childVal, tmplVal) {
+			items = append(items, mapnode.Difference{
+				Key:    keyPrefix + key,
+				Values: map[string]interface{}{"before": childVal, "after": tmplVal},
+			})
+		}
+	}
+	for key, childVal := range childMap {
+		if _, found := tmplMap[key]; !found {
+			items = append(items, mapnode.Difference{
+				Key:    keyPrefix + key,
+				Values: map[string]interface{}{"before": childVal, "after": nil},
+			})
+		}
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	tolerated := append(ObjectFieldBindingList{}, generatedResourceDefaults[obj.GetKind()]...)
+	for _, n := range mo.normalizers.normalizersFor(obj) {
+		tolerated = append(tolerated, additionsOf(n.MaskKeys(nil)...))
+	}
+	diff := tolerated.FilterDiff(obj, &mapnode.DiffResult{Items: items})
+	if diff.Size() == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(diff.Items, func(i, j int) bool {
+		return diff.Items[i].Key < diff.Items[j].Key
+	})
+	return diff, nil
+}
+
+// remove a service account token volume and its mounts which are added to a pod on server side.
+// a volume is regarded as the one only if it projects nothing but a token, `kube-root-ca.crt` and the namespace.
+func removeServiceAccountTokenVolume(obj unstructured.Unstructured) unstructured.Unstructured {
+	if obj.GetKind() != "Pod" {
+		return obj
+	}
+	obj = *obj.DeepCopy()
+	volumes, _, _ := unstructured.NestedSlice(obj.Object, "spec", "volumes")
+	removed := map[string]bool{}
+	keptVolumes := []interface{}{}
+	for _, v := range volumes {
+		vMap, _ := v.(map[string]interface{})
+		name, _ := vMap["name"].(string)
+		if strings.HasPrefix(name, serviceAccountTokenVolumePrefix) && isServiceAccountTokenVolume(vMap) {
+			removed[name] = true
+			continue
+		}
+		keptVolumes = append(keptVolumes, v)
+	}
+	if len(removed) == 0 {
+		return obj
+	}
+	if len(keptVolumes) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "spec", "volumes")
+	} else {
+		_ = unstructured.SetNestedSlice(obj.Object, keptVolumes, "spec", "volumes")
+	}
+	for _, listKey := range []string{"containers", "initContainers"} {
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", listKey)
+		for i, c := range containers {
+			cMap, _ := c.(map[string]interface{})
+			mounts, _, _ := unstructured.NestedSlice(cMap, "volumeMounts")
+			keptMounts := []interface{}{}
+			for _, m := range mounts {
+				mMap, _ := m.(map[string]interface{})
+				name, _ := mMap["name"].(string)
+				if removed[name] && mMap["mountPath"] == "/var/run/secrets/kubernetes.io/serviceaccount" {
+					continue
+				}
+				keptMounts = append(keptMounts, m)
+			}
+			if len(keptMounts) == 0 {
+				delete(cMap, "volumeMounts")
+			} else {
+				cMap["volumeMounts"] = keptMounts
+			}
+			containers[i] = cMap
+		}
+		if len(containers) > 0 {
+			_ = unstructured.SetNestedSlice(obj.Object, containers, "spec", listKey)
+		}
+	}
+	return obj
+}
+
+func isServiceAccountTokenVolume(volume map[string]interface{}) bool {
+	if len(volume) != 2 {
+		return false
+	}
+	sources, found, _ := unstructured.NestedSlice(volume, "projected", "sources")
+	if !found {
+		return false
+	}
+	for _, src := range sources {
+		srcMap, _ := src.(map[string]interface{})
+		for kind, val := range srcMap {
+			switch kind {
+			case "serviceAccountToken", "downwardAPI":
+			case "configMap":
+				cm, _ := val.(map[string]interface{})
+				if cm["name"] != "kube-root-ca.crt" {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rebase parameters of the ancestor onto the generated resource; only fields in the template are kept
+func (l ParameterList) forGeneratedResource(ancestor unstructured.Unstructured, tmpl *ownerTemplate) ParameterList {
+	params := ParameterList{}
+	for _, p := range l {
+		if !p.Objects.Match(ancestor) {
+			continue
+		}
+		fields := []string{}
+		for _, f := range p.Fields {
+			if !strings.HasPrefix(f, tmpl.ancestorPath+".") {
+				continue
+			}
+			field := strings.TrimPrefix(f, tmpl.ancestorPath+".")
+			if tmpl.childPath != "" {
+				field = tmpl.childPath + "." + field
+			}
+			fields = append(fields, field)
+		}
+		if len(fields) > 0 {
+			params = append(params, Parameter{Fields: fields, Constraint: p.Constraint})
+		}
+	}
+	return params
+}
+
+// return an object in the shape of the generated resource, which has the template of the ancestor at the child path
+func generatedObjectOfTemplate(mnf map[string]interface{}, tmpl *ownerTemplate) map[string]interface{} {
+	tmplMap, _, _ := unstructured.NestedMap(mnf, strings.Split(tmpl.ancestorPath, ".")...)
+	if tmpl.childPath == "" {
+		return tmplMap
+	}
+	obj := map[string]interface{}{}
+	_ = unstructured.SetNestedMap(obj, tmplMap, strings.Split(tmpl.childPath, ".")...)
+	return obj
+}
@@ -0,0 +1,164 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: sample-app
+spec:
+  template:
+    metadata:
+      creationTimestamp: null
+      labels:
+        app: sample-app
+    spec:
+      containers:
+      - name: app
+        image: sample-app:v1
+`
+
+const testPod = `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: sample-app-5d8f9c-abcde
+  labels:
+    app: sample-app
+    pod-template-hash: 5d8f9c
+spec:
+  containers:
+  - name: app
+    image: %s
+    terminationMessagePath: /dev/termination-log
+    imagePullPolicy: IfNotPresent
+    volumeMounts:
+    - name: kube-api-access-x7k2p
+      mountPath: /var/run/secrets/kubernetes.io/serviceaccount
+      readOnly: true
+%s
+  dnsPolicy: ClusterFirst
+  serviceAccountName: default
+  nodeName: node-1
+  tolerations:
+  - key: node.kubernetes.io/not-ready
+    operator: Exists
+    effect: NoExecute
+    tolerationSeconds: 300
+  volumes:
+  - name: kube-api-access-x7k2p
+    projected:
+      sources:
+      - serviceAccountToken:
+          path: token
+      - configMap:
+          name: kube-root-ca.crt
+%s
+`
+
+func loadTestObject(t *testing.T, yamlStr string) unstructured.Unstructured {
+	var obj unstructured.Unstructured
+	err := yaml.Unmarshal([]byte(yamlStr), &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestMatchGeneratedResourceWithTemplate(t *testing.T) {
+	tmpl, _ := findOwnerTemplate("Deployment", "Pod")
+
+	cases := []struct {
+		name         string
+		image        string
+		extra        string
+		extraSpec    string
+		expectedKeys []string
+	}{
+		{name: "defaulted fields are allowed", image: "sample-app:v1", expectedKeys: nil},
+		{name: "changed image", image: "sample-app:evil", expectedKeys: []string{"spec.containers.0.image"}},
+		{name: "injected container", image: "sample-app:v1", extra: "  - name: miner\n    image: miner:latest", expectedKeys: []string{"spec.containers.1.image", "spec.containers.1.name"}},
+		{
+			name:         "added privileged fields",
+			image:        "sample-app:v1",
+			extra:        "    securityContext:\n      privileged: true",
+			extraSpec:    "  hostPID: true\n  serviceAccount: admin",
+			expectedKeys: []string{"spec.containers.0.securityContext.privileged", "spec.hostPID", "spec.serviceAccount"},
+		},
+	}
+	for _, c := range cases {
+		pod := loadTestObject(t, fmt.Sprintf(testPod, c.image, c.extra, c.extraSpec))
+		diff, err := matchGeneratedResourceWithTemplate(pod, []byte(testDeployment), tmpl, &resourceMatchOption{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actualKeys := []string{}
+		if diff != nil {
+			actualKeys = diff.Keys()
+		}
+		if len(actualKeys) != len(c.expectedKeys) {
+			t.Errorf("%s: expected diff keys %v, but got %v", c.name, c.expectedKeys, actualKeys)
+			continue
+		}
+		for i := range actualKeys {
+			if actualKeys[i] != c.expectedKeys[i] {
+				t.Errorf("%s: expected diff keys %v, but got %v", c.name, c.expectedKeys, actualKeys)
+			}
+		}
+	}
+}
+
+func TestMatchGeneratedResourceWithKnownMutators(t *testing.T) {
+	tmpl, _ := findOwnerTemplate("Deployment", "Pod")
+	mutators := KnownMutatorList{{
+		Name:       "istio",
+		Containers: []ContainerPattern{{Name: "istio-*", Image: "docker.io/istio/proxyv2:*"}},
+		Volumes:    []VolumePattern{{Name: "istio-*", Sources: []string{"emptyDir"}}},
+	}}
+	injected := "  - name: istio-proxy\n    image: docker.io/istio/proxyv2:1.10.0"
+	injectedVolume := "  - name: istio-envoy\n    emptyDir: {}"
+	pod := loadTestObject(t, fmt.Sprintf(testPod, "sample-app:v1", injected, injectedVolume))
+
+	mo := &resourceMatchOption{knownMutators: mutators}
+	diff, err := matchGeneratedResourceWithTemplate(pod, []byte(testDeployment), tmpl, mo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != nil {
+		t.Errorf("a pod injected by a known mutator should match; diff: %s", diff)
+	}
+	if len(mo.mutations) != 1 || len(mo.mutations[0].Fields) != 2 {
+		t.Errorf("unexpected mutations: %+v", mo.mutations)
+	}
+
+	diff, err = matchGeneratedResourceWithTemplate(pod, []byte(testDeployment), tmpl, &resourceMatchOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil {
+		t.Errorf("an injected pod should not match without known mutators")
+	}
+}
@@ -0,0 +1,193 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// a document of this kind in a bundle defines parameters of the signed manifests.
+// it is signed together with the manifests, so the constraints cannot be changed after signing.
+const (
+	ManifestParametersAPIVersion = "k8s-manifest-sigstore/v1alpha1"
+	ManifestParametersKind       = "ManifestParameters"
+)
+
+var digestValuePattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+type ManifestParameters struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Spec       ManifestParametersSpec `json:"spec"`
+}
+
+type ManifestParametersSpec struct {
+	Parameters ParameterList `json:"parameters,omitempty"`
+}
+
+// Parameter is a set of fields which can have different values from the signed manifest
+// as long as the values satisfy the constraint.
+type Parameter struct {
+	Objects    ObjectReferenceList `json:"objects,omitempty"`
+	Fields     []string            `json:"fields"`
+	Constraint ParameterConstraint `json:"constraint,omitempty"`
+}
+
+type ParameterList []Parameter
+
+// ParameterConstraint is a condition of a parameter value. All of the specified conditions must be satisfied.
+type ParameterConstraint struct {
+	Regex  string          `json:"regex,omitempty"`
+	Enum   []string        `json:"enum,omitempty"`
+	Range  *ParameterRange `json:"range,omitempty"`
+	Digest bool            `json:"digest,omitempty"`
+}
+
+type ParameterRange struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+func isManifestParametersYAML(yamlBytes []byte) bool {
+	var obj unstructured.Unstructured
+	err := yaml.Unmarshal(yamlBytes, &obj)
+	if err != nil {
+		return false
+	}
+	return obj.GetAPIVersion() == ManifestParametersAPIVersion && obj.GetKind() == ManifestParametersKind
+}
+
+func LoadManifestParameters(yamlBytes []byte) (*ManifestParameters, error) {
+	var params *ManifestParameters
+	err := yaml.Unmarshal(yamlBytes, &params)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil || params.APIVersion != ManifestParametersAPIVersion || params.Kind != ManifestParametersKind {
+		return nil, errors.New(fmt.Sprintf("parameters must be defined with apiVersion `%s` and kind `%s`", ManifestParametersAPIVersion, ManifestParametersKind))
+	}
+	for i, p := range params.Spec.Parameters {
+		if len(p.Fields) == 0 {
+			return nil, errors.New(fmt.Sprintf("parameters[%v] has no fields", i))
+		}
+		if p.Constraint.Regex != "" {
+			if _, err := regexp.Compile(p.Constraint.Regex); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("parameters[%v] has an invalid regex", i))
+			}
+		}
+	}
+	return params, nil
+}
+
+// collect all parameters defined in a bundle
+func findParametersInBundle(concatYAMLFromImage []byte) ParameterList {
+	params := ParameterList{}
+	for _, yamlBytes := range k8ssigutil.SplitConcatYAMLs(concatYAMLFromImage) {
+		if !isManifestParametersYAML(yamlBytes) {
+			continue
+		}
+		tmpParams, err := LoadManifestParameters(yamlBytes)
+		if err != nil {
+			continue
+		}
+		params = append(params, tmpParams.Spec.Parameters...)
+	}
+	return params
+}
+
+// filter out diffs in parameter fields whose values in the object satisfy the constraints
+func (l ParameterList) FilterDiff(obj unstructured.Unstructured, diff *mapnode.DiffResult) *mapnode.DiffResult {
+	if diff == nil || len(l) == 0 {
+		return diff
+	}
+	objNode, _ := mapnode.NewFromMap(obj.Object)
+	for _, p := range l {
+		if !p.Objects.Match(obj) {
+			continue
+		}
+		paramDiff, otherDiff, _ := diff.FilterWithNode(p.Fields, objNode)
+		for _, d := range paramDiff.Items {
+			if !p.Constraint.Match(d.Values["before"]) {
+				otherDiff.Items = append(otherDiff.Items, d)
+			}
+		}
+		diff = otherDiff
+	}
+	return diff
+}
+
+func (c ParameterConstraint) Match(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	strVal := fmt.Sprint(value)
+	if c.Regex != "" {
+		if m, _ := regexp.MatchString(c.Regex, strVal); !m {
+			return false
+		}
+	}
+	if len(c.Enum) > 0 {
+		found := false
+		for _, e := range c.Enum {
+			if e == strVal {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.Range != nil {
+		num, err := strconv.ParseFloat(strVal, 64)
+		if err != nil {
+			return false
+		}
+		if c.Range.Min != nil && num < *c.Range.Min {
+			return false
+		}
+		if c.Range.Max != nil && num > *c.Range.Max {
+			return false
+		}
+	}
+	if c.Digest {
+		if !isDigestValue(strVal) {
+			return false
+		}
+	}
+	return true
+}
+
+// check if a value is a digest (`sha256:...`) or an image reference by digest
+func isDigestValue(val string) bool {
+	if digestValuePattern.MatchString(val) {
+		return true
+	}
+	_, err := name.NewDigest(val)
+	return err == nil
+}
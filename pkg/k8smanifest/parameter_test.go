@@ -0,0 +1,82 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"testing"
+
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+const testParameters = `
+apiVersion: k8s-manifest-sigstore/v1alpha1
+kind: ManifestParameters
+metadata:
+  name: sample-app-parameters
+spec:
+  parameters:
+  - objects:
+    - kind: Deployment
+    fields:
+    - spec.replicas
+    constraint:
+      range:
+        min: 1
+        max: 5
+  - fields:
+    - spec.template.spec.containers.*.image
+    constraint:
+      regex: "^registry.example.com/sample-app@"
+      digest: true
+  - fields:
+    - metadata.labels.env
+    constraint:
+      enum: ["dev", "stg", "prod"]
+`
+
+func TestParameterFilterDiff(t *testing.T) {
+	params, err := LoadManifestParameters([]byte(testParameters))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy := loadTestObject(t, testDeployment)
+	digestImage := "registry.example.com/sample-app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	cases := []struct {
+		name         string
+		key          string
+		value        interface{}
+		expectedSize int
+	}{
+		{name: "replicas in range", key: "spec.replicas", value: float64(3), expectedSize: 0},
+		{name: "replicas out of range", key: "spec.replicas", value: float64(10), expectedSize: 1},
+		{name: "image by digest", key: "spec.template.spec.containers.0.image", value: digestImage, expectedSize: 0},
+		{name: "image by tag", key: "spec.template.spec.containers.0.image", value: "registry.example.com/sample-app:latest", expectedSize: 1},
+		{name: "env in enum", key: "metadata.labels.env", value: "prod", expectedSize: 0},
+		{name: "env not in enum", key: "metadata.labels.env", value: "test", expectedSize: 1},
+		{name: "not a parameter", key: "spec.template.spec.serviceAccountName", value: "admin", expectedSize: 1},
+	}
+	for _, c := range cases {
+		diff := &mapnode.DiffResult{Items: []mapnode.Difference{
+			{Key: c.key, Values: map[string]interface{}{"before": c.value, "after": "placeholder"}},
+		}}
+		diff = params.Spec.Parameters.FilterDiff(deploy, diff)
+		if diff.Size() != c.expectedSize {
+			t.Errorf("%s: expected %v diffs, but got %v", c.name, c.expectedSize, diff.Size())
+		}
+	}
+}
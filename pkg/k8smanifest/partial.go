@@ -0,0 +1,166 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// annotation in a partial manifest in a bundle, which lists the signed field paths (comma separated)
+const SignedFieldsAnnotationKey = "k8s-manifest-sigstore/signed-fields"
+
+// fields which are always kept in a partial manifest to find the corresponding resource
+var partialManifestIdentityKeys = []string{
+	"apiVersion",
+	"kind",
+	"metadata.name",
+	"metadata.namespace",
+}
+
+// replace manifests in a dir with partial ones which have only the selected subtrees
+func makePartialManifestsInDir(dirPath string, selectors ObjectFieldBindingList) error {
+	return filepath.Walk(dirPath, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if path.Ext(info.Name()) != ".yaml" && path.Ext(info.Name()) != ".yml" {
+			return nil
+		}
+		yamlBytes, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return err
+		}
+		updated := false
+		newYAMLs := [][]byte{}
+		for _, y := range k8ssigutil.SplitConcatYAMLs(yamlBytes) {
+			var obj unstructured.Unstructured
+			if err := yaml.Unmarshal(y, &obj); err != nil || obj.GetKind() == "" || isManifestParametersYAML(y) {
+				newYAMLs = append(newYAMLs, y)
+				continue
+			}
+			ok, fields := selectors.Match(obj)
+			if !ok || len(fields) == 0 {
+				newYAMLs = append(newYAMLs, y)
+				continue
+			}
+			partial, err := makePartialManifest(y, fields)
+			if err != nil {
+				return errors.Wrap(err, "failed to make a partial manifest of "+obj.GetKind()+" "+obj.GetName())
+			}
+			newYAMLs = append(newYAMLs, partial)
+			updated = true
+		}
+		if !updated {
+			return nil
+		}
+		return ioutil.WriteFile(fpath, k8ssigutil.ConcatenateYAMLs(newYAMLs), info.Mode())
+	})
+}
+
+func makePartialManifest(yamlBytes []byte, fields []string) ([]byte, error) {
+	extracted, err := extractFields(yamlBytes, fields)
+	if err != nil {
+		return nil, err
+	}
+	return embedAnnotation(extracted, map[string]interface{}{
+		SignedFieldsAnnotationKey: strings.Join(fields, ","),
+	})
+}
+
+// extract the given fields and the identity fields from a YAML/JSON
+func extractFields(rawBytes []byte, fields []string) ([]byte, error) {
+	node, err := mapnode.NewFromYamlBytes(rawBytes)
+	if err != nil {
+		return nil, err
+	}
+	keys := append([]string{}, partialManifestIdentityKeys...)
+	keys = append(keys, fields...)
+	return []byte(node.Extract(keys).ToYaml()), nil
+}
+
+// return the signed fields if the manifest is a partial one
+func getSignedFields(manifestBytes []byte) []string {
+	val, ok := k8ssigutil.GetAnnotationsInYAML(manifestBytes)[SignedFieldsAnnotationKey]
+	if !ok || val == "" {
+		return nil
+	}
+	fields := []string{}
+	for _, f := range strings.Split(val, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// compare only the signed subtrees of the object with a partial manifest
+func partialMatch(obj unstructured.Unstructured, manifestBytes []byte, signedFields, maskKeys []string) (bool, *mapnode.DiffResult, error) {
+	objBytes, _ := json.Marshal(obj.Object)
+	partialObjBytes, err := extractFields(objBytes, signedFields)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to extract signed fields from object")
+	}
+	objNode, err := mapnode.NewFromYamlBytes(partialObjBytes)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to initialize object node")
+	}
+	mnfNode, err := mapnode.NewFromYamlBytes(manifestBytes)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to initialize manifest node")
+	}
+	maskedObjNode := objNode.Mask(maskKeys)
+	maskedMnfNode := mnfNode.Mask(maskKeys)
+	diff := maskedObjNode.Diff(maskedMnfNode)
+	if diff == nil || diff.Size() == 0 {
+		return true, nil, nil
+	}
+	return false, diff, nil
+}
+
+// parse field selectors in the form of `<kind>=<field>[,<field>...]` (e.g. `ConfigMap=data`, `Role=rules`)
+func ParseFieldSelectors(selectors []string) (ObjectFieldBindingList, error) {
+	bindings := ObjectFieldBindingList{}
+	for _, s := range selectors {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("field selector must be in the form of `<kind>=<field>[,<field>...]`; " + s)
+		}
+		fields := []string{}
+		for _, f := range strings.Split(parts[1], ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+		bindings = append(bindings, ObjectFieldBinding{
+			Objects: ObjectReferenceList{{Kind: parts[0]}},
+			Fields:  fields,
+		})
+	}
+	return bindings, nil
+}
@@ -0,0 +1,66 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testConfigMap = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: sample-config
+  labels:
+    team: %s
+data:
+  key: %s
+`
+
+func TestPartialMatch(t *testing.T) {
+	signedBytes := []byte(fmt.Sprintf(testConfigMap, "a", "value"))
+	partial, err := makePartialManifest(signedBytes, []string{"data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedFields := getSignedFields(partial)
+	if len(signedFields) != 1 || signedFields[0] != "data" {
+		t.Fatalf("expected signed fields [data], but got %v", signedFields)
+	}
+
+	cases := []struct {
+		name     string
+		team     string
+		value    string
+		expected bool
+	}{
+		{name: "same", team: "a", value: "value", expected: true},
+		{name: "unsigned field changed", team: "b", value: "value", expected: true},
+		{name: "signed field changed", team: "a", value: "evil", expected: false},
+	}
+	for _, c := range cases {
+		obj := loadTestObject(t, fmt.Sprintf(testConfigMap, c.team, c.value))
+		matched, diff, err := partialMatch(obj, partial, signedFields, CommonResourceMaskKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched != c.expected {
+			t.Errorf("%s: expected %v, but got %v; diff: %s", c.name, c.expected, matched, diff)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	kubeutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/kubeutil"
+)
+
+// RegistryOption configures connections to OCI registries of bundle images and signatures
+type RegistryOption struct {
+	// a CA bundle in PEM for registries with a private CA
+	CACertPath string `json:"caCert,omitempty"`
+	// registries (`<host>[:<port>]`) whose TLS certificates are not verified
+	InsecureRegistries []string `json:"insecureRegistries,omitempty"`
+	// registries (`<host>[:<port>]`) which are connected with plain HTTP
+	PlainHTTPRegistries []string `json:"plainHTTPRegistries,omitempty"`
+	// a docker config.json for registry credentials (if empty, the one of the user)
+	DockerConfigPath string `json:"dockerConfig,omitempty"`
+	// imagePullSecrets in the form of `<namespace>/<name>`, whose credentials are used in addition to the docker config
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// registries which are rewritten to their mirrors when pulling bundle images and signatures
+	Mirrors []RegistryMirror `json:"mirrors,omitempty"`
+}
+
+type RegistryMirror struct {
+	Registry string `json:"registry"`
+	Mirror   string `json:"mirror"`
+}
+
+// the registry option which is applied last
+var appliedRegistryOption = struct {
+	sync.Mutex
+	option *RegistryOption
+}{}
+
+// ConfigureRegistry applies a registry option to all registry connections of this process.
+// if nil or the same as the one applied last, nothing is changed, so this can be called for every loaded config.
+// imagePullSecrets are read only when the option is changed.
+func ConfigureRegistry(opt *RegistryOption) error {
+	if opt == nil {
+		return nil
+	}
+	appliedRegistryOption.Lock()
+	defer appliedRegistryOption.Unlock()
+	if appliedRegistryOption.option != nil && reflect.DeepEqual(*appliedRegistryOption.option, *opt) {
+		return nil
+	}
+	if err := configureRegistry(opt); err != nil {
+		return err
+	}
+	applied := *opt
+	appliedRegistryOption.option = &applied
+	return nil
+}
+
+func configureRegistry(opt *RegistryOption) error {
+	var caPEM []byte
+	if opt.CACertPath != "" {
+		var err error
+		caPEM, err = ioutil.ReadFile(opt.CACertPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to read registry CA bundle")
+		}
+	}
+	err := k8ssigutil.ConfigureRegistryTransport(caPEM, opt.InsecureRegistries, opt.PlainHTTPRegistries)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure registry transport")
+	}
+
+	mirrors := map[string]string{}
+	for _, m := range opt.Mirrors {
+		mirrors[m.Registry] = m.Mirror
+	}
+	k8ssigutil.SetRegistryMirrors(mirrors)
+
+	if opt.DockerConfigPath == "" && len(opt.ImagePullSecrets) == 0 {
+		return nil
+	}
+	dockerConfigs := [][]byte{}
+	if opt.DockerConfigPath != "" {
+		cfg, err := ioutil.ReadFile(opt.DockerConfigPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to read docker config")
+		}
+		dockerConfigs = append(dockerConfigs, cfg)
+	} else if cfg := k8ssigutil.DefaultDockerConfig(); cfg != nil {
+		dockerConfigs = append(dockerConfigs, cfg)
+	}
+	for _, secretRef := range opt.ImagePullSecrets {
+		cfg, err := loadImagePullSecret(secretRef)
+		if err != nil {
+			return err
+		}
+		dockerConfigs = append(dockerConfigs, cfg)
+	}
+	return k8ssigutil.SetDockerConfigs(dockerConfigs)
+}
+
+// return a docker config JSON in a secret of `kubernetes.io/dockerconfigjson` or `kubernetes.io/dockercfg`
+func loadImagePullSecret(secretRef string) ([]byte, error) {
+	parts := strings.Split(secretRef, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New(fmt.Sprintf("imagePullSecret must be `<namespace>/<name>`, but `%s`", secretRef))
+	}
+	secret, err := kubeutil.GetResource("v1", "Secret", parts[0], parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get imagePullSecret")
+	}
+	data, _ := secret.Object["data"].(map[string]interface{})
+	for _, key := range []string{".dockerconfigjson", ".dockercfg"} {
+		encoded, ok := data[key].(string)
+		if !ok {
+			continue
+		}
+		cfg, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode imagePullSecret")
+		}
+		return cfg, nil
+	}
+	return nil, errors.New(fmt.Sprintf("docker config is not found in secret `%s`", secretRef))
+}
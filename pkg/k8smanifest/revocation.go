@@ -0,0 +1,381 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	kubeutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/kubeutil"
+)
+
+const (
+	RevocationListAPIVersion = "k8s-manifest-sigstore/v1alpha1"
+	RevocationListKind       = "RevocationList"
+
+	defaultRevocationListConfigMapKey = "revocation.yaml"
+	// a loaded revocation list is reused until this interval passes
+	DefaultRevocationListRefreshInterval = 5 * time.Minute
+)
+
+// RevocationList is a list of revoked signers, keys, certificates and bundles.
+// The list itself must be signed, in the same way as other manifests.
+type RevocationList struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Spec       RevocationListSpec     `json:"spec"`
+}
+
+type RevocationListSpec struct {
+	Revocations []Revocation `json:"revocations,omitempty"`
+}
+
+// Revocation revokes signatures which match any of the conditions
+type Revocation struct {
+	Signers SignerList `json:"signers,omitempty"`
+	// sha256 of a DER public key, e.g. `sha256:0123...`, or a fingerprint of an OpenPGP primary key for gpg backend
+	KeyFingerprints []string `json:"keyFingerprints,omitempty"`
+	// serial numbers of certificates in hex or decimal
+	CertificateSerials []string `json:"certificateSerials,omitempty"`
+	// digests of bundle images, e.g. `sha256:0123...`
+	BundleDigests []string `json:"bundleDigests,omitempty"`
+	// signatures made at or after this time are revoked. if empty, all of the matched signatures are revoked.
+	// a signature is exempted only by a verified integrated time of Rekor, because the signed-at annotation is set by the signer
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty"`
+	Reason        string     `json:"reason,omitempty"`
+}
+
+// RevocationListSource is where a signed revocation list is loaded from. One of File, ConfigMap and Image is used.
+type RevocationListSource struct {
+	File      string              `json:"file,omitempty"`
+	ConfigMap *ConfigMapReference `json:"configMap,omitempty"`
+	// a bundle image which contains the list
+	Image string `json:"image,omitempty"`
+	// a key and signers for verifying the list (if key is empty, do key-less verification)
+	Key     string     `json:"key,omitempty"`
+	Signers SignerList `json:"signers,omitempty"`
+	// how long a loaded list is reused, e.g. `1m`, `1h` (if empty, 5m)
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+}
+
+type ConfigMapReference struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// a key in data (if empty, `revocation.yaml`)
+	Key string `json:"key,omitempty"`
+}
+
+// RevokedError is returned when all of the valid signatures are revoked
+type RevokedError struct {
+	Reason string
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("signature is revoked; %s", e.Reason)
+}
+
+// signatureIdentity is a set of attributes of a verified signature which can be revoked
+type signatureIdentity struct {
+	Signer         string
+	KeyFingerprint string
+	CertSerial     string
+	BundleDigest   string
+	// verified integrated time of Rekor only; the signed-at annotation can be backdated with a leaked key
+	IntegratedTime *time.Time
+}
+
+// revocation lists loaded for verifications, keyed by their sources
+type revocationListCache struct {
+	sync.Mutex
+	entries map[string]*revocationListCacheEntry
+}
+
+type revocationListCacheEntry struct {
+	list     *RevocationList
+	loadedAt time.Time
+}
+
+var revocationLists = &revocationListCache{entries: map[string]*revocationListCacheEntry{}}
+
+// return a cached revocation list, or load it again if the refresh interval has passed.
+// if the list cannot be loaded, a RevokedError is returned instead of the old list, so that
+// signatures are not accepted while the source is unreachable (fail closed)
+func (c *revocationListCache) get(src *RevocationListSource, now time.Time, load func(*RevocationListSource) (*RevocationList, error)) (*RevocationList, error) {
+	if src == nil {
+		return nil, nil
+	}
+	interval := DefaultRevocationListRefreshInterval
+	if src.RefreshInterval != "" {
+		var err error
+		interval, err = parseDuration(src.RefreshInterval)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse refreshInterval of revocation list")
+		}
+	}
+	keyBytes, _ := json.Marshal(src)
+	key := string(keyBytes)
+
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[key]; ok && now.Sub(e.loadedAt) < interval {
+		return e.list, nil
+	}
+	list, err := load(src)
+	if err != nil {
+		delete(c.entries, key)
+		return nil, &RevokedError{Reason: fmt.Sprintf("revocation list is not available; %s", err.Error())}
+	}
+	c.entries[key] = &revocationListCacheEntry{list: list, loadedAt: now}
+	return list, nil
+}
+
+// load a revocation list and verify its signature
+func LoadRevocationList(src *RevocationListSource) (*RevocationList, error) {
+	if src == nil {
+		return nil, nil
+	}
+	var listYAML []byte
+	imageRef := src.Image
+	switch {
+	case src.Image != "":
+		var err error
+		listYAML, err = getManifestsInImage(src.Image)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get revocation list image")
+		}
+	case src.File != "":
+		var err error
+		listYAML, err = ioutil.ReadFile(src.File)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read revocation list file")
+		}
+	case src.ConfigMap != nil:
+		var err error
+		listYAML, err = loadRevocationListInConfigMap(src.ConfigMap)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("one of file, configMap and image is required for revocation list")
+	}
+
+	if src.Image == "" {
+		// a file or a ConfigMap is a signed YAML which has a bundle image ref in annotation
+		imageRef = k8ssigutil.GetAnnotationsInYAML(listYAML)[ImageRefAnnotationKey]
+		if imageRef == "" {
+			return nil, errors.New("revocation list is not signed")
+		}
+		result, err := Verify(listYAML, imageRef, src.Key, &VerifyOption{Signers: src.Signers})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to verify revocation list")
+		}
+		if !result.Verified {
+			return nil, errors.New(fmt.Sprintf("revocation list is not verified; %s", result.String()))
+		}
+	} else {
+		verified, signerNames, err := verifyImageSignatures(imageRef, src.Key, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to verify revocation list")
+		}
+		if !verified || !src.Signers.MatchAny(signerNames) {
+			return nil, errors.New("revocation list is not signed by a valid signer")
+		}
+	}
+
+	for _, doc := range k8ssigutil.SplitConcatYAMLs(listYAML) {
+		var list RevocationList
+		if err := yaml.Unmarshal(doc, &list); err != nil {
+			continue
+		}
+		if list.Kind == RevocationListKind {
+			return &list, nil
+		}
+	}
+	return nil, errors.New("RevocationList is not found")
+}
+
+func loadRevocationListInConfigMap(ref *ConfigMapReference) ([]byte, error) {
+	cm, err := kubeutil.GetResource("v1", "ConfigMap", ref.Namespace, ref.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get revocation list configmap")
+	}
+	key := ref.Key
+	if key == "" {
+		key = defaultRevocationListConfigMapKey
+	}
+	data, _ := cm.Object["data"].(map[string]interface{})
+	listStr, ok := data[key].(string)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("`%s` is not found in configmap `%s/%s`", key, ref.Namespace, ref.Name))
+	}
+	return []byte(listStr), nil
+}
+
+// return a reason if the signature is revoked
+func (l *RevocationList) revoked(id signatureIdentity) (bool, string) {
+	if l == nil {
+		return false, ""
+	}
+	for _, r := range l.Spec.Revocations {
+		if matched, what := r.match(id); matched {
+			reason := what
+			if r.Reason != "" {
+				reason = fmt.Sprintf("%s (%s)", what, r.Reason)
+			}
+			return true, reason
+		}
+	}
+	return false, ""
+}
+
+func (r Revocation) match(id signatureIdentity) (bool, string) {
+	// a signature without a verified integrated time is regarded as made after the effective date
+	if r.EffectiveFrom != nil && id.IntegratedTime != nil && id.IntegratedTime.Before(*r.EffectiveFrom) {
+		return false, ""
+	}
+	if id.Signer != "" && len(r.Signers) > 0 && r.Signers.Match(id.Signer) {
+		return true, fmt.Sprintf("signer `%s` is revoked", id.Signer)
+	}
+	if id.KeyFingerprint != "" {
+		for _, fp := range r.KeyFingerprints {
+			if normalizeHex(fp) == normalizeHex(id.KeyFingerprint) {
+				return true, fmt.Sprintf("key `%s` is revoked", id.KeyFingerprint)
+			}
+		}
+	}
+	if id.CertSerial != "" {
+		for _, s := range r.CertificateSerials {
+			if normalizeHex(s) == id.CertSerial || s == certSerialDecimal(id.CertSerial) {
+				return true, fmt.Sprintf("certificate `%s` is revoked", id.CertSerial)
+			}
+		}
+	}
+	if id.BundleDigest != "" {
+		for _, d := range r.BundleDigests {
+			if normalizeHex(d) == normalizeHex(id.BundleDigest) {
+				return true, fmt.Sprintf("bundle `%s` is revoked", id.BundleDigest)
+			}
+		}
+	}
+	return false, ""
+}
+
+// return a lowercase hex without `sha256:` prefix and colons
+func normalizeHex(s string) string {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "sha256:")
+	return strings.ReplaceAll(s, ":", "")
+}
+
+func certSerialDecimal(hexSerial string) string {
+	b, err := hex.DecodeString(hexSerial)
+	if err != nil {
+		return ""
+	}
+	n := new(big.Int).SetBytes(b)
+	return n.String()
+}
+
+// return `sha256:<hex>` of a DER public key
+func publicKeyFingerprint(pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(der))
+}
+
+// signatureChecker rejects verified signatures by policies which do not depend on backends
+type signatureChecker struct {
+	TimePolicy *SigningTimePolicy
+	Revocation *RevocationList
+	// if not empty, only signatures of a helm chart rendered with values files of this digest are accepted
+	HelmValuesDigest string
+}
+
+// check a verified signature. keyFingerprint is used only when the signature has no certificate
+func (c *signatureChecker) check(sp cosign.SignedPayload, signerName, keyFingerprint string, now time.Time) error {
+	var timePolicy *SigningTimePolicy
+	if c != nil {
+		timePolicy = c.TimePolicy
+	}
+	if err := timePolicy.check(sp, now); err != nil {
+		return err
+	}
+	if err := c.checkHelmValuesDigest(sp); err != nil {
+		return err
+	}
+	if c == nil || c.Revocation == nil {
+		return nil
+	}
+	id, err := identityOf(sp, signerName, keyFingerprint)
+	if err != nil {
+		return err
+	}
+	if revoked, reason := c.Revocation.revoked(id); revoked {
+		return &RevokedError{Reason: reason}
+	}
+	return nil
+}
+
+func (c *signatureChecker) checkHelmValuesDigest(sp cosign.SignedPayload) error {
+	if c == nil || c.HelmValuesDigest == "" {
+		return nil
+	}
+	ss := payload.SimpleContainerImage{}
+	if err := json.Unmarshal(sp.Payload, &ss); err != nil {
+		return errors.Wrap(err, "failed to unmarshal payload")
+	}
+	digest, _ := ss.Optional[HelmValuesDigestAnnotationKey].(string)
+	if digest != c.HelmValuesDigest {
+		return errors.New(fmt.Sprintf("helm values digest in the signature `%s` does not match with `%s`", digest, c.HelmValuesDigest))
+	}
+	return nil
+}
+
+func identityOf(sp cosign.SignedPayload, signerName, keyFingerprint string) (signatureIdentity, error) {
+	id := signatureIdentity{Signer: signerName, KeyFingerprint: keyFingerprint}
+	ss := payload.SimpleContainerImage{}
+	if err := json.Unmarshal(sp.Payload, &ss); err != nil {
+		return id, errors.Wrap(err, "failed to unmarshal payload")
+	}
+	id.BundleDigest = ss.Critical.Image.DockerManifestDigest
+	if sp.Cert != nil {
+		id.KeyFingerprint = publicKeyFingerprint(sp.Cert.PublicKey)
+		id.CertSerial = fmt.Sprintf("%x", sp.Cert.SerialNumber)
+	}
+	integratedTime, _, _, err := signingTimesOf(sp)
+	if err != nil {
+		return id, err
+	}
+	id.IntegratedTime = integratedTime
+	return id, nil
+}
@@ -0,0 +1,117 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/sigstore/cosign/pkg/cosign"
+)
+
+const testRevocationListYAML = `
+apiVersion: k8s-manifest-sigstore/v1alpha1
+kind: RevocationList
+metadata:
+  name: revocation
+spec:
+  revocations:
+  - signers:
+    - leaver@example.com
+    reason: left the team
+  - keyFingerprints:
+    - sha256:AB:CD:EF
+    effectiveFrom: 2021-05-01T00:00:00Z
+  - certificateSerials:
+    - "255"
+  - bundleDigests:
+    - sha256:0123
+`
+
+func TestRevocationList(t *testing.T) {
+	var list RevocationList
+	if err := yaml.Unmarshal([]byte(testRevocationListYAML), &list); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	after := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		name    string
+		id      signatureIdentity
+		revoked bool
+	}{
+		{name: "revoked signer", id: signatureIdentity{Signer: "leaver@example.com"}, revoked: true},
+		{name: "valid signer", id: signatureIdentity{Signer: "member@example.com"}, revoked: false},
+		{name: "key used after rotation", id: signatureIdentity{KeyFingerprint: "sha256:abcdef", IntegratedTime: &after}, revoked: true},
+		{name: "key used before rotation", id: signatureIdentity{KeyFingerprint: "sha256:abcdef", IntegratedTime: &before}, revoked: false},
+		{name: "key without integrated time", id: signatureIdentity{KeyFingerprint: "sha256:abcdef"}, revoked: true},
+		{name: "cert serial in decimal", id: signatureIdentity{CertSerial: "ff"}, revoked: true},
+		{name: "revoked bundle", id: signatureIdentity{BundleDigest: "sha256:0123"}, revoked: true},
+		{name: "other bundle", id: signatureIdentity{BundleDigest: "sha256:4567"}, revoked: false},
+	}
+	for _, tc := range testcases {
+		revoked, reason := list.revoked(tc.id)
+		if revoked != tc.revoked {
+			t.Errorf("%s: expected revoked=%v, got %v (%s)", tc.name, tc.revoked, revoked, reason)
+		}
+	}
+
+	// a signed-at annotation backdated with a leaked key does not exempt the signature
+	backdated := cosign.SignedPayload{Payload: []byte(fmt.Sprintf(`{"critical":{"image":{"docker-manifest-digest":"sha256:4567"}},"optional":{"%s":"%s"}}`, SignedAtAnnotationKey, before.Format(time.RFC3339)))}
+	id, err := identityOf(backdated, "", "sha256:abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := list.revoked(id); !revoked {
+		t.Errorf("expected a signature with a backdated signed-at to be revoked")
+	}
+}
+
+func TestRevocationListCache(t *testing.T) {
+	c := &revocationListCache{entries: map[string]*revocationListCacheEntry{}}
+	src := &RevocationListSource{File: "revocation.yaml", RefreshInterval: "1m"}
+	loaded := 0
+	var loadErr error
+	load := func(*RevocationListSource) (*RevocationList, error) {
+		loaded++
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return &RevocationList{Kind: RevocationListKind}, nil
+	}
+	now := time.Now()
+	if _, err := c.get(src, now, load); err != nil {
+		t.Fatal(err)
+	}
+	// the list is reused within the refresh interval
+	if _, err := c.get(src, now.Add(30*time.Second), load); err != nil || loaded != 1 {
+		t.Errorf("expected the cached list, but loaded %d times (err: %v)", loaded, err)
+	}
+	// the old list is not used if the source is unreachable after the interval
+	loadErr = errors.New("connection refused")
+	_, err := c.get(src, now.Add(2*time.Minute), load)
+	if _, ok := err.(*RevokedError); !ok || loaded != 2 {
+		t.Errorf("expected RevokedError after a failed refresh, but got %v (loaded %d times)", err, loaded)
+	}
+	if _, err := c.get(src, now.Add(2*time.Minute+time.Second), load); err == nil {
+		t.Error("expected an error while the source is unreachable")
+	}
+}
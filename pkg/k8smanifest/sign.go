@@ -18,20 +18,20 @@ package k8smanifest
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
 	"github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
 
-	cosigncli "github.com/sigstore/cosign/cmd/cosign/cli"
 	cremote "github.com/sigstore/cosign/pkg/cosign/remote"
 )
 
@@ -43,9 +43,121 @@ const (
 	BundleAnnotationKey      = "cosign.sigstore.dev/bundle"
 )
 
-func Sign(inputDir, imageRef, keyPath, output string, updateAnnotation bool) ([]byte, error) {
+const (
+	kustomizeBaseDirName = "base"
+	renderedDirName      = "rendered"
+	parametersFileName   = "manifest-parameters.yaml"
+)
+
+// SignOption is an optional configuration for signing
+type SignOption struct {
+	// used only when the input is a helm chart dir
+	Helm *HelmSignOption
+	// a file of ManifestParameters which is bundled with the manifests
+	ParametersFile string
+	// if a manifest matches, only the selected fields of it are signed (e.g. `data` of ConfigMap)
+	FieldSelectors ObjectFieldBindingList
+	// if true, only a signature is added to an existing bundle image as a co-signature
+	AppendSignature bool
+	// signer backend (if empty, cosign)
+	Backend *BackendOption
+	// if true, a signing time is embedded in the signed payload
+	Timestamp bool
+	// if not 0, an expiry (signing time + Expiry) is embedded in the signed payload together with a signing time
+	Expiry time.Duration
+	// if true, the given image ref is written into a signed YAML as it is, instead of a digest-pinned ref
+	UnpinnedRef bool
+}
+
+// return annotations of the signed payload which are specified by the option
+func (so *SignOption) payloadAnnotations() map[string]interface{} {
+	if so == nil || (!so.Timestamp && so.Expiry <= 0) {
+		return map[string]interface{}{}
+	}
+	return signingTimeAnnotations(time.Now(), so.Expiry)
+}
+
+func Sign(inputDir, imageRef, keyPath, output string, updateAnnotation bool, so *SignOption) ([]byte, error) {
+	if so != nil && so.AppendSignature {
+		return nil, appendSignature(imageRef, keyPath, so.Backend, so.payloadAnnotations())
+	}
+
+	// for a kustomization dir or a helm chart dir, both the original files and the rendered manifests are signed,
+	// and the rendered manifests are used for generating a signed YAML
+	bundleDir := inputDir
+	manifestDir := inputDir
+	sigAnnotations := so.payloadAnnotations()
+	if k8ssigutil.IsHelmChartDir(inputDir) {
+		ho := &HelmSignOption{}
+		if so != nil && so.Helm != nil {
+			ho = so.Helm
+		}
+		tmpDir, valuesDigest, err := prepareHelmBundleDir(inputDir, ho)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to prepare manifests in a helm chart dir")
+		}
+		defer os.RemoveAll(tmpDir)
+		bundleDir = tmpDir
+		manifestDir = filepath.Join(tmpDir, renderedDirName)
+		sigAnnotations[HelmValuesDigestAnnotationKey] = valuesDigest
+	} else if k8ssigutil.IsKustomizationDir(inputDir) {
+		tmpDir, err := prepareKustomizeBundleDir(inputDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to prepare manifests in a kustomization dir")
+		}
+		defer os.RemoveAll(tmpDir)
+		bundleDir = tmpDir
+		manifestDir = filepath.Join(tmpDir, renderedDirName)
+	}
+
+	// the input is copied when files in the bundle are added or modified
+	needBundleCopy := so != nil && (so.ParametersFile != "" || len(so.FieldSelectors) > 0)
+	if needBundleCopy && bundleDir == inputDir {
+		tmpDir, err := ioutil.TempDir("", "kubectl-sigstore-bundle-dir")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create a bundle dir")
+		}
+		defer os.RemoveAll(tmpDir)
+		err = k8ssigutil.CopyDir(inputDir, filepath.Join(tmpDir, filepath.Base(inputDir)))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to copy an input file/dir")
+		}
+		bundleDir = tmpDir
+	}
+
+	// only the selected subtrees of manifests are signed
+	if so != nil && len(so.FieldSelectors) > 0 {
+		partialDir := bundleDir
+		if manifestDir != inputDir {
+			// keep the full rendered manifests for generating a signed YAML
+			fullDir, err := ioutil.TempDir("", "kubectl-sigstore-rendered-dir")
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to create a temp dir")
+			}
+			defer os.RemoveAll(fullDir)
+			err = k8ssigutil.CopyDir(manifestDir, fullDir)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to copy rendered manifests")
+			}
+			partialDir = manifestDir
+			manifestDir = fullDir
+		}
+		err := makePartialManifestsInDir(partialDir, so.FieldSelectors)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to make partial manifests")
+		}
+	}
+
+	// parameters are signed together with the manifests
+	if so != nil && so.ParametersFile != "" {
+		err := addParametersToBundleDir(so.ParametersFile, bundleDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to add parameters")
+		}
+	}
+
 	var inputDataBuffer bytes.Buffer
-	err := k8ssigutil.TarGzCompress(inputDir, &inputDataBuffer)
+	err := k8ssigutil.TarGzCompress(bundleDir, &inputDataBuffer)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to compress an input file/dir")
 	}
@@ -53,18 +165,26 @@ func Sign(inputDir, imageRef, keyPath, output string, updateAnnotation bool) ([]
 
 	if imageRef != "" {
 		// upload files as image
-		err := uploadFileToRegistry(inputDataBuffer.Bytes(), imageRef)
+		digestRef, err := uploadFileToRegistry(inputDataBuffer.Bytes(), imageRef)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to upload image with manifest")
 		}
+		// the uploaded image is signed and referred by digest, so that a re-pointed tag is not trusted
+		if so == nil || !so.UnpinnedRef {
+			imageRef = digestRef
+		}
 		// sign the image
-		err = signImage(imageRef, keyPath)
+		var bo *BackendOption
+		if so != nil {
+			bo = so.Backend
+		}
+		err = signImage(imageRef, keyPath, sigAnnotations, bo)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to sign image")
 		}
 		if updateAnnotation {
 			// generate a signed YAML file
-			signedBytes, err = generateSignedYAMLManifest(inputDir, imageRef, nil)
+			signedBytes, err = generateSignedYAMLManifest(manifestDir, imageRef, nil)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to generate a signed YAML")
 			}
@@ -81,17 +201,81 @@ func Sign(inputDir, imageRef, keyPath, output string, updateAnnotation bool) ([]
 	return signedBytes, nil
 }
 
-func uploadFileToRegistry(inputData []byte, imageRef string) error {
-	dir, err := ioutil.TempDir("", "kubectl-sigstore-temp-dir")
+// copy a kustomization dir as `base` and write the output of `kustomize build` into `rendered` dir
+func prepareKustomizeBundleDir(inputDir string) (string, error) {
+	dir, err := ioutil.TempDir("", "kubectl-sigstore-kustomize-dir")
+	if err != nil {
+		return "", err
+	}
+	err = k8ssigutil.CopyDir(inputDir, filepath.Join(dir, kustomizeBaseDirName))
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	rendered, err := k8ssigutil.KustomizeBuild(inputDir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	renderedDir := filepath.Join(dir, renderedDirName)
+	err = os.MkdirAll(renderedDir, 0755)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	err = ioutil.WriteFile(filepath.Join(renderedDir, "manifest.yaml"), rendered, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// add a signature to an existing bundle image, so that it has signatures from multiple signers
+func appendSignature(imageRef, keyPath string, bo *BackendOption, sigAnnotations map[string]interface{}) error {
+	if imageRef == "" {
+		return errors.New("imageRef is empty")
+	}
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse image ref")
+	}
+	// the bundle must exist, otherwise a co-signature is meaningless
+	_, err = k8ssigutil.PullImage(ref.String())
+	if err != nil {
+		return errors.Wrap(err, "failed to find an existing bundle image")
+	}
+	err = signImage(imageRef, keyPath, sigAnnotations, bo)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign image")
+	}
+	return nil
+}
+
+func addParametersToBundleDir(paramsPath, bundleDir string) error {
+	paramsBytes, err := ioutil.ReadFile(paramsPath)
 	if err != nil {
 		return err
 	}
+	_, err = LoadManifestParameters(paramsBytes)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(bundleDir, parametersFileName), paramsBytes, 0644)
+}
+
+// upload files as an image, and return a digest-pinned ref of the image (e.g. `bundle:dev@sha256:...`)
+func uploadFileToRegistry(inputData []byte, imageRef string) (string, error) {
+	dir, err := ioutil.TempDir("", "kubectl-sigstore-temp-dir")
+	if err != nil {
+		return "", err
+	}
 	defer os.RemoveAll(dir)
 
 	fpath := filepath.Join(dir, "manifest.yaml")
 	err = ioutil.WriteFile(fpath, inputData, 0644)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	files := []cremote.File{
@@ -100,38 +284,39 @@ func uploadFileToRegistry(inputData []byte, imageRef string) error {
 
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	_, err = cremote.UploadFiles(ref, files)
+	digester, err := cremote.UploadFiles(ref, files)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	digest, err := digester.Digest()
+	if err != nil {
+		return "", err
+	}
+	return pinnedImageRef(imageRef, digest.String()), nil
 }
 
-func signImage(imageRef, keyPath string) error {
-	// TODO: check usecase for yaml signing
-	imageAnnotation := map[string]interface{}{}
-
-	// TODO: check sk (security key) and idToken (identity token for cert from fulcio)
-	sk := false
-	idToken := ""
-
-	// TODO: handle the case that COSIGN_EXPERIMENTAL env var is not set
-
-	opt := cosigncli.SignOpts{
-		Annotations: imageAnnotation,
-		Sk:          sk,
-		IDToken:     idToken,
+// return `<imageRef>@<digest>`. a tag in the ref is kept for readability
+func pinnedImageRef(imageRef, digest string) string {
+	if i := strings.Index(imageRef, "@"); i >= 0 {
+		imageRef = imageRef[:i]
 	}
+	return imageRef + "@" + digest
+}
 
-	if keyPath != "" {
-		opt.KeyRef = keyPath
-		opt.Pf = cosigncli.GetPass
+func signImage(imageRef, keyPath string, imageAnnotation map[string]interface{}, bo *BackendOption) error {
+	opt, cleanup, err := bo.withKeyPath(keyPath).resolveKey(privateKeySecretDataKey)
+	defer cleanup()
+	if err != nil {
+		return err
 	}
-
-	return cosigncli.SignCmd(context.Background(), opt, imageRef, true, "", false, false)
+	signer, err := NewSignerBackend(opt)
+	if err != nil {
+		return err
+	}
+	return signer.Sign(imageRef, imageAnnotation)
 }
 
 func generateSignedYAMLManifest(inputDir, imageRef string, sigMaps map[string][]byte) ([]byte, error) {
@@ -154,18 +339,56 @@ func generateSignedYAMLManifest(inputDir, imageRef string, sigMaps map[string][]
 	signedYAMLs := [][]byte{}
 	sumErr := []string{}
 	for _, yaml := range yamls {
-		signedYAML, err := embedAnnotation(yaml, annotationMap)
-		if err != nil {
-			sumErr = append(sumErr, err.Error())
-			continue
+		// a file is edited as it is, so that comments, key order and document separators are kept in a signed YAML
+		if !hasManifestParametersYAML(yaml) {
+			signedYAML, err := embedAnnotationPreservingFormat(yaml, annotationMap)
+			if err == nil {
+				signedYAMLs = append(signedYAMLs, signedYAML)
+				continue
+			}
+			log.Debug("failed to embed annotation preserving format, so re-encode YAMLs; ", err.Error())
+		}
+		for _, splitYAML := range k8ssigutil.SplitConcatYAMLs(yaml) {
+			// parameters are not deployed
+			if isManifestParametersYAML(splitYAML) {
+				continue
+			}
+			signedYAML, err := embedAnnotation(splitYAML, annotationMap)
+			if err != nil {
+				sumErr = append(sumErr, err.Error())
+				continue
+			}
+			signedYAMLs = append(signedYAMLs, signedYAML)
 		}
-		signedYAMLs = append(signedYAMLs, signedYAML)
 	}
 	if len(signedYAMLs) == 0 && len(sumErr) > 0 {
 		return nil, errors.New(fmt.Sprintf("failed to embed annotation to YAMLs; %s", strings.Join(sumErr, "; ")))
 	}
-	signedConcatYAML := k8ssigutil.ConcatenateYAMLs(signedYAMLs)
-	return signedConcatYAML, nil
+	return concatenateYAMLFiles(signedYAMLs), nil
+}
+
+func hasManifestParametersYAML(yaml []byte) bool {
+	for _, splitYAML := range k8ssigutil.SplitConcatYAMLs(yaml) {
+		if isManifestParametersYAML(splitYAML) {
+			return true
+		}
+	}
+	return false
+}
+
+// concatenate YAML files with a document separator without changing their contents
+func concatenateYAMLFiles(yamls [][]byte) []byte {
+	var buf bytes.Buffer
+	for i, y := range yamls {
+		if i > 0 {
+			if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+				buf.WriteString("\n")
+			}
+			buf.WriteString("---\n")
+		}
+		buf.Write(y)
+	}
+	return buf.Bytes()
 }
 
 func embedAnnotation(yamlBytes []byte, annotationMap map[string]interface{}) ([]byte, error) {
@@ -0,0 +1,40 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import "testing"
+
+func TestPinnedImageRef(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testcases := map[string]string{
+		"bundle-bar:dev":                          "bundle-bar:dev@" + digest,
+		"registry.example.com:5000/bundle-bar":    "registry.example.com:5000/bundle-bar@" + digest,
+		"bundle-bar:dev@sha256:00000000000000000": "bundle-bar:dev@" + digest,
+	}
+	for imageRef, expected := range testcases {
+		if actual := pinnedImageRef(imageRef, digest); actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+		// refs without a valid digest are rejected before accessing a registry
+		if err := checkPinnedBundleRef(imageRef); err == nil {
+			t.Errorf("a ref without digest must be rejected: %s", imageRef)
+		}
+		if err := checkPinnedBundleRef(expected); err != nil {
+			t.Errorf("a ref pinned by digest must be accepted: %s; %s", expected, err.Error())
+		}
+	}
+}
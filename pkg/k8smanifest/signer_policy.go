@@ -0,0 +1,114 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// SignerPolicy requires signatures from multiple signers (e.g. two-person integrity).
+// All of the conditions must be satisfied.
+type SignerPolicy struct {
+	// minimum number of distinct valid signers which match with Signers (if empty, any signer)
+	Threshold int        `json:"threshold,omitempty"`
+	Signers   SignerList `json:"signers,omitempty"`
+	// each role requires its own threshold of signers (e.g. one from dev and one from security)
+	Roles []SignerRole `json:"roles,omitempty"`
+}
+
+type SignerRole struct {
+	Name    string     `json:"name"`
+	Signers SignerList `json:"signers"`
+	// minimum number of distinct valid signers of this role (default 1)
+	Threshold int `json:"threshold,omitempty"`
+}
+
+// check if the valid signers satisfy the policy, and return the reason if not
+func (p *SignerPolicy) Match(signerNames []string) (bool, string) {
+	if p == nil {
+		return true, ""
+	}
+	reasons := []string{}
+	if count := countMatchedSigners(p.Signers, signerNames); count < p.Threshold {
+		reasons = append(reasons, fmt.Sprintf("%v signers are required, but %v found", p.Threshold, count))
+	}
+	for _, r := range p.Roles {
+		threshold := r.Threshold
+		if threshold <= 0 {
+			threshold = 1
+		}
+		if count := countMatchedSigners(r.Signers, signerNames); count < threshold {
+			reasons = append(reasons, fmt.Sprintf("%v signers of role `%s` are required, but %v found", threshold, r.Name, count))
+		}
+	}
+	if len(reasons) > 0 {
+		return false, strings.Join(reasons, "; ")
+	}
+	return true, ""
+}
+
+// signatures verified with a cosign key pair have no signer identity, so they are never counted
+// for the policy. return an error if the policy can never be satisfied with the backend
+func (p *SignerPolicy) checkBackend(bo *BackendOption) error {
+	if p == nil || (p.Threshold <= 0 && len(p.Roles) == 0) {
+		return nil
+	}
+	if bo.backendType() == BackendCosign && bo.KeyPath != "" {
+		return errors.New("signerPolicy requires signer identities, but signatures verified with a cosign key have none; use keyless or x509 signing")
+	}
+	return nil
+}
+
+func countMatchedSigners(l SignerList, signerNames []string) int {
+	count := 0
+	for _, s := range signerNames {
+		// an empty name is a signature verified with a key, which is not counted as an identity
+		if s == "" {
+			continue
+		}
+		if l.Match(s) {
+			count++
+		}
+	}
+	return count
+}
+
+// check if the valid signers are accepted by the signer configurations
+func (vo *VerifyOption) acceptSigners(signerNames []string) bool {
+	if vo == nil {
+		return true
+	}
+	if vo.SignerPolicy != nil {
+		ok, reason := vo.SignerPolicy.Match(signerNames)
+		if !ok {
+			log.Debug("signer policy is not satisfied; ", reason)
+			return false
+		}
+	}
+	return vo.Signers.MatchAny(signerNames)
+}
+
+func firstSigner(signerNames []string) string {
+	if len(signerNames) == 0 {
+		return ""
+	}
+	return signerNames[0]
+}
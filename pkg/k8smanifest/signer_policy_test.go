@@ -0,0 +1,59 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import "testing"
+
+func TestSignerPolicyMatch(t *testing.T) {
+	policy := &SignerPolicy{
+		Threshold: 2,
+		Roles: []SignerRole{
+			{Name: "dev", Signers: SignerList{"alice@example.com", "carol@example.com"}},
+			{Name: "security", Signers: SignerList{"bob@example.com"}},
+		},
+	}
+	cases := []struct {
+		name     string
+		signers  []string
+		expected bool
+	}{
+		{name: "dev and security", signers: []string{"alice@example.com", "bob@example.com"}, expected: true},
+		{name: "two devs", signers: []string{"alice@example.com", "carol@example.com"}, expected: false},
+		{name: "single signer", signers: []string{"bob@example.com"}, expected: false},
+		{name: "key signature is not an identity", signers: []string{"", "alice@example.com"}, expected: false},
+	}
+	for _, c := range cases {
+		actual, reason := policy.Match(c.signers)
+		if actual != c.expected {
+			t.Errorf("%s: expected %v, but got %v (%s)", c.name, c.expected, actual, reason)
+		}
+	}
+}
+
+func TestSignerPolicyCheckBackend(t *testing.T) {
+	policy := &SignerPolicy{Threshold: 2}
+	if err := policy.checkBackend((&BackendOption{}).withKeyPath("cosign.pub")); err == nil {
+		t.Error("expected an error for a threshold policy with a cosign key")
+	}
+	if err := policy.checkBackend(nil); err != nil {
+		t.Errorf("unexpected error for keyless verification; %s", err.Error())
+	}
+	vo := &VerifyOption{SignerPolicy: policy, Backend: &BackendOption{KeyPath: "cosign.pub"}}
+	if err := vo.Validate(); err == nil {
+		t.Error("expected a validation error for a threshold policy with a cosign key")
+	}
+}
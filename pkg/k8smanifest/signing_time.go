@@ -0,0 +1,205 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+	log "github.com/sirupsen/logrus"
+)
+
+// signing time and expiry which are embedded in a signed payload as optional annotations
+const (
+	SignedAtAnnotationKey  = "k8s-manifest-sigstore/signed-at"
+	ExpiresAtAnnotationKey = "k8s-manifest-sigstore/expires-at"
+)
+
+// SigningTimePolicy rejects signatures by their signing time.
+// The signing time is the integrated time of Rekor if available, otherwise the signed-at annotation in the payload.
+// The integrated time is used only when the SET in the bundle is verified with the Rekor public key,
+// because the bundle is not signed by the signer and anyone who can push to the registry can rewrite it.
+// An expiry in the payload is always checked regardless of this policy.
+type SigningTimePolicy struct {
+	// reject signatures older than this duration, e.g. `90d`, `720h`
+	MaxAge string `json:"maxAge,omitempty"`
+	// reject signatures made before this time (e.g. a key rotation date) in RFC3339
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	// reject signatures without any signing time
+	RequireSigningTime bool `json:"requireSigningTime,omitempty"`
+	// reject signatures without an integrated time of Rekor
+	RequireIntegratedTime bool `json:"requireIntegratedTime,omitempty"`
+}
+
+// return annotations of signing time (and expiry if not 0) for a payload
+func signingTimeAnnotations(now time.Time, expiry time.Duration) map[string]interface{} {
+	annotations := map[string]interface{}{
+		SignedAtAnnotationKey: now.UTC().Format(time.RFC3339),
+	}
+	if expiry > 0 {
+		annotations[ExpiresAtAnnotationKey] = now.Add(expiry).UTC().Format(time.RFC3339)
+	}
+	return annotations
+}
+
+// check a verified signature; returns an error with a reason if it is rejected
+func (p *SigningTimePolicy) check(sp cosign.SignedPayload, now time.Time) error {
+	integratedTime, signedAt, expiresAt, err := signingTimesOf(sp)
+	if err != nil {
+		return err
+	}
+	return p.checkTimes(integratedTime, signedAt, expiresAt, now)
+}
+
+// return the integrated time of Rekor, and the signing time and expiry in the payload if available
+func signingTimesOf(sp cosign.SignedPayload) (*time.Time, *time.Time, *time.Time, error) {
+	ss := payload.SimpleContainerImage{}
+	if err := json.Unmarshal(sp.Payload, &ss); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to unmarshal payload")
+	}
+	integratedTime := verifiedIntegratedTime(sp)
+	signedAt, err := getTimeInAnnotations(ss.Optional, SignedAtAnnotationKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	expiresAt, err := getTimeInAnnotations(ss.Optional, ExpiresAtAnnotationKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return integratedTime, signedAt, expiresAt, nil
+}
+
+// return the integrated time in the bundle only if its SET is verified and the log entry is of this signature
+func verifiedIntegratedTime(sp cosign.SignedPayload) *time.Time {
+	if sp.Bundle == nil || sp.Bundle.IntegratedTime <= 0 {
+		return nil
+	}
+	if ok, err := sp.VerifyBundle(); !ok || err != nil {
+		log.Debugf("ignore the integrated time in the bundle; failed to verify SET: %v", err)
+		return nil
+	}
+	if !bundleEntryMatches(sp) {
+		log.Debug("ignore the integrated time in the bundle; the log entry is not of this signature")
+		return nil
+	}
+	t := time.Unix(sp.Bundle.IntegratedTime, 0)
+	return &t
+}
+
+// rekord entry in a bundle body; only the fields to identify a signature
+type rekordEntry struct {
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content string `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// check if the log entry in the bundle has the same signature and payload digest,
+// so that a valid SET of another signature cannot be reused
+func bundleEntryMatches(sp cosign.SignedPayload) bool {
+	body, ok := sp.Bundle.Body.(string)
+	if !ok {
+		return false
+	}
+	entryBytes, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return false
+	}
+	var entry rekordEntry
+	if err := json.Unmarshal(entryBytes, &entry); err != nil {
+		return false
+	}
+	digest := sha256.Sum256(sp.Payload)
+	return entry.Spec.Signature.Content == sp.Base64Signature &&
+		entry.Spec.Data.Hash.Algorithm == "sha256" &&
+		entry.Spec.Data.Hash.Value == hex.EncodeToString(digest[:])
+}
+
+func (p *SigningTimePolicy) checkTimes(integratedTime, signedAt, expiresAt *time.Time, now time.Time) error {
+	if expiresAt != nil && now.After(*expiresAt) {
+		return errors.New(fmt.Sprintf("signature expired at %s", expiresAt.Format(time.RFC3339)))
+	}
+	if p == nil {
+		return nil
+	}
+	if p.RequireIntegratedTime && integratedTime == nil {
+		return errors.New("signature has no integrated time of transparency log")
+	}
+	signingTime := integratedTime
+	if signingTime == nil {
+		signingTime = signedAt
+	}
+	if signingTime == nil {
+		if p.RequireSigningTime || p.MaxAge != "" || p.NotBefore != nil {
+			return errors.New("signature has no signing time")
+		}
+		return nil
+	}
+	if p.NotBefore != nil && signingTime.Before(*p.NotBefore) {
+		return errors.New(fmt.Sprintf("signature was made at %s, before %s", signingTime.Format(time.RFC3339), p.NotBefore.Format(time.RFC3339)))
+	}
+	if p.MaxAge != "" {
+		maxAge, err := parseDuration(p.MaxAge)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse maxAge")
+		}
+		if now.Sub(*signingTime) > maxAge {
+			return errors.New(fmt.Sprintf("signature was made at %s, older than %s", signingTime.Format(time.RFC3339), p.MaxAge))
+		}
+	}
+	return nil
+}
+
+func getTimeInAnnotations(annotations map[string]interface{}, key string) (*time.Time, error) {
+	val, ok := annotations[key].(string)
+	if !ok || val == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse `%s` in payload", key))
+	}
+	return &t, nil
+}
+
+// same as time.ParseDuration, but `d` (days) is also accepted, e.g. `90d`
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
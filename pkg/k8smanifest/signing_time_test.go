@@ -0,0 +1,100 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sigstore/cosign/pkg/cosign"
+	cremote "github.com/sigstore/cosign/pkg/cosign/remote"
+)
+
+func TestSigningTimePolicy(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(d int) *time.Time {
+		t := now.Add(-time.Duration(d) * 24 * time.Hour)
+		return &t
+	}
+	rotation := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		name           string
+		policy         *SigningTimePolicy
+		integratedTime *time.Time
+		signedAt       *time.Time
+		expiresAt      *time.Time
+		ok             bool
+	}{
+		{name: "no policy", policy: nil, ok: true},
+		{name: "expired without policy", policy: nil, signedAt: daysAgo(10), expiresAt: daysAgo(1), ok: false},
+		{name: "not expired", policy: nil, signedAt: daysAgo(10), expiresAt: daysAgo(-1), ok: true},
+		{name: "within max age", policy: &SigningTimePolicy{MaxAge: "90d"}, integratedTime: daysAgo(30), ok: true},
+		{name: "older than max age", policy: &SigningTimePolicy{MaxAge: "90d"}, integratedTime: daysAgo(100), ok: false},
+		{name: "integrated time is preferred", policy: &SigningTimePolicy{MaxAge: "90d"}, integratedTime: daysAgo(100), signedAt: daysAgo(1), ok: false},
+		{name: "signed-at is used without tlog", policy: &SigningTimePolicy{MaxAge: "720h"}, signedAt: daysAgo(1), ok: true},
+		{name: "no signing time", policy: &SigningTimePolicy{MaxAge: "90d"}, ok: false},
+		{name: "before rotation", policy: &SigningTimePolicy{NotBefore: &rotation}, integratedTime: daysAgo(60), ok: false},
+		{name: "after rotation", policy: &SigningTimePolicy{NotBefore: &rotation}, integratedTime: daysAgo(10), ok: true},
+		{name: "integrated time required", policy: &SigningTimePolicy{RequireIntegratedTime: true}, signedAt: daysAgo(1), ok: false},
+	}
+	for _, tc := range testcases {
+		err := tc.policy.checkTimes(tc.integratedTime, tc.signedAt, tc.expiresAt, now)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok=%v, got error %v", tc.name, tc.ok, err)
+		}
+	}
+}
+
+func TestSigningTimePolicyWithForgedBundle(t *testing.T) {
+	now := time.Now()
+	signedAt := now.Add(-200 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"sample"},"image":{"docker-manifest-digest":"sha256:0"},"type":"cosign container image signature"},"optional":{"%s":"%s"}}`, SignedAtAnnotationKey, signedAt))
+	sig := base64.StdEncoding.EncodeToString([]byte("signature"))
+	digest := sha256.Sum256(payload)
+	entry, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "rekord",
+		"spec": map[string]interface{}{
+			"data":      map[string]interface{}{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(digest[:])}},
+			"signature": map[string]interface{}{"content": sig},
+		},
+	})
+	// a recent integrated time with a bogus SET, pushed by someone without the Rekor key
+	sp := cosign.SignedPayload{
+		Payload:         payload,
+		Base64Signature: sig,
+		Bundle: &cremote.Bundle{
+			SignedEntryTimestamp: []byte("forged"),
+			Body:                 base64.StdEncoding.EncodeToString(entry),
+			IntegratedTime:       now.Unix(),
+			LogID:                "forged",
+		},
+	}
+
+	if err := (&SigningTimePolicy{MaxAge: "90d"}).check(sp, now); err == nil {
+		t.Errorf("expected an old signature with a forged bundle to be rejected by maxAge")
+	}
+	if err := (&SigningTimePolicy{RequireIntegratedTime: true}).check(sp, now); err == nil {
+		t.Errorf("expected a forged bundle to be rejected by requireIntegratedTime")
+	}
+}
@@ -0,0 +1,205 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// keys which can be written in a path without quotes
+var plainPathKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_\-/]+$`)
+
+// FieldSuggestion is a suggested ignore field with the number of diffs and resources found in
+type FieldSuggestion struct {
+	Field     string `json:"field"`
+	Count     int    `json:"count"`
+	Resources int    `json:"resources"`
+}
+
+// ConfigSuggestion is a set of ignore fields for each kind, suggested from diffs in verification results
+type ConfigSuggestion struct {
+	// number of the verified resources and the ones with diffs
+	Resources         int                                    `json:"resources"`
+	ResourcesWithDiff int                                    `json:"resourcesWithDiff"`
+	Fields            map[schema.GroupKind][]FieldSuggestion `json:"-"`
+}
+
+// SuggestConfig aggregates diff keys in the results by kind, and generalizes them into paths of ignore fields
+// (e.g. `spec.template.spec.containers.0.image` -> `spec.template.spec.containers[*].image`)
+func SuggestConfig(results []*VerifyResourceResult) *ConfigSuggestion {
+	s := &ConfigSuggestion{Fields: map[schema.GroupKind][]FieldSuggestion{}}
+	counts := map[schema.GroupKind]map[string]*FieldSuggestion{}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		s.Resources++
+		if r.Diff == nil || r.Diff.Size() == 0 {
+			continue
+		}
+		s.ResourcesWithDiff++
+		gk := r.Object.GroupVersionKind().GroupKind()
+		if counts[gk] == nil {
+			counts[gk] = map[string]*FieldSuggestion{}
+		}
+		foundInResource := map[string]bool{}
+		for _, d := range r.Diff.Items {
+			field := generalizeDiffKey(r.Object.Object, d.Key)
+			fs, ok := counts[gk][field]
+			if !ok {
+				fs = &FieldSuggestion{Field: field}
+				counts[gk][field] = fs
+			}
+			fs.Count++
+			if !foundInResource[field] {
+				foundInResource[field] = true
+				fs.Resources++
+			}
+		}
+	}
+	for gk, fields := range counts {
+		for _, fs := range fields {
+			s.Fields[gk] = append(s.Fields[gk], *fs)
+		}
+		sort.Slice(s.Fields[gk], func(i, j int) bool { return s.Fields[gk][i].Field < s.Fields[gk][j].Field })
+	}
+	return s
+}
+
+// VerifyOption returns a config with the suggested ignore fields
+func (s *ConfigSuggestion) VerifyOption() *VerifyOption {
+	vo := &VerifyOption{}
+	for _, gk := range s.sortedGroupKinds() {
+		fields := []string{}
+		for _, fs := range s.Fields[gk] {
+			fields = append(fields, fs.Field)
+		}
+		vo.IgnoreFields = append(vo.IgnoreFields, ObjectFieldBinding{
+			Objects: ObjectReferenceList{{Group: gk.Group, Kind: gk.Kind}},
+			Fields:  fields,
+		})
+	}
+	return vo
+}
+
+// ToYAML returns a config YAML with the suggested ignore fields, and their counts as comments for review
+func (s *ConfigSuggestion) ToYAML() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# suggested from %d resources (%d with diffs)\n", s.Resources, s.ResourcesWithDiff)
+	b.WriteString("# review each field before use. `<n> diffs in <m> resources` is shown for each field\n")
+	if len(s.Fields) == 0 {
+		b.WriteString("ignoreFields: []\n")
+		return []byte(b.String())
+	}
+	b.WriteString("ignoreFields:\n")
+	for _, gk := range s.sortedGroupKinds() {
+		b.WriteString("- objects:\n")
+		if gk.Group != "" {
+			fmt.Fprintf(&b, "  - group: %s\n    kind: %s\n", gk.Group, gk.Kind)
+		} else {
+			fmt.Fprintf(&b, "  - kind: %s\n", gk.Kind)
+		}
+		b.WriteString("  fields:\n")
+		for _, fs := range s.Fields[gk] {
+			fmt.Fprintf(&b, "  - %s  # %d diffs in %d resources\n", yamlScalar(fs.Field), fs.Count, fs.Resources)
+		}
+	}
+	return []byte(b.String())
+}
+
+func (s *ConfigSuggestion) sortedGroupKinds() []schema.GroupKind {
+	gks := []schema.GroupKind{}
+	for gk := range s.Fields {
+		gks = append(gks, gk)
+	}
+	sort.Slice(gks, func(i, j int) bool {
+		if gks[i].Group != gks[j].Group {
+			return gks[i].Group < gks[j].Group
+		}
+		return gks[i].Kind < gks[j].Kind
+	})
+	return gks
+}
+
+// quote a string for YAML if necessary
+func yamlScalar(s string) string {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return strconv.Quote(s)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// convert a flat diff key to a path by walking the object. indexes of lists are generalized to `[*]`,
+// and keys with dots (e.g. annotations) are quoted
+func generalizeDiffKey(obj map[string]interface{}, key string) string {
+	keys := strings.Split(key, ".")
+	var b strings.Builder
+	var val interface{} = obj
+	for len(keys) > 0 {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			n := 1
+			for i := len(keys); i > 1; i-- {
+				if _, ok := v[strings.Join(keys[:i], ".")]; ok {
+					n = i
+					break
+				}
+			}
+			k := strings.Join(keys[:n], ".")
+			if plainPathKeyPattern.MatchString(k) {
+				if b.Len() > 0 {
+					b.WriteString(".")
+				}
+				b.WriteString(k)
+			} else {
+				fmt.Fprintf(&b, "['%s']", k)
+			}
+			val = v[k]
+			keys = keys[n:]
+		case []interface{}:
+			b.WriteString("[*]")
+			if i, err := strconv.Atoi(keys[0]); err == nil && i >= 0 && i < len(v) {
+				val = v[i]
+			} else {
+				val = nil
+			}
+			keys = keys[1:]
+		default:
+			// a key not in the object (e.g. a removed field)
+			for _, k := range keys {
+				if _, err := strconv.Atoi(k); err == nil {
+					b.WriteString("[*]")
+				} else {
+					if b.Len() > 0 {
+						b.WriteString(".")
+					}
+					b.WriteString(k)
+				}
+			}
+			keys = nil
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"strings"
+	"testing"
+
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+func TestSuggestConfig(t *testing.T) {
+	obj := loadTestObject(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    deployment.kubernetes.io/revision: "3"
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v2
+      - name: proxy
+        image: proxy:v2
+`)
+	results := []*VerifyResourceResult{
+		{Object: obj, Diff: &mapnode.DiffResult{Items: []mapnode.Difference{
+			{Key: "metadata.annotations.deployment.kubernetes.io/revision"},
+			{Key: "spec.template.spec.containers.0.image"},
+			{Key: "spec.template.spec.containers.1.image"},
+		}}},
+		{Object: obj, Diff: &mapnode.DiffResult{Items: []mapnode.Difference{
+			{Key: "spec.template.spec.containers.0.image"},
+			{Key: "spec.paused"},
+		}}},
+		{Object: obj, Verified: true},
+	}
+	s := SuggestConfig(results)
+	if s.Resources != 3 || s.ResourcesWithDiff != 2 {
+		t.Errorf("unexpected number of resources; %d, %d", s.Resources, s.ResourcesWithDiff)
+	}
+	expected := []FieldSuggestion{
+		{Field: "metadata.annotations['deployment.kubernetes.io/revision']", Count: 1, Resources: 1},
+		{Field: "spec.paused", Count: 1, Resources: 1},
+		{Field: "spec.template.spec.containers[*].image", Count: 3, Resources: 2},
+	}
+	fields := s.Fields[obj.GroupVersionKind().GroupKind()]
+	if len(fields) != len(expected) {
+		t.Fatalf("unexpected suggestions; %+v", fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("expected %+v, but got %+v", expected[i], fields[i])
+		}
+	}
+	if err := s.VerifyOption().Validate(); err != nil {
+		t.Errorf("suggested config should be valid; %s", err.Error())
+	}
+	if !strings.Contains(string(s.ToYAML()), "- spec.template.spec.containers[*].image  # 3 diffs in 2 resources") {
+		t.Errorf("unexpected YAML; %s", string(s.ToYAML()))
+	}
+}
@@ -17,25 +17,19 @@
 package k8smanifest
 
 import (
-	"context"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
-	"github.com/google/go-containerregistry/pkg/name"
-	v1 "github.com/google/go-containerregistry/pkg/v1"
 	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
 	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
-
-	"github.com/sigstore/cosign/cmd/cosign/cli"
-	"github.com/sigstore/cosign/pkg/cosign"
-	"github.com/sigstore/cosign/pkg/cosign/fulcio"
-	"github.com/sigstore/sigstore/pkg/signature/payload"
 )
 
 var EmbeddedAnnotationMaskKeys = []string{
@@ -44,12 +38,17 @@ var EmbeddedAnnotationMaskKeys = []string{
 	fmt.Sprintf("metadata.annotations.\"%s\"", CertificateAnnotationKey),
 	fmt.Sprintf("metadata.annotations.\"%s\"", MessageAnnotationKey),
 	fmt.Sprintf("metadata.annotations.\"%s\"", BundleAnnotationKey),
+	fmt.Sprintf("metadata.annotations.\"%s\"", SignedFieldsAnnotationKey),
 }
 
 type VerifyResult struct {
-	Verified bool                `json:"verified"`
-	Signer   string              `json:"signer"`
-	Diff     *mapnode.DiffResult `json:"diff"`
+	Verified bool                         `json:"verified"`
+	Signer   string                       `json:"signer"`
+	Signers  []string                     `json:"signers,omitempty"`
+	Diff     *mapnode.DiffResult          `json:"diff"`
+	Images   []ContainerImageVerifyResult `json:"images,omitempty"`
+	// a reason of revocation if all of the valid signatures are revoked
+	Revoked string `json:"revoked,omitempty"`
 }
 
 func (r *VerifyResult) String() string {
@@ -57,21 +56,31 @@ func (r *VerifyResult) String() string {
 	return string(rB)
 }
 
-func Verify(manifest []byte, imageRef, keyPath string) (*VerifyResult, error) {
+func Verify(manifest []byte, imageRef, keyPath string, vo *VerifyOption) (*VerifyResult, error) {
 	if manifest == nil {
 		return nil, errors.New("input YAML manifest must be non-empty")
 	}
 
 	verified := false
-	signerName := ""
+	signerNames := []string{}
 
 	// TODO: support directly attached annotation sigantures
 	if imageRef != "" {
-		image, err := k8ssigutil.PullImage(imageRef)
+		if vo != nil && vo.RequireBundleDigest {
+			if err := checkPinnedBundleRef(imageRef); err != nil {
+				return nil, err
+			}
+		}
+		if vo != nil {
+			if err := vo.SignerPolicy.checkBackend(vo.Backend.withKeyPath(keyPath)); err != nil {
+				return nil, err
+			}
+		}
+		concatYAMLFromImage, err := getManifestsInImage(imageRef)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to pull image")
 		}
-		ok, tmpDiff, err := matchManifest(manifest, image)
+		ok, tmpDiff, err := matchManifest(manifest, concatYAMLFromImage)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to match manifest")
 		}
@@ -83,70 +92,68 @@ func Verify(manifest []byte, imageRef, keyPath string) (*VerifyResult, error) {
 			}, nil
 		}
 
-		verified, signerName, err = imageVerify(imageRef, &keyPath)
+		bo, err := vo.backendOption()
+		if err == nil {
+			verified, signerNames, err = verifyImageSignatures(imageRef, keyPath, bo)
+		}
+		if revokedErr, ok := err.(*RevokedError); ok {
+			return &VerifyResult{Verified: false, Revoked: revokedErr.Reason}, nil
+		}
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to verify image")
 		}
+		if verified && !vo.acceptSigners(signerNames) {
+			verified = false
+		}
 	}
 
-	return &VerifyResult{
+	result := &VerifyResult{
 		Verified: verified,
-		Signer:   signerName,
-	}, nil
-
-}
-
-func imageVerify(imageRef string, pubkeyPath *string) (bool, string, error) {
-	ref, err := name.ParseReference(imageRef)
-	if err != nil {
-		return false, "", fmt.Errorf("failed to parse image ref `%s`; %s", imageRef, err.Error())
+		Signer:   firstSigner(signerNames),
+		Signers:  signerNames,
 	}
 
-	co := &cosign.CheckOpts{
-		Claims: true,
-		Tlog:   true,
-		Roots:  fulcio.Roots,
-	}
-
-	if pubkeyPath != nil && *pubkeyPath != "" {
-		tmpPubkey, err := cosign.LoadPublicKey(context.Background(), *pubkeyPath)
+	// second stage: verify container images referenced by the verified manifest
+	if verified && vo != nil && vo.ContainerImages.enabled() {
+		var obj unstructured.Unstructured
+		err := yaml.Unmarshal(manifest, &obj)
 		if err != nil {
-			return false, "", fmt.Errorf("error loading public key; %s", err.Error())
+			return nil, errors.Wrap(err, "failed to unmarshal manifest")
 		}
-		co.PubKey = tmpPubkey
+		result.Verified, result.Images = verifyContainerImages(obj, vo)
 	}
+	return result, nil
 
-	rekorSever := cli.TlogServer()
-	verified, err := cosign.Verify(context.Background(), ref, co, rekorSever)
-	if err != nil {
-		return false, "", fmt.Errorf("error occured while verifying image `%s`; %s", imageRef, err.Error())
-	}
-	if len(verified) == 0 {
-		return false, "", fmt.Errorf("no verified signatures in the image `%s`; %s", imageRef, err.Error())
-	}
-	var cert *x509.Certificate
-	for _, vp := range verified {
-		ss := payload.SimpleContainerImage{}
-		err := json.Unmarshal(vp.Payload, &ss)
-		if err != nil {
-			continue
-		}
-		cert = vp.Cert
-		break
-	}
+}
 
-	signerName := "" // singerName could be empty in case of key-used verification
-	if cert != nil {
-		signerName = k8ssigutil.GetNameInfoFromCert(cert)
+// check that a bundle image ref is pinned by digest. the image is pulled by the digest, so its content
+// is verified by the registry client and no registry access is needed here.
+func checkPinnedBundleRef(imageRef string) error {
+	ref, err := name.NewDigest(imageRef)
+	if err != nil || !strings.HasPrefix(ref.DigestStr(), "sha256:") {
+		return errors.New(fmt.Sprintf("bundle image ref `%s` is not pinned by digest", imageRef))
 	}
-	return true, signerName, nil
+	return nil
 }
 
-func matchManifest(manifest []byte, image v1.Image) (bool, *mapnode.DiffResult, error) {
-	concatYAMLFromImage, err := k8ssigutil.GenerateConcatYAMLsFromImage(image)
+// verify signatures of a bundle image with the configured backend (cosign by default)
+func verifyImageSignatures(imageRef, keyPath string, bo *BackendOption) (bool, []string, error) {
+	opt, cleanup, err := bo.withKeyPath(keyPath).resolveKey(publicKeySecretDataKey)
+	defer cleanup()
+	if err != nil {
+		return false, nil, err
+	}
+	verifier, err := NewVerifierBackend(opt)
 	if err != nil {
 		return false, nil, err
 	}
+	start := time.Now()
+	defer observeStageDuration(StageSignatureCheck, start)
+	// signatures are pulled from a mirror if configured
+	return verifier.Verify(k8ssigutil.MirrorImageRef(imageRef))
+}
+
+func matchManifest(manifest, concatYAMLFromImage []byte) (bool, *mapnode.DiffResult, error) {
 	log.Debug("manifest:", string(manifest))
 	log.Debug("manifest in image:", string(concatYAMLFromImage))
 	inputFileNode, err := mapnode.NewFromYamlBytes(manifest)
@@ -168,12 +175,25 @@ func matchManifest(manifest []byte, image v1.Image) (bool, *mapnode.DiffResult,
 	if !found {
 		return false, nil, errors.New("failed to find the input file in image")
 	}
+	// for a partial manifest, only the signed subtrees of the input are compared
+	if signedFields := getSignedFields(foundBytes); len(signedFields) > 0 {
+		partialBytes, err := extractFields(manifest, signedFields)
+		if err != nil {
+			return false, nil, err
+		}
+		inputFileNode, err = mapnode.NewFromYamlBytes(partialBytes)
+		if err != nil {
+			return false, nil, err
+		}
+		maskedInputNode = inputFileNode.Mask(EmbeddedAnnotationMaskKeys)
+	}
 	manifestNode, err := mapnode.NewFromYamlBytes(foundBytes)
 	if err != nil {
 		return false, nil, err
 	}
 	maskedManifestNode := manifestNode.Mask(EmbeddedAnnotationMaskKeys)
 	diff := maskedInputNode.Diff(maskedManifestNode)
+	diff = findParametersInBundle(concatYAMLFromImage).FilterDiff(obj, diff)
 	if diff == nil || diff.Size() == 0 {
 		return true, nil, nil
 	}
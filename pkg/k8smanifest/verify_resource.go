@@ -19,12 +19,13 @@ package k8smanifest
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
-	v1 "github.com/google/go-containerregistry/pkg/v1"
 	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
 	kubeutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/kubeutil"
 	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
@@ -38,6 +39,7 @@ var CommonResourceMaskKeys = []string{
 	fmt.Sprintf("metadata.annotations.\"%s\"", CertificateAnnotationKey),
 	fmt.Sprintf("metadata.annotations.\"%s\"", MessageAnnotationKey),
 	fmt.Sprintf("metadata.annotations.\"%s\"", BundleAnnotationKey),
+	fmt.Sprintf("metadata.annotations.\"%s\"", SignedFieldsAnnotationKey),
 	"metadata.annotations.namespace",
 	"metadata.annotations.kubectl.\"kubernetes.io/last-applied-configuration\"",
 	"metadata.managedFields",
@@ -52,11 +54,18 @@ var CommonResourceMaskKeys = []string{
 }
 
 type VerifyResourceResult struct {
-	Object   unstructured.Unstructured `json:"-"`
-	Verified bool                      `json:"verified"`
-	InScope  bool                      `json:"inScope"`
-	Signer   string                    `json:"signer"`
-	Diff     *mapnode.DiffResult       `json:"diff"`
+	Object   unstructured.Unstructured    `json:"-"`
+	Verified bool                         `json:"verified"`
+	InScope  bool                         `json:"inScope"`
+	Signer   string                       `json:"signer"`
+	Signers  []string                     `json:"signers,omitempty"`
+	Diff     *mapnode.DiffResult          `json:"diff"`
+	Ancestor *ObjectReference             `json:"ancestor,omitempty"`
+	Images   []ContainerImageVerifyResult `json:"images,omitempty"`
+	// a reason of revocation if all of the valid signatures are revoked
+	Revoked string `json:"revoked,omitempty"`
+	// fields added by known mutators, which are tolerated
+	Mutations []MutationResult `json:"mutations,omitempty"`
 }
 
 func (r *VerifyResourceResult) String() string {
@@ -65,10 +74,20 @@ func (r *VerifyResourceResult) String() string {
 }
 
 func VerifyResource(obj unstructured.Unstructured, imageRef, keyPath string, vo *VerifyOption) (*VerifyResourceResult, error) {
+	result, err := verifyResource(obj, imageRef, keyPath, vo)
+	// second stage: verify container images referenced by the verified resource
+	if err == nil && result.Verified && vo != nil && vo.ContainerImages.enabled() {
+		result.Verified, result.Images = verifyContainerImages(obj, vo)
+	}
+	recordVerification(obj, result, err)
+	return result, err
+}
+
+func verifyResource(obj unstructured.Unstructured, imageRef, keyPath string, vo *VerifyOption) (*VerifyResourceResult, error) {
 
 	verified := false
 	inScope := true // assume that input resource is in scope in verify-resource
-	signerName := ""
+	signerNames := []string{}
 
 	// if imageRef is not specified in args and it is found in object annotations, use the found image ref
 	if imageRef == "" {
@@ -87,105 +106,206 @@ func VerifyResource(obj unstructured.Unstructured, imageRef, keyPath string, vo
 	}
 
 	// get ignore fields configuration for this resource if found
-	ignoreFields := []string{}
+	mo := &resourceMatchOption{}
 	if vo != nil {
-		if ok, fields := vo.IgnoreFields.Match(obj); ok {
-			ignoreFields = fields
+		mo.ignoreFields = append(mo.ignoreFields, vo.IgnoreFields...)
+		mo.nameTransforms = vo.NameTransforms
+		mo.normalizers = vo.Normalizers
+		mo.knownMutators = vo.KnownMutators
+		mo.fieldManagers = vo.AllowedFieldManagers
+	}
+	// fields added by helm on install are not in the signed render
+	if isHelmManaged(obj) {
+		mo.ignoreFields = append(mo.ignoreFields, ObjectFieldBinding{Fields: HelmReleaseMaskKeys})
+	}
+
+	// a resource generated by a controller (e.g. Pod of Deployment) is verified with the signed template of its ancestor
+	if vo != nil && vo.FollowOwnerReferences && hasControllerOwner(obj) {
+		manifestFound := false
+		if imageRef != "" {
+			concatYAMLFromImage, err := getManifestsInImage(imageRef)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to pull image")
+			}
+			manifestFound = manifestFoundInBundle(obj, concatYAMLFromImage)
+		}
+		if !manifestFound {
+			result, ancestorFound, err := verifyGeneratedResource(obj, imageRef, keyPath, vo, mo)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to verify a resource with its ancestor")
+			}
+			if ancestorFound {
+				result.InScope = inScope
+				return result, nil
+			}
 		}
 	}
 
 	// do manifest matching and signature verification
 	// TODO: support directly attached annotation sigantures
 	if imageRef != "" {
-		image, err := k8ssigutil.PullImage(imageRef)
+		if vo != nil && vo.RequireBundleDigest {
+			if err := checkPinnedBundleRef(imageRef); err != nil {
+				return nil, err
+			}
+		}
+		if vo != nil {
+			if err := vo.SignerPolicy.checkBackend(vo.Backend.withKeyPath(keyPath)); err != nil {
+				return nil, err
+			}
+		}
+		concatYAMLFromImage, err := getManifestsInImage(imageRef)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to pull image")
 		}
-		ok, tmpDiff, err := matchResourceWithManifest(obj, image, ignoreFields)
+		ok, tmpDiff, err := matchResourceWithManifest(obj, concatYAMLFromImage, mo)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to match resource with manifest")
 		}
 		if !ok {
 			return &VerifyResourceResult{
-				Object:   obj,
-				Verified: false,
-				InScope:  inScope,
-				Signer:   "",
-				Diff:     tmpDiff,
+				Object:    obj,
+				Verified:  false,
+				InScope:   inScope,
+				Signer:    "",
+				Diff:      tmpDiff,
+				Mutations: mo.mutations,
 			}, nil
 		}
-		verified, signerName, err = imageVerify(imageRef, &keyPath)
+		bo, err := vo.backendOption()
+		if err == nil {
+			verified, signerNames, err = verifyImageSignatures(imageRef, keyPath, bo)
+		}
+		if revokedErr, ok := err.(*RevokedError); ok {
+			return &VerifyResourceResult{Object: obj, Verified: false, InScope: inScope, Revoked: revokedErr.Reason}, nil
+		}
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to verify image")
 		}
-		if verified {
-			if !vo.Signers.Match(signerName) {
-				verified = false
-			}
+		if verified && !vo.acceptSigners(signerNames) {
+			verified = false
 		}
 	}
 
 	return &VerifyResourceResult{
-		Object:   obj,
-		Verified: verified,
-		InScope:  inScope,
-		Signer:   signerName,
+		Object:    obj,
+		Verified:  verified,
+		InScope:   inScope,
+		Signer:    firstSigner(signerNames),
+		Signers:   signerNames,
+		Mutations: mo.mutations,
 	}, nil
 
 }
 
-func matchResourceWithManifest(obj unstructured.Unstructured, image v1.Image, ignoreFields []string) (bool, *mapnode.DiffResult, error) {
+// resourceMatchOption is a set of rules in VerifyOption for matching a resource with its signed manifest
+type resourceMatchOption struct {
+	ignoreFields   ObjectFieldBindingList
+	nameTransforms NameTransformList
+	normalizers    FieldNormalizerList
+	knownMutators  KnownMutatorList
+	fieldManagers  FieldManagerList
+
+	// set by matchResourceWithManifest()
+	mutations []MutationResult
+}
+
+func matchResourceWithManifest(obj unstructured.Unstructured, concatYAMLFromImage []byte, mo *resourceMatchOption) (bool, *mapnode.DiffResult, error) {
+	if mo == nil {
+		mo = &resourceMatchOption{}
+	}
 
 	apiVersion := obj.GetAPIVersion()
 	kind := obj.GetKind()
 	name := obj.GetName()
 	namespace := obj.GetNamespace()
 
-	concatYAMLFromImage, err := k8ssigutil.GenerateConcatYAMLsFromImage(image)
-	if err != nil {
-		return false, nil, err
-	}
 	log.Debug("obj: apiVersion", apiVersion, "kind", kind, "name", name)
 	log.Debug("manifest in image:", string(concatYAMLFromImage))
 
 	found, foundBytes := k8ssigutil.FindSingleYaml(concatYAMLFromImage, apiVersion, kind, name, namespace)
+	if !found {
+		// the resource may be deployed with a transformed name (e.g. `namePrefix` of kustomize)
+		for _, origName := range mo.nameTransforms.OriginalNames(obj) {
+			found, foundBytes = k8ssigutil.FindSingleYaml(concatYAMLFromImage, apiVersion, kind, origName, namespace)
+			if found {
+				log.Debug("manifest is found with the original name: ", origName)
+				var err error
+				foundBytes, err = renameManifest(foundBytes, name)
+				if err != nil {
+					return false, nil, errors.Wrap(err, "failed to rename manifest")
+				}
+				break
+			}
+		}
+	}
 	if !found {
 		return false, nil, errors.New("failed to find the corresponding manifest YAML file in image")
 	}
 
+	var err error
 	var matched bool
 	var diff *mapnode.DiffResult
+	// additions of known mutators (e.g. an injected sidecar) are removed from the object before matching
+	obj, mo.mutations = mo.knownMutators.removeAdditions(obj, foundBytes)
 	objBytes, _ := json.Marshal(obj.Object)
+	// fields populated by a server or a controller are masked in addition to the common ones
+	maskKeys := mo.normalizers.maskKeys(obj, foundBytes)
+
+	// parameter fields can have any values which satisfy the signed constraints
+	params := findParametersInBundle(concatYAMLFromImage)
+
+	// a partial manifest is compared only with the signed subtrees, without dryrun
+	if signedFields := getSignedFields(foundBytes); len(signedFields) > 0 {
+		matched, diff, err = partialMatch(obj, foundBytes, signedFields, maskKeys)
+		if err != nil {
+			return false, nil, errors.Wrap(err, "error occured during partial match")
+		}
+		diff = mo.nameTransforms.FilterDiff(diff)
+		diff = params.FilterDiff(obj, diff)
+		diff = mo.ignoreFields.FilterDiff(obj, diff)
+		diff = mo.fieldManagers.FilterDiff(obj, diff)
+		if matched || diff == nil || diff.Size() == 0 {
+			return true, nil, nil
+		}
+		return false, diff, nil
+	}
 
 	// CASE1: direct match
-	matched, diff, err = directMatch(objBytes, foundBytes)
+	matched, diff, err = directMatch(objBytes, foundBytes, maskKeys)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "error occured during diract match")
 	}
-	if matched {
+	diff = mo.nameTransforms.FilterDiff(diff)
+	diff = params.FilterDiff(obj, diff)
+	if matched || diff == nil || diff.Size() == 0 {
 		return true, nil, nil
 	}
 
 	// CASE2: dryrun create match
-	matched, diff, err = dryrunCreateMatch(objBytes, foundBytes)
+	matched, diff, err = dryrunCreateMatch(objBytes, foundBytes, maskKeys)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "error occured during dryrun create match")
 	}
-	if matched {
+	diff = mo.nameTransforms.FilterDiff(diff)
+	diff = params.FilterDiff(obj, diff)
+	if matched || diff == nil || diff.Size() == 0 {
 		return true, nil, nil
 	}
 
 	// CASE3: dryrun apply match
-	matched, diff, err = dryrunApplyMatch(objBytes, foundBytes)
+	matched, diff, err = dryrunApplyMatch(objBytes, foundBytes, maskKeys)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "error occured during dryrun apply match")
 	}
-	if matched {
+	diff = mo.nameTransforms.FilterDiff(diff)
+	diff = params.FilterDiff(obj, diff)
+	if matched || diff == nil || diff.Size() == 0 {
 		return true, nil, nil
 	}
 	// TODO: handle patch case
 	// // CASE4: dryrun patch match
-	// matched, diff, err = dryrunPatchMatch(objBytes, foundBytes)
+	// matched, diff, err = dryrunPatchMatch(objBytes, foundBytes, maskKeys)
 	// if err != nil {
 	// 	return false, errors.Wrap(err, "error occured during dryrun patch match")
 	// }
@@ -193,10 +313,9 @@ func matchResourceWithManifest(obj unstructured.Unstructured, image v1.Image, ig
 	// 	return true, nil
 	// }
 
-	// filter out ignoreFields
-	if diff != nil && len(ignoreFields) > 0 {
-		_, diff, _ = diff.Filter(ignoreFields)
-	}
+	// filter out ignoreFields and fields owned by the allowed field managers
+	diff = mo.ignoreFields.FilterDiff(obj, diff)
+	diff = mo.fieldManagers.FilterDiff(obj, diff)
 	if diff == nil || diff.Size() == 0 {
 		matched = true
 		diff = nil
@@ -205,7 +324,7 @@ func matchResourceWithManifest(obj unstructured.Unstructured, image v1.Image, ig
 	return matched, diff, nil
 }
 
-func directMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, error) {
+func directMatch(objBytes, manifestBytes []byte, maskKeys []string) (bool, *mapnode.DiffResult, error) {
 	objNode, err := mapnode.NewFromBytes(objBytes)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize object node")
@@ -214,8 +333,8 @@ func directMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, err
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize manifest node")
 	}
-	maskedObjNode := objNode.Mask(CommonResourceMaskKeys)
-	maskedMnfNode := mnfNode.Mask(CommonResourceMaskKeys)
+	maskedObjNode := objNode.Mask(maskKeys)
+	maskedMnfNode := mnfNode.Mask(maskKeys)
 	diff := maskedObjNode.Diff(maskedMnfNode)
 	if diff == nil || diff.Size() == 0 {
 		return true, nil, nil
@@ -223,7 +342,7 @@ func directMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, err
 	return false, diff, nil
 }
 
-func dryrunCreateMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, error) {
+func dryrunCreateMatch(objBytes, manifestBytes []byte, maskKeys []string) (bool, *mapnode.DiffResult, error) {
 	objNode, err := mapnode.NewFromBytes(objBytes)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize object node")
@@ -233,7 +352,9 @@ func dryrunCreateMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResul
 		return false, nil, errors.Wrap(err, "failed to initialize manifest node")
 	}
 	nsMaskedManifestBytes := mnfNode.Mask([]string{"metadata.namespace"}).ToYaml()
+	start := time.Now()
 	simBytes, err := kubeutil.DryRunCreate([]byte(nsMaskedManifestBytes), defaultDryRunNamespace)
+	observeStageDuration(StageDryRun, start)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to dryrun with the found YAML in image")
 	}
@@ -241,7 +362,7 @@ func dryrunCreateMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResul
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize dry-run-generated object node")
 	}
-	mask := CommonResourceMaskKeys
+	mask := append([]string{}, maskKeys...)
 	mask = append(mask, "metadata.name") // name is overwritten for dryrun like `sample-configmap-dryrun`
 	maskedObjNode := objNode.Mask(mask)
 	maskedSimNode := simNode.Mask(mask)
@@ -252,7 +373,7 @@ func dryrunCreateMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResul
 	return false, diff, nil
 }
 
-func dryrunApplyMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, error) {
+func dryrunApplyMatch(objBytes, manifestBytes []byte, maskKeys []string) (bool, *mapnode.DiffResult, error) {
 	objNode, err := mapnode.NewFromBytes(objBytes)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize object node")
@@ -264,12 +385,14 @@ func dryrunApplyMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult
 	}
 	patchedNode, _ := mapnode.NewFromBytes(patchedBytes)
 	nsMaskedPatchedNode := patchedNode.Mask([]string{"metadata.namespace"})
+	start := time.Now()
 	simPatchedObj, err := kubeutil.DryRunCreate([]byte(nsMaskedPatchedNode.ToYaml()), defaultDryRunNamespace)
+	observeStageDuration(StageDryRun, start)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "error during DryRunCreate for Patch")
 	}
 	simNode, _ := mapnode.NewFromYamlBytes(simPatchedObj)
-	mask := CommonResourceMaskKeys
+	mask := append([]string{}, maskKeys...)
 	mask = append(mask, "metadata.name") // name is overwritten for dryrun like `sample-configmap-dryrun`
 	maskedObjNode := objNode.Mask(mask)
 	maskedSimNode := simNode.Mask(mask)
@@ -281,7 +404,7 @@ func dryrunApplyMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult
 
 }
 
-func dryrunPatchMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, error) {
+func dryrunPatchMatch(objBytes, manifestBytes []byte, maskKeys []string) (bool, *mapnode.DiffResult, error) {
 	objNode, err := mapnode.NewFromBytes(objBytes)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize object node")
@@ -297,7 +420,7 @@ func dryrunPatchMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult
 		return false, nil, errors.Wrap(err, "error during DryRunCreate for Patch:")
 	}
 	simNode, _ := mapnode.NewFromYamlBytes(simPatchedObj)
-	mask := CommonResourceMaskKeys
+	mask := append([]string{}, maskKeys...)
 	mask = append(mask, "metadata.name") // name is overwritten for dryrun like `sample-configmap-dryrun`
 	maskedObjNode := objNode.Mask(mask)
 	maskedSimNode := simNode.Mask(mask)
@@ -307,3 +430,14 @@ func dryrunPatchMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult
 	}
 	return false, diff, nil
 }
+
+// set the deployed name to a manifest found with its original name so that it can be compared with the resource
+func renameManifest(manifestBytes []byte, name string) ([]byte, error) {
+	var mnfObj unstructured.Unstructured
+	err := yaml.Unmarshal(manifestBytes, &mnfObj)
+	if err != nil {
+		return nil, err
+	}
+	mnfObj.SetName(name)
+	return yaml.Marshal(mnfObj.Object)
+}
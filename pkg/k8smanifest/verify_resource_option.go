@@ -17,10 +17,13 @@
 package k8smanifest
 
 import (
-	"os"
+	"regexp"
+	"strings"
+	"time"
 
-	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
 	k8ssigutil "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -28,6 +31,34 @@ type VerifyOption struct {
 	SkipObjects  ObjectReferenceList    `json:"skipObjects,omitempty"`
 	IgnoreFields ObjectFieldBindingList `json:"ignoreFields,omitempty"`
 	Signers      SignerList             `json:"signers,omitempty"`
+	// threshold and role-based signer requirements in addition to Signers
+	SignerPolicy *SignerPolicy `json:"signerPolicy,omitempty"`
+	// verifier backend of signatures (if empty, cosign)
+	Backend *BackendOption `json:"backend,omitempty"`
+	// rejects signatures by their signing time (e.g. older than 90 days)
+	SigningTime *SigningTimePolicy `json:"signingTime,omitempty"`
+	// a signed list of revoked signers, keys, certificates and bundles
+	Revocation *RevocationListSource `json:"revocation,omitempty"`
+	// if true, a bundle image must be referred by digest (e.g. `bundle:dev@sha256:...`)
+	RequireBundleDigest bool `json:"requireBundleDigest,omitempty"`
+	// connections to registries (applied by ConfigureRegistry)
+	Registry *RegistryOption `json:"registry,omitempty"`
+	// if true, resources generated by controllers (e.g. Pod, ReplicaSet) are verified with the signed template of their ancestor
+	FollowOwnerReferences bool `json:"followOwnerReferences,omitempty"`
+	// if enabled, container images referenced by the manifest are also verified
+	ContainerImages *ContainerImageVerifyOption `json:"containerImages,omitempty"`
+	// rules to map names of deployed resources back to the signed names (e.g. `namePrefix` of kustomize)
+	NameTransforms NameTransformList `json:"nameTransforms,omitempty"`
+	// fields of custom resources which are populated by a server or a controller, in addition to the built-in normalizers
+	Normalizers FieldNormalizerList `json:"normalizers,omitempty"`
+	// mutating webhooks (e.g. sidecar injection) whose additions to resources are tolerated
+	KnownMutators KnownMutatorList `json:"knownMutators,omitempty"`
+	// a diff is accepted if the field is owned only by these field managers in `metadata.managedFields`
+	AllowedFieldManagers FieldManagerList `json:"allowedFieldManagers,omitempty"`
+	// how this config is merged onto the configs loaded before it (see LoadVerifyConfigs)
+	Merge *ConfigMergeOption `json:"merge,omitempty"`
+	// digest of values files of a helm chart, which must be recorded in signatures (see HelmValuesDigest)
+	HelmValuesDigest string `json:"-"`
 }
 
 type ObjectReference struct {
@@ -48,12 +79,34 @@ type ObjectUserBinding struct {
 type ObjectFieldBinding struct {
 	Fields  []string            `json:"fields,omitempty"`
 	Objects ObjectReferenceList `json:"objects,omitempty"`
+	// ignoreFields only: if set, a diff in the fields is ignored only when it satisfies the constraint
+	Constraint *IgnoreConstraint `json:"constraint,omitempty"`
+}
+
+// IgnoreConstraint is a guardrail of an ignored diff. The value constraints are checked with the value
+// in the deployed resource, so a removed field does not satisfy them.
+type IgnoreConstraint struct {
+	ParameterConstraint
+	// if true, only fields which are not in the signed manifest are ignored, and changed or removed fields are not
+	AdditionsOnly bool `json:"additionsOnly,omitempty"`
 }
 
 type ObjectFieldBindingList []ObjectFieldBinding
 
 type SignerList []string
 
+// NameTransform describes how a signed name is changed on deployment.
+// The deployed name is `<Prefix><signed name><Suffix>`, optionally followed by
+// a hash suffix of configMapGenerator / secretGenerator when StripHashSuffix is true.
+type NameTransform struct {
+	Objects         ObjectReferenceList `json:"objects,omitempty"`
+	Prefix          string              `json:"prefix,omitempty"`
+	Suffix          string              `json:"suffix,omitempty"`
+	StripHashSuffix bool                `json:"stripHashSuffix,omitempty"`
+}
+
+type NameTransformList []NameTransform
+
 func ObjectToReference(obj unstructured.Unstructured) ObjectReference {
 	return ObjectReference{
 		Group:     obj.GroupVersionKind().Group,
@@ -110,6 +163,40 @@ func (f ObjectFieldBinding) Match(obj unstructured.Unstructured) (bool, []string
 	return false, nil
 }
 
+// filter out diffs in ignored fields of the object. a diff in fields with a constraint remains unless it satisfies the constraint
+func (l ObjectFieldBindingList) FilterDiff(obj unstructured.Unstructured, diff *mapnode.DiffResult) *mapnode.DiffResult {
+	if diff == nil || len(l) == 0 {
+		return diff
+	}
+	// filters in fields (e.g. `[?(@.name=='sidecar')]`) are evaluated against the object
+	objNode, _ := mapnode.NewFromMap(obj.Object)
+	for _, f := range l {
+		if !f.Objects.Match(obj) {
+			continue
+		}
+		ignoredDiff, otherDiff, _ := diff.FilterWithNode(f.Fields, objNode)
+		for _, d := range ignoredDiff.Items {
+			if !f.Constraint.Allow(d) {
+				otherDiff.Items = append(otherDiff.Items, d)
+			}
+		}
+		diff = otherDiff
+	}
+	return diff
+}
+
+// Allow returns true if the diff can be ignored. `before` of the diff is the deployed value and `after` is the signed one.
+func (c *IgnoreConstraint) Allow(d mapnode.Difference) bool {
+	if c == nil {
+		return true
+	}
+	before, after := d.Values["before"], d.Values["after"]
+	if c.AdditionsOnly && (before == nil || after != nil) {
+		return false
+	}
+	return c.ParameterConstraint.Match(before)
+}
+
 func (l SignerList) Match(signerName string) bool {
 	if len(l) == 0 {
 		return true
@@ -122,15 +209,131 @@ func (l SignerList) Match(signerName string) bool {
 	return false
 }
 
-func LoadVerifyConfig(fpath string) (*VerifyOption, error) {
-	cfgBytes, err := os.ReadFile(fpath)
+// return the signed name of a deployed name, or false if the name was not generated by this transform
+func (t NameTransform) OriginalName(name string) (string, bool) {
+	origName := name
+	if t.StripHashSuffix {
+		if !k8ssigutil.HasKustomizeHashSuffix(origName) {
+			return "", false
+		}
+		origName = k8ssigutil.StripKustomizeHashSuffix(origName)
+	}
+	if !strings.HasPrefix(origName, t.Prefix) || !strings.HasSuffix(origName, t.Suffix) {
+		return "", false
+	}
+	origName = strings.TrimSuffix(strings.TrimPrefix(origName, t.Prefix), t.Suffix)
+	if origName == "" || origName == name {
+		return "", false
+	}
+	return origName, true
+}
+
+// return candidates of the signed name of the object
+func (l NameTransformList) OriginalNames(obj unstructured.Unstructured) []string {
+	names := []string{}
+	for _, t := range l {
+		if !t.Objects.Match(obj) {
+			continue
+		}
+		if origName, ok := t.OriginalName(obj.GetName()); ok {
+			names = append(names, origName)
+		}
+	}
+	return names
+}
+
+// keys of a resource name and name references which kustomize transforms (e.g. `configMapRef` in a pod spec)
+var nameReferenceKeyPattern = regexp.MustCompile(`^(metadata\.name|spec\.serviceName|spec\.scaleTargetRef\.name|roleRef\.name|subjects\.\d+\.name|` +
+	`.*\.(configMapRef|secretRef|configMapKeyRef|secretKeyRef|configMap)\.name|.*\.secret\.secretName|` +
+	`.*\.persistentVolumeClaim\.claimName|.*\.imagePullSecrets\.\d+\.name|.*\.serviceAccountName|` +
+	`.*\.backend\.(serviceName|service\.name))$`)
+
+// filter out diffs which are caused only by name transforms (e.g. a reference to a generated ConfigMap).
+// only a resource name and name references are checked, so that a value like `prod-xxx` in any other field does not pass
+func (l NameTransformList) FilterDiff(diff *mapnode.DiffResult) *mapnode.DiffResult {
+	if diff == nil || len(l) == 0 {
+		return diff
+	}
+	items := []mapnode.Difference{}
+	for _, d := range diff.Items {
+		if !nameReferenceKeyPattern.MatchString(d.Key) || !l.transformed(d.Values["before"], d.Values["after"]) {
+			items = append(items, d)
+		}
+	}
+	return &mapnode.DiffResult{Items: items}
+}
+
+func (l NameTransformList) transformed(before, after interface{}) bool {
+	deployedName, ok1 := before.(string)
+	signedName, ok2 := after.(string)
+	if !ok1 || !ok2 {
+		return false
+	}
+	for _, t := range l {
+		if origName, ok := t.OriginalName(deployedName); ok && origName == signedName {
+			return true
+		}
+	}
+	return false
+}
+
+func (l SignerList) MatchAny(signerNames []string) bool {
+	for _, s := range signerNames {
+		if l.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// return a backend option with checkers of signatures, and load a revocation list if configured.
+// the list is cached and refreshed periodically, and a RevokedError is returned if it is not available
+func (vo *VerifyOption) backendOption() (*BackendOption, error) {
+	if vo == nil {
+		return nil, nil
+	}
+	if vo.SigningTime == nil && vo.Revocation == nil && vo.HelmValuesDigest == "" {
+		return vo.Backend, nil
+	}
+	revocation, err := revocationLists.get(vo.Revocation, time.Now(), LoadRevocationList)
 	if err != nil {
 		return nil, err
 	}
+	bo := &BackendOption{}
+	if vo.Backend != nil {
+		*bo = *vo.Backend
+	}
+	bo.checker = &signatureChecker{TimePolicy: vo.SigningTime, Revocation: revocation, HelmValuesDigest: vo.HelmValuesDigest}
+	return bo, nil
+}
+
+func LoadVerifyConfig(fpath string) (*VerifyOption, error) {
+	return LoadVerifyConfigs([]string{fpath}, "")
+}
+
+// ParseVerifyConfig parses a config YAML strictly; an unknown field, an empty config or an invalid field path is an error
+func ParseVerifyConfig(cfgBytes []byte) (*VerifyOption, error) {
 	var option *VerifyOption
-	err = yaml.Unmarshal(cfgBytes, &option)
+	err := k8ssigutil.StrictUnmarshalYAML(cfgBytes, &option)
 	if err != nil {
 		return nil, err
 	}
+	if option == nil {
+		return nil, errors.New("config is empty")
+	}
+	if err = option.Validate(); err != nil {
+		return nil, err
+	}
 	return option, nil
 }
+
+// Validate returns an error if the option has an invalid field path (e.g. an unclosed `[`) or an invalid regex,
+// so that a typo is reported instead of silently never matching. see Lint() for warnings.
+func (vo *VerifyOption) Validate() error {
+	for _, f := range vo.Lint() {
+		if f.Level == LintLevelError {
+			return errors.New(f.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	mapnode "github.com/yuji-watanabe-jp/k8s-manifest-sigstore/pkg/util/mapnode"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNameTransformOriginalName(t *testing.T) {
+	cases := []struct {
+		transform NameTransform
+		name      string
+		expected  string
+		ok        bool
+	}{
+		{transform: NameTransform{Prefix: "prod-"}, name: "prod-app", expected: "app", ok: true},
+		{transform: NameTransform{Prefix: "prod-"}, name: "dev-app", ok: false},
+		{transform: NameTransform{Suffix: "-v2"}, name: "app-v2", expected: "app", ok: true},
+		{transform: NameTransform{Prefix: "prod-", StripHashSuffix: true}, name: "prod-app-config-7f9k2hbm5c", expected: "app-config", ok: true},
+		{transform: NameTransform{StripHashSuffix: true}, name: "app-config", ok: false},
+	}
+	for _, c := range cases {
+		actual, ok := c.transform.OriginalName(c.name)
+		if ok != c.ok || actual != c.expected {
+			t.Errorf("%+v: expected (%s, %v) for `%s`, but got (%s, %v)", c.transform, c.expected, c.ok, c.name, actual, ok)
+		}
+	}
+}
+
+func TestNameTransformFilterDiff(t *testing.T) {
+	transforms := NameTransformList{{Prefix: "prod-"}}
+	diff := &mapnode.DiffResult{Items: []mapnode.Difference{
+		{Key: "metadata.name", Values: map[string]interface{}{"before": "prod-app", "after": "app"}},
+		{Key: "spec.template.spec.containers.0.envFrom.0.configMapRef.name", Values: map[string]interface{}{"before": "prod-app-config", "after": "app-config"}},
+		// a transformed value in a field other than name references is not filtered
+		{Key: "spec.template.spec.containers.0.image", Values: map[string]interface{}{"before": "prod-evil:v1", "after": "evil:v1"}},
+		{Key: "spec.template.spec.containers.0.command.0", Values: map[string]interface{}{"before": "prod-sh", "after": "sh"}},
+	}}
+	remaining := transforms.FilterDiff(diff)
+	keys := remaining.Keys()
+	if len(keys) != 2 || keys[0] != "spec.template.spec.containers.0.image" || keys[1] != "spec.template.spec.containers.0.command.0" {
+		t.Errorf("unexpected remaining diff keys: %v", keys)
+	}
+}
+
+func TestIgnoreFieldsFilterDiff(t *testing.T) {
+	var obj unstructured.Unstructured
+	_ = yaml.Unmarshal([]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 12
+`), &obj)
+	min, max := 2.0, 10.0
+	ignoreFields := ObjectFieldBindingList{
+		{Fields: []string{"spec.replicas"}, Constraint: &IgnoreConstraint{ParameterConstraint: ParameterConstraint{Range: &ParameterRange{Min: &min, Max: &max}}}},
+		{Fields: []string{"spec.template.spec.containers[*].image"}, Constraint: &IgnoreConstraint{ParameterConstraint: ParameterConstraint{Regex: `:v\d+\.\d+\.\d+$`}}},
+		{Fields: []string{"metadata.labels"}, Constraint: &IgnoreConstraint{AdditionsOnly: true}},
+		{Fields: []string{"metadata.annotations"}},
+	}
+	diff := &mapnode.DiffResult{Items: []mapnode.Difference{
+		{Key: "spec.replicas", Values: map[string]interface{}{"before": 12.0, "after": 3.0}},
+		{Key: "spec.template.spec.containers.0.image", Values: map[string]interface{}{"before": "app:v1.2.3", "after": "app:v1.2.0"}},
+		{Key: "spec.template.spec.containers.1.image", Values: map[string]interface{}{"before": "app:latest", "after": "app:v1.2.0"}},
+		{Key: "metadata.labels.team", Values: map[string]interface{}{"before": "a", "after": nil}},
+		{Key: "metadata.labels.app", Values: map[string]interface{}{"before": nil, "after": "app"}},
+		{Key: "metadata.annotations.foo", Values: map[string]interface{}{"before": nil, "after": "bar"}},
+	}}
+	remaining := ignoreFields.FilterDiff(obj, diff)
+	expected := map[string]bool{"spec.replicas": true, "spec.template.spec.containers.1.image": true, "metadata.labels.app": true}
+	if remaining.Size() != len(expected) {
+		t.Errorf("unexpected diffs remain; %s", remaining)
+	}
+	for _, d := range remaining.Items {
+		if !expected[d.Key] {
+			t.Errorf("diff `%s` should be ignored", d.Key)
+		}
+	}
+}
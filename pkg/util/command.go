@@ -36,3 +36,18 @@ func CmdExec(baseCmd string, args ...string) (string, error) {
 	out := stdout.String()
 	return out, nil
 }
+
+func CmdExecWithInput(input []byte, baseCmd string, args ...string) (string, error) {
+	cmd := exec.Command(baseCmd, args...)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.Wrap(err, stderr.String())
+	}
+	out := stdout.String()
+	return out, nil
+}
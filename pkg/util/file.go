@@ -136,3 +136,25 @@ func TarGzDecompress(src io.Reader, dst string) error {
 	}
 	return nil
 }
+
+// CopyDir copies all files in src dir into dst dir
+func CopyDir(src, dst string) error {
+	return filepath.Walk(src, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, fpath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(fpath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode())
+	})
+}
@@ -0,0 +1,51 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const helmChartFileName = "Chart.yaml"
+
+func IsHelmChartDir(dirPath string) bool {
+	fi, err := os.Stat(dirPath)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dirPath, helmChartFileName))
+	return err == nil
+}
+
+// render manifests in a helm chart in the same way as `helm template`
+func HelmTemplate(chartPath, releaseName, namespace string, valuesFiles []string) ([]byte, error) {
+	args := []string{"template", releaseName, chartPath}
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	}
+	for _, f := range valuesFiles {
+		args = append(args, "--values", f)
+	}
+	out, err := CmdExec("helm", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run `helm template`")
+	}
+	return []byte(out), nil
+}
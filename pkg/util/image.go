@@ -28,7 +28,6 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
@@ -36,17 +35,30 @@ import (
 )
 
 func PullImage(imageRef string) (v1.Image, error) {
-	ref, err := name.ParseReference(imageRef)
+	ref, err := name.ParseReference(MirrorImageRef(imageRef))
 	if err != nil {
 		return nil, err
 	}
-	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	img, err := remote.Image(ref, RegistryRemoteOptions()...)
 	if err != nil {
 		return nil, err
 	}
 	return img, nil
 }
 
+// return the digest of an image manifest in registry
+func GetImageDigest(imageRef string) (string, error) {
+	ref, err := name.ParseReference(MirrorImageRef(imageRef))
+	if err != nil {
+		return "", err
+	}
+	desc, err := remote.Head(ref, RegistryRemoteOptions()...)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest.String(), nil
+}
+
 func GetBlob(layer v1.Layer) ([]byte, error) {
 	rc, err := layer.Compressed()
 	if err != nil {
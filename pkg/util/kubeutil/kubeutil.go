@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
@@ -239,3 +240,108 @@ func ListResources(apiVersion, kind, namespace string) ([]*unstructured.Unstruct
 	}
 	return resources, nil
 }
+
+func DeleteResource(apiVersion, kind, namespace, name string) error {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return fmt.Errorf("Error in parsing apiVersion; %s", err.Error())
+	}
+	apiResources, err := GetAPIResources()
+	if err != nil {
+		return fmt.Errorf("Error in getting API Resources; %s", err.Error())
+	}
+	namespaced := true
+	gvr := schema.GroupVersionResource{}
+	for _, r := range apiResources {
+		if r.Group == gv.Group && r.Version == gv.Version && r.Kind == kind {
+			gvr = schema.GroupVersionResource{
+				Group:    r.Group,
+				Version:  r.Version,
+				Resource: r.Name,
+			}
+			namespaced = r.Namespaced
+		}
+	}
+	if gvr.Resource == "" {
+		return fmt.Errorf("Failed to find GroupVersionKind matches apiVerions: %s, kind: %s", apiVersion, kind)
+	}
+
+	config, err := GetKubeConfig()
+	if err != nil {
+		return fmt.Errorf("Error in getting k8s config; %s", err.Error())
+	}
+
+	dyClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("Error in creating DynamicClient; %s", err.Error())
+	}
+
+	if namespaced {
+		err = dyClient.Resource(gvr).Namespace(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	} else {
+		err = dyClient.Resource(gvr).Delete(context.Background(), name, metav1.DeleteOptions{})
+	}
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("Error in deleting resource; %s", err.Error())
+	}
+	return nil
+}
+
+func ApplyResource(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gvk := obj.GroupVersionKind()
+	apiResources, err := GetAPIResources()
+	if err != nil {
+		return nil, fmt.Errorf("Error in getting API Resources; %s", err.Error())
+	}
+	namespaced := true
+	gvr := schema.GroupVersionResource{}
+	for _, r := range apiResources {
+		if r.Group == gvk.Group && r.Version == gvk.Version && r.Kind == gvk.Kind {
+			gvr = schema.GroupVersionResource{
+				Group:    r.Group,
+				Version:  r.Version,
+				Resource: r.Name,
+			}
+			namespaced = r.Namespaced
+		}
+	}
+	if gvr.Resource == "" {
+		return nil, fmt.Errorf("Failed to find GroupVersionKind matches apiVerions: %s, kind: %s", obj.GetAPIVersion(), gvk.Kind)
+	}
+
+	config, err := GetKubeConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Error in getting k8s config; %s", err.Error())
+	}
+
+	dyClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error in creating DynamicClient; %s", err.Error())
+	}
+
+	var resClient dynamic.ResourceInterface
+	if namespaced {
+		resClient = dyClient.Resource(gvr).Namespace(obj.GetNamespace())
+	} else {
+		resClient = dyClient.Resource(gvr)
+	}
+
+	// create the resource if not found, otherwise update it with the current resourceVersion
+	current, err := resClient.Get(context.Background(), obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return nil, fmt.Errorf("Error in getting resource; %s", err.Error())
+		}
+		created, err := resClient.Create(context.Background(), obj, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("Error in creating resource; %s", err.Error())
+		}
+		return created, nil
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	updated, err := resClient.Update(context.Background(), obj, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error in updating resource; %s", err.Error())
+	}
+	return updated, nil
+}
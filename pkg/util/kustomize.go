@@ -0,0 +1,64 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var kustomizationFileNames = []string{
+	"kustomization.yaml",
+	"kustomization.yml",
+	"Kustomization",
+}
+
+// hash suffix which is added by configMapGenerator / secretGenerator of kustomize (e.g. `-7f9k2hbm5c`)
+var kustomizeHashSuffixPattern = regexp.MustCompile(`-[bcdfghkmt2-9]{10}$`)
+
+func IsKustomizationDir(dirPath string) bool {
+	fi, err := os.Stat(dirPath)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	for _, fname := range kustomizationFileNames {
+		if _, err := os.Stat(filepath.Join(dirPath, fname)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// render manifests in a kustomization directory in the same way as `kustomize build`
+func KustomizeBuild(dirPath string) ([]byte, error) {
+	out, err := CmdExec("kubectl", "kustomize", dirPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run `kubectl kustomize`")
+	}
+	return []byte(out), nil
+}
+
+func StripKustomizeHashSuffix(name string) string {
+	return kustomizeHashSuffixPattern.ReplaceAllString(name, "")
+}
+
+func HasKustomizeHashSuffix(name string) bool {
+	return kustomizeHashSuffixPattern.MatchString(name)
+}
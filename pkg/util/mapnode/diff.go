@@ -18,7 +18,6 @@ package mapnode
 
 import (
 	"encoding/json"
-	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
@@ -112,18 +111,27 @@ func (dr *DiffResult) Remove(patterns []*DiffPattern) *DiffResult {
 	return &DiffResult{Items: items}
 }
 
+// split diff items into the ones whose keys match any of maskKeys (paths) and the others.
+// filters in paths (e.g. `[?(@.name=='foo')]`) never match; use FilterWithNode() for them.
 func (dr *DiffResult) Filter(maskKeys []string) (*DiffResult, *DiffResult, []string) {
-	for i, key := range maskKeys {
-		// to match diff fields with maskKey prefix, "*" is added here
-		maskKeys[i] = fmt.Sprintf("%s*", key)
-	}
+	return dr.FilterWithNode(maskKeys, nil)
+}
+
+// same as Filter(), but filters in paths are evaluated against the node
+func (dr *DiffResult) FilterWithNode(maskKeys []string, node *Node) (*DiffResult, *DiffResult, []string) {
+	paths := parseValidPaths(maskKeys)
 	filtered := &DiffResult{}
 	unfiltered := &DiffResult{}
 	matchedKeys := []string{}
 	for _, dri := range dr.Items {
-		driKey := dri.Key
-		exists, matched := keyExistsInList(maskKeys, driKey)
-		if exists {
+		matched := ""
+		for _, p := range paths {
+			if p.matchFlatKey(dri.Key, node) {
+				matched = p.String()
+				break
+			}
+		}
+		if matched != "" {
 			filtered.Items = append(filtered.Items, dri)
 			matchedKeys = append(matchedKeys, matched)
 		} else {
@@ -163,17 +171,6 @@ func (d *DiffResult) KeyString() string {
 	return string(keysByte)
 }
 
-func keyExistsInList(slice []string, val string) (bool, string) {
-	var isMatch bool
-	for _, item := range slice {
-		isMatch = isListed(val, item)
-		if isMatch {
-			return true, item
-		}
-	}
-	return false, ""
-}
-
 func isListed(data, rule string) bool {
 	isMatch := false
 	if data == rule {
@@ -17,7 +17,6 @@
 package mapnode
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -240,57 +239,56 @@ func (n *Node) Merge(n2 *Node) (*Node, error) {
 	return emptyNode(), errors.New("Unsupported type of node.")
 }
 
-// extract elements that match input key
+// extract elements that match input key. keys are paths (see Path)
 func (n *Node) Extract(filterKeys []string) *Node {
-	m := n.Ravel()
-	allKeysInNode := []string{}
-	for k := range m {
-		allKeysInNode = append(allKeysInNode, k)
-	}
-	maskKeys := []string{}
-	validFilterKeys := n.validateKeyList(filterKeys)
-	for _, k := range allKeysInNode {
-		keyFoundInFilters := false
-		for _, fk := range validFilterKeys {
-			if strings.HasPrefix(k, fk) {
-				keyFoundInFilters = true
-				break
+	paths := parseValidPaths(filterKeys)
+	node := n.recursiveExtract(n, []string{}, paths)
+	if node == nil {
+		return &Node{Value: nil, Children: map[string]*Node{}}
+	}
+	return node
+}
+
+// return the node if a path matches it, or its children which are extracted. nil if nothing is extracted
+func (n *Node) recursiveExtract(root *Node, currentKeys []string, paths []*Path) *Node {
+	if len(currentKeys) > 0 && matchAnyPath(paths, currentKeys, root) {
+		return n
+	}
+	if n.IsValue() {
+		return nil
+	}
+	if n.IsMap() {
+		newChildrenMap := make(map[string]*Node)
+		for k, v := range n.Children.(map[string]*Node) {
+			if en := v.recursiveExtract(root, appendKey(currentKeys, k), paths); en != nil {
+				newChildrenMap[k] = en
 			}
 		}
-		if !keyFoundInFilters {
-			maskKeys = append(maskKeys, k)
+		if len(newChildrenMap) == 0 && len(currentKeys) > 0 {
+			return nil
 		}
-
-		keyParts := strings.Split(k, ".")
-		for i := range keyParts {
-			if i == len(keyParts)-1 {
-				continue
-			}
-			parentKeyUsedInFilters := false
-			parentKey := strings.Join(keyParts[:i+1], ".")
-			for _, fk := range validFilterKeys {
-				if strings.HasPrefix(fk, parentKey) || strings.HasPrefix(parentKey, fk) {
-					parentKeyUsedInFilters = true
-					break
-				}
-			}
-			if !parentKeyUsedInFilters {
-				maskKeys = append(maskKeys, parentKey)
-			}
+		return &Node{Value: nil, Children: newChildrenMap}
+	}
+	newChildrenSlice := []*Node{}
+	for i, v := range n.Children.([]*Node) {
+		if en := v.recursiveExtract(root, appendKey(currentKeys, strconv.Itoa(i)), paths); en != nil {
+			newChildrenSlice = append(newChildrenSlice, en)
 		}
 	}
-	node := n.Mask(maskKeys)
-	return node
+	if len(newChildrenSlice) == 0 && len(currentKeys) > 0 {
+		return nil
+	}
+	return &Node{Value: nil, Children: newChildrenSlice}
 }
 
-// remove elements that match input key
+// remove elements that match input key. keys are paths (see Path), and invalid ones are ignored
 func (t *Node) Mask(keys []string) *Node {
-	validKeys := t.validateKeyList(keys)
-	node := t.recursiveMask("", validKeys)
+	paths := parseValidPaths(keys)
+	node := t.recursiveMask(t, []string{}, paths)
 	return node
 }
 
-func (n *Node) recursiveMask(currentPath string, maskKeys []string) *Node {
+func (n *Node) recursiveMask(root *Node, currentKeys []string, paths []*Path) *Node {
 	if n.IsValue() {
 		return n
 	}
@@ -299,17 +297,11 @@ func (n *Node) recursiveMask(currentPath string, maskKeys []string) *Node {
 		children := n.Children.(map[string]*Node)
 		newChildrenMap := make(map[string]*Node)
 		for k, v := range children {
-			var currentKey string
-			if currentPath == "" {
-				currentKey = k
-			} else {
-				currentKey = fmt.Sprintf("%s.%s", currentPath, k)
-			}
-
-			if matched, _ := keyExistsInList(maskKeys, currentKey); matched {
+			currentKey := appendKey(currentKeys, k)
+			if matchAnyPath(paths, currentKey, root) {
 				continue
 			}
-			mn := v.recursiveMask(currentKey, maskKeys)
+			mn := v.recursiveMask(root, currentKey, paths)
 			newChildrenMap[k] = mn
 		}
 		newChildren = newChildrenMap
@@ -318,17 +310,11 @@ func (n *Node) recursiveMask(currentPath string, maskKeys []string) *Node {
 		children := n.Children.([]*Node)
 		newChildrenSlice := []*Node{}
 		for i, v := range children {
-			k := strconv.Itoa(i)
-			var currentKey string
-			if currentPath == "" {
-				currentKey = k
-			} else {
-				currentKey = fmt.Sprintf("%s.%s", currentPath, k)
-			}
-			if matched, _ := keyExistsInList(maskKeys, currentKey); matched {
+			currentKey := appendKey(currentKeys, strconv.Itoa(i))
+			if matchAnyPath(paths, currentKey, root) {
 				continue
 			}
-			mn := v.recursiveMask(currentKey, maskKeys)
+			mn := v.recursiveMask(root, currentKey, paths)
 			newChildrenSlice = append(newChildrenSlice, mn)
 		}
 		newChildren = newChildrenSlice
@@ -336,6 +322,21 @@ func (n *Node) recursiveMask(currentPath string, maskKeys []string) *Node {
 	return &Node{Value: nil, Children: newChildren}
 }
 
+func appendKey(keys []string, key string) []string {
+	newKeys := make([]string, len(keys), len(keys)+1)
+	copy(newKeys, keys)
+	return append(newKeys, key)
+}
+
+func matchAnyPath(paths []*Path, keys []string, root *Node) bool {
+	for _, p := range paths {
+		if p.MatchKey(keys, root) {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *Node) IsValue() bool {
 	return (!n.IsMap() && !n.IsSlice())
 }
@@ -416,103 +417,16 @@ func (n *Node) GetNodeByJSONPath(jpathKey string) (*Node, error) {
 	return foundNode, nil
 }
 
-// convert key "foo[1].bar" to "foo.1.bar" and then generate actual existing keys by generateKeyList()
-func (t *Node) validateKeyList(keys []string) []string {
-	newKeys := []string{}
-	for i, key := range keys {
-		keyAlt := parseConcatKey(key)
-		if key != keyAlt {
-			keys[i] = keyAlt
-		}
-	}
-	for _, key := range keys {
-		tmpList := t.generateKeyList(key)
-		if len(tmpList) > 0 {
-			newKeys = append(newKeys, tmpList...)
-		} else {
-			newKeys = append(newKeys, key)
-		}
-	}
-	return newKeys
-}
-
-func GetConcreteKeys(keys []string, n *Node) []string {
-	validatedKeyList := n.validateKeyList(keys)
-	return validatedKeyList
-}
-
-// expand input key "foo[].bar" to actual exsiting keys like ["foo.1.bar", "foo.2.bar"]
-func (t *Node) generateKeyList(concatKey string) []string {
-	if !strings.Contains(concatKey, "[]") {
-		return []string{}
-	}
-	parts := strings.Split(concatKey, "[]")
-	for i := range parts {
-		parts[i] = strings.Trim(parts[i], ".")
-	}
-	indexList := []int{}
-	finished := []bool{}
-
-	concatKeySlice := []string{}
-	for i := 0; i < len(parts)-1; i++ {
-		indexList = append(indexList, 0)
-		finished = append(finished, false)
-	}
-	allFinished := false
-	for !allFinished {
-		key := ""
-		checkKey := ""
-		for i, p := range parts {
-			key += p
-			if i < len(parts)-1 {
-				key += fmt.Sprintf(".%s.", strconv.Itoa(indexList[i]))
-				checkKey += p
-				checkKey += fmt.Sprintf(".%s.", strconv.Itoa(indexList[i]))
-			}
-		}
-		key = strings.TrimSuffix(key, ".")
-		checkKey = strings.TrimSuffix(checkKey, ".")
-		ok := t.KeyExists(checkKey)
-		notFinishedI := -1
-		for i := len(finished) - 1; i >= 0; i-- {
-			if finished[i] {
-				continue
-			} else {
-				notFinishedI = i
-				break
-			}
-		}
-		if ok {
-			concatKeySlice = append(concatKeySlice, key)
-			indexList[notFinishedI] += 1
-		} else {
-			finished[notFinishedI] = true
-			if finished[0] {
-				allFinished = true
-			}
-		}
-	}
-	return concatKeySlice
-}
-
+// MultipleSubNode returns nodes which match the path, e.g. `spec.containers[*].env[*]` (same as FindNodes)
 func (t *Node) MultipleSubNode(concatKey string) []*Node {
-	if !strings.Contains(concatKey, "[]") {
-		subNode := t.SubNode(concatKey)
-		return []*Node{subNode}
-	}
-
-	concatKeySlice := t.generateKeyList(concatKey)
-	nodeSlice := []*Node{}
-	for _, key := range concatKeySlice {
-		tmpNode := t.SubNode(key)
-		nodeSlice = append(nodeSlice, tmpNode)
-	}
-
-	return nodeSlice
+	return t.FindNodes(concatKey)
 }
 
 func (t *Node) GetNode(concatKey string) (*Node, bool) {
-	keys := splitConcatKey(concatKey)
+	keys, ok := splitConcatKey(concatKey)
+	if !ok {
+		return emptyNode(), false
+	}
 	currentNode := &Node{
 		Value:    t.Value,
 		Children: t.Children,
@@ -799,6 +713,8 @@ func FindDiffBetweenNodes(t1, t2 *Node, findType map[string]bool) *DiffResult {
 
 	m1 := t1.Ravel()
 	m2 := t2.Ravel()
+	// semantically equal values (e.g. `cpu: 1` and `cpu: 1000m`) are not regarded as diffs
+	normalizeComparableMaps(m1, m2)
 	if reflect.DeepEqual(m1, m2) {
 		return nil
 	}
@@ -871,38 +787,13 @@ func recursiveGetByKey(m map[string]interface{}, i int, keyList []string) (inter
 	}
 }
 
-func parseConcatKey(concatKey string) string {
-	return strings.Join(splitConcatKey(concatKey), ".")
-}
-
-func splitConcatKey(concatKey string) []string {
-	var keys []string
-	if !strings.Contains(concatKey, "\"") {
-		keys = strings.Split(concatKey, ".")
-	} else {
-		r := csv.NewReader(strings.NewReader(concatKey))
-		r.Comma = '.' // delimiter
-		loadedKeys, err := r.Read()
-		if err != nil {
-			return []string{}
-		}
-		keys = loadedKeys
-	}
-	parsedKeys := []string{}
-	for _, k := range keys {
-		re := regexp.MustCompile(`\[\d+\]$`)
-		if found := re.FindString(k); found == "" {
-			parsedKeys = append(parsedKeys, k)
-		} else {
-			k1 := strings.ReplaceAll(k, found, "")
-			k2 := strings.Trim(found, "[]")
-			if k1 != "" {
-				parsedKeys = append(parsedKeys, k1)
-			}
-			parsedKeys = append(parsedKeys, k2)
-		}
+// split a key of a single node like `spec.containers[0].image` with the path syntax
+func splitConcatKey(concatKey string) ([]string, bool) {
+	p, err := ParsePath(concatKey)
+	if err != nil {
+		return nil, false
 	}
-	return parsedKeys
+	return p.concreteKeys()
 }
 
 // extract actual diff (remove "key-only diffs" in list)
@@ -934,7 +825,10 @@ func removeKeyDiffsInListNode(items []Difference) []Difference {
 }
 
 func GetValueByLongKey(m map[string]interface{}, longKey string) (interface{}, error) {
-	keyList := splitConcatKey(longKey)
+	keyList, ok := splitConcatKey(longKey)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("key `%s` is not a path of a single value", longKey))
+	}
 	val, err := recursiveGetByKey(m, 0, keyList)
 	if err != nil {
 		return nil, err
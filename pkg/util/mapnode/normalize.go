@@ -0,0 +1,170 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package mapnode
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// keys of resource.Quantity fields, e.g. `spec.containers.0.resources.limits.cpu`, `spec.hard.requests.memory`.
+// the patterns are anchored to the known fields, so that a number-like string in other fields (e.g. `data`) is not rewritten
+var quantityKeyPattern = regexp.MustCompile(`(^|\.)resources\.(limits|requests)\.[^.]+$|` +
+	`^(spec|status)\.(hard|used)\..+$|` +
+	`^(spec|status)\.(capacity|allocatable)\.[^.]+$|` +
+	`^spec\.limits\.[0-9]+\.(default|defaultRequest|max|min|maxLimitRequestRatio)\.[^.]+$|` +
+	`(^|\.)spec\.overhead\.[^.]+$|` +
+	`(^|\.)emptyDir\.sizeLimit$`)
+
+// keys of opaque data, whose values are compared only as they are
+var opaqueDataKeyPattern = regexp.MustCompile(`^(data|stringData|binaryData)\.`)
+
+// keys of IntOrString fields, e.g. `spec.ports.0.targetPort`, `spec.strategy.rollingUpdate.maxSurge`
+var intOrStringKeyPattern = regexp.MustCompile(`(^|\.)(targetPort|port|maxSurge|maxUnavailable|minAvailable)$`)
+
+// normalizeComparableMaps makes values in m2 identical to the ones in m1 if they are semantically equal,
+// e.g. `3` (int64) and `3` (float64), `cpu: 1` and `cpu: 1000m`, `targetPort: "80"` and `targetPort: 80`
+func normalizeComparableMaps(m1, m2 map[string]interface{}) {
+	for k, v1 := range m1 {
+		v2, ok := m2[k]
+		if !ok || v1 == nil || v2 == nil || reflect.DeepEqual(v1, v2) {
+			continue
+		}
+		if semanticallyEqual(k, v1, v2) {
+			m2[k] = v1
+		}
+	}
+}
+
+func semanticallyEqual(key string, v1, v2 interface{}) bool {
+	if opaqueDataKeyPattern.MatchString(key) {
+		return false
+	}
+	if numbersEqual(v1, v2) {
+		return true
+	}
+	if intOrStringKeyPattern.MatchString(key) {
+		s1, ok1 := intOrStringValue(v1)
+		s2, ok2 := intOrStringValue(v2)
+		if ok1 && ok2 && s1 == s2 {
+			return true
+		}
+	}
+	if quantityKeyPattern.MatchString(key) {
+		q1, err1 := toQuantity(v1)
+		q2, err2 := toQuantity(v2)
+		if err1 == nil && err2 == nil && q1.Cmp(q2) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compare numbers exactly. integers are not compared via float64, which cannot represent all of int64 values
+func numbersEqual(v1, v2 interface{}) bool {
+	i1, isInt1 := toInt64(v1)
+	i2, isInt2 := toInt64(v2)
+	if isInt1 && isInt2 {
+		return i1 == i2
+	}
+	f1, ok1 := toFloat(v1)
+	f2, ok2 := toFloat(v2)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if isInt1 {
+		return floatEqualsInt(f2, i1)
+	}
+	if isInt2 {
+		return floatEqualsInt(f1, i2)
+	}
+	return f1 == f2
+}
+
+// a float is equal to an int only if it is exactly the int value
+func floatEqualsInt(f float64, i int64) bool {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return false
+	}
+	return int64(f) == i
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// return a string of an int value or a string value
+func intOrStringValue(v interface{}) (string, bool) {
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	if i, ok := toInt64(v); ok {
+		return strconv.FormatInt(i, 10), true
+	}
+	if n, ok := toFloat(v); ok && n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+		return strconv.FormatInt(int64(n), 10), true
+	}
+	return "", false
+}
+
+func toQuantity(v interface{}) (resource.Quantity, error) {
+	if s, ok := v.(string); ok {
+		return resource.ParseQuantity(s)
+	}
+	if i, ok := toInt64(v); ok {
+		return resource.ParseQuantity(strconv.FormatInt(i, 10))
+	}
+	if n, ok := toFloat(v); ok {
+		return resource.ParseQuantity(strconv.FormatFloat(n, 'f', -1, 64))
+	}
+	return resource.Quantity{}, fmt.Errorf("%v is not a quantity", v)
+}
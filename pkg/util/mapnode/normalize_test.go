@@ -0,0 +1,130 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package mapnode
+
+import (
+	"testing"
+)
+
+func TestNormalizedDiff(t *testing.T) {
+	signed := []byte(`
+spec:
+  replicas: 3
+  strategy:
+    rollingUpdate:
+      maxSurge: 1
+  template:
+    spec:
+      containers:
+      - name: app
+        ports:
+        - containerPort: 8080
+        resources:
+          limits:
+            cpu: 1
+            memory: 1Gi
+          requests:
+            cpu: 500m
+  ports:
+  - port: 80
+    targetPort: "8080"
+`)
+	deployed := []byte(`
+spec:
+  replicas: 3.0
+  strategy:
+    rollingUpdate:
+      maxSurge: "1"
+  template:
+    spec:
+      containers:
+      - name: app
+        ports:
+        - containerPort: 8080
+        resources:
+          limits:
+            cpu: 1000m
+            memory: 1024Mi
+          requests:
+            cpu: "0.5"
+  ports:
+  - port: 80
+    targetPort: 8080
+`)
+	n1, _ := NewFromYamlBytes(signed)
+	n2, _ := NewFromYamlBytes(deployed)
+	if dr := n1.Diff(n2); dr != nil && dr.Size() > 0 {
+		t.Errorf("semantically equal values must not be diffs: %s", dr.String())
+	}
+
+	changed := []byte(`
+spec:
+  replicas: 4
+  template:
+    spec:
+      containers:
+      - name: app
+        resources:
+          limits:
+            cpu: 2
+`)
+	base := []byte(`
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        resources:
+          limits:
+            cpu: 1000m
+`)
+	n3, _ := NewFromYamlBytes(base)
+	n4, _ := NewFromYamlBytes(changed)
+	dr := n3.Diff(n4)
+	if dr == nil || dr.Size() != 2 {
+		t.Errorf("changed values must be diffs: %v", dr)
+	}
+}
+
+func TestNormalizeComparableMapsExactly(t *testing.T) {
+	m1 := map[string]interface{}{
+		"spec.replicas": int64(9007199254740993),
+		"spec.template.spec.containers.0.resources.limits.cpu": "1",
+		"data.max.connections":                                 "1",
+		"data.resources.limits.cpu":                            "1",
+	}
+	m2 := map[string]interface{}{
+		// float64 cannot represent the int64 value above, and it is rounded to this value
+		"spec.replicas": float64(9007199254740992),
+		"spec.template.spec.containers.0.resources.limits.cpu": "1000m",
+		"data.max.connections":                                 "1000m",
+		"data.resources.limits.cpu":                            "1000m",
+	}
+	normalizeComparableMaps(m1, m2)
+	expected := map[string]interface{}{
+		"spec.replicas": float64(9007199254740992),
+		"spec.template.spec.containers.0.resources.limits.cpu": "1",
+		"data.max.connections":                                 "1000m",
+		"data.resources.limits.cpu":                            "1000m",
+	}
+	for k, v := range expected {
+		if m2[k] != v {
+			t.Errorf("`%s`: expected %v, but got %v", k, v, m2[k])
+		}
+	}
+}
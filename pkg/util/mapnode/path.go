@@ -0,0 +1,431 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package mapnode
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**********************************************
+
+					Path
+
+***********************************************/
+
+// Path is a parsed path to select nodes. The syntax is a superset of dotted keys.
+//
+//	metadata.annotations."cosign.sigstore.dev/imageRef"  quoted key (`*` at the end is a key prefix)
+//	metadata.labels['app.kubernetes.io/name']            bracket key (no wildcard)
+//	metadata.labels.app\.kubernetes\.io/name             escaped dot
+//	spec.containers[0].image, spec.containers.0.image    index
+//	spec.containers[*].image, spec.containers[].image    any element (`*` is any key)
+//	metadata.labels.app*                                 key prefix
+//	..image, spec..image                                 recursive descent
+//	spec.containers[?(@.name=='sidecar')].image          filter of elements (`==`, `!=` or existence)
+//
+// A leading `$` or `$.` is allowed. A path matches a key and all keys under it.
+type Path struct {
+	raw      string
+	segments []pathSegment
+}
+
+type segmentKind int
+
+const (
+	segmentKey segmentKind = iota
+	segmentIndex
+	segmentAny
+	segmentKeyPrefix
+	segmentRecursive
+	segmentFilter
+)
+
+type pathSegment struct {
+	kind   segmentKind
+	key    string
+	index  int
+	filter *pathFilter
+}
+
+type pathFilter struct {
+	field []string
+	op    string
+	value string
+}
+
+func (p *Path) String() string {
+	return p.raw
+}
+
+// ParsePath parses a path string, and returns an error if the syntax is invalid
+func ParsePath(s string) (*Path, error) {
+	raw := s
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "$") {
+		s = strings.TrimPrefix(s, "$")
+		if !strings.HasPrefix(s, "..") {
+			s = strings.TrimPrefix(s, ".")
+		}
+	}
+	if s == "" {
+		return nil, fmt.Errorf("path `%s` is empty", raw)
+	}
+	segments := []pathSegment{}
+	i := 0
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			if strings.HasPrefix(s[i:], "..") {
+				segments = append(segments, pathSegment{kind: segmentRecursive})
+				i += 2
+				if i >= len(s) || s[i] == '.' {
+					return nil, fmt.Errorf("path `%s` has no key after `..`", raw)
+				}
+				continue
+			}
+			if len(segments) == 0 || i+1 >= len(s) || s[i+1] == '[' {
+				return nil, fmt.Errorf("path `%s` has an empty key", raw)
+			}
+			i++
+		case '[':
+			end := closingBracket(s, i)
+			if end < 0 {
+				return nil, fmt.Errorf("path `%s` has an unclosed `[`", raw)
+			}
+			seg, err := parseBracket(s[i+1 : end])
+			if err != nil {
+				return nil, fmt.Errorf("path `%s` is invalid; %s", raw, err.Error())
+			}
+			segments = append(segments, seg)
+			i = end + 1
+			if i < len(s) && s[i] != '.' && s[i] != '[' {
+				return nil, fmt.Errorf("path `%s` has an invalid character after `]`", raw)
+			}
+		case '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("path `%s` has an unclosed quote", raw)
+			}
+			// `*` at the end of a double-quoted key is a key prefix as same as a plain key
+			seg := pathSegment{kind: segmentKey, key: s[i+1 : i+1+end]}
+			if strings.HasSuffix(seg.key, "*") {
+				seg = pathSegment{kind: segmentKeyPrefix, key: strings.TrimSuffix(seg.key, "*")}
+			}
+			segments = append(segments, seg)
+			i = i + 1 + end + 1
+			if i < len(s) && s[i] != '.' && s[i] != '[' {
+				return nil, fmt.Errorf("path `%s` has an invalid character after a quoted key", raw)
+			}
+		default:
+			var key strings.Builder
+			for i < len(s) && s[i] != '.' && s[i] != '[' {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				key.WriteByte(s[i])
+				i++
+			}
+			seg, err := parsePlainKey(key.String())
+			if err != nil {
+				return nil, fmt.Errorf("path `%s` is invalid; %s", raw, err.Error())
+			}
+			segments = append(segments, seg)
+		}
+	}
+	return &Path{raw: raw, segments: segments}, nil
+}
+
+// ParsePaths parses paths, and returns an error for the first invalid path
+func ParsePaths(paths []string) ([]*Path, error) {
+	parsed := []*Path{}
+	for _, s := range paths {
+		p, err := ParsePath(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// parse paths and skip invalid ones, which never match
+func parseValidPaths(paths []string) []*Path {
+	parsed := []*Path{}
+	for _, s := range paths {
+		if p, err := ParsePath(s); err == nil {
+			parsed = append(parsed, p)
+		}
+	}
+	return parsed
+}
+
+func closingBracket(s string, start int) int {
+	var quote byte
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseBracket(content string) (pathSegment, error) {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "" || content == "*":
+		return pathSegment{kind: segmentAny}, nil
+	case isQuoted(content):
+		return pathSegment{kind: segmentKey, key: content[1 : len(content)-1]}, nil
+	case strings.HasPrefix(content, "?(") && strings.HasSuffix(content, ")"):
+		f, err := parseFilter(content[2 : len(content)-1])
+		if err != nil {
+			return pathSegment{}, err
+		}
+		return pathSegment{kind: segmentFilter, filter: f}, nil
+	}
+	index, err := strconv.Atoi(content)
+	if err != nil || index < 0 {
+		return pathSegment{}, fmt.Errorf("`[%s]` is not an index, a quoted key, `*` or a filter", content)
+	}
+	return pathSegment{kind: segmentIndex, index: index}, nil
+}
+
+func parsePlainKey(key string) (pathSegment, error) {
+	if key == "*" {
+		return pathSegment{kind: segmentAny}, nil
+	}
+	starIndex := strings.Index(key, "*")
+	if starIndex < 0 {
+		return pathSegment{kind: segmentKey, key: key}, nil
+	}
+	if starIndex != len(key)-1 {
+		return pathSegment{}, fmt.Errorf("`*` is allowed only at the end of key `%s`", key)
+	}
+	return pathSegment{kind: segmentKeyPrefix, key: strings.TrimSuffix(key, "*")}, nil
+}
+
+// parse `@.field == 'value'`, `@.field != 'value'` or `@.field`
+func parseFilter(expr string) (*pathFilter, error) {
+	expr = strings.TrimSpace(expr)
+	op := ""
+	left, right := expr, ""
+	for _, o := range []string{"==", "!="} {
+		if i := strings.Index(expr, o); i >= 0 {
+			op = o
+			left, right = strings.TrimSpace(expr[:i]), strings.TrimSpace(expr[i+len(o):])
+			break
+		}
+	}
+	if !strings.HasPrefix(left, "@.") || len(left) <= 2 {
+		return nil, fmt.Errorf("filter `%s` must start with `@.<field>`", expr)
+	}
+	field := strings.Split(strings.TrimPrefix(left, "@."), ".")
+	for _, f := range field {
+		if f == "" {
+			return nil, fmt.Errorf("filter `%s` has an empty field", expr)
+		}
+	}
+	if op == "" {
+		return &pathFilter{field: field}, nil
+	}
+	if right == "" {
+		return nil, fmt.Errorf("filter `%s` has no value", expr)
+	}
+	if isQuoted(right) {
+		right = right[1 : len(right)-1]
+	} else if _, err := strconv.ParseFloat(right, 64); err != nil && right != "true" && right != "false" && right != "null" {
+		return nil, fmt.Errorf("value of filter `%s` must be quoted, a number or a boolean", expr)
+	}
+	return &pathFilter{field: field, op: op, value: right}, nil
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && ((s[0] == '\'' && s[len(s)-1] == '\'') || (s[0] == '"' && s[len(s)-1] == '"'))
+}
+
+// return keys from the root if the path selects a single node, i.e. it has only keys and indices
+func (p *Path) concreteKeys() ([]string, bool) {
+	keys := []string{}
+	for _, seg := range p.segments {
+		switch seg.kind {
+		case segmentKey:
+			keys = append(keys, seg.key)
+		case segmentIndex:
+			keys = append(keys, strconv.Itoa(seg.index))
+		default:
+			return nil, false
+		}
+	}
+	return keys, true
+}
+
+// MatchKey returns true if the path matches the key or a parent of the key.
+// keySegments are keys from the root, and the root node is used for evaluating filters (if nil, filters never match).
+func (p *Path) MatchKey(keySegments []string, root *Node) bool {
+	return matchSegments(p.segments, keySegments, root)
+}
+
+// match a flat key like `spec.containers.0.image` in which keys with dots cannot be distinguished
+func (p *Path) matchFlatKey(flatKey string, root *Node) bool {
+	return p.MatchKey(strings.Split(flatKey, "."), root)
+}
+
+func matchSegments(segs []pathSegment, keys []string, node *Node) bool {
+	if len(segs) == 0 {
+		return true
+	}
+	seg := segs[0]
+	if seg.kind == segmentRecursive {
+		for skip := 0; skip < len(keys); skip++ {
+			if matchSegments(segs[1:], keys[skip:], node) {
+				return true
+			}
+			node = childOf(node, keys[skip])
+		}
+		return false
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	switch seg.kind {
+	case segmentKey:
+		if keys[0] == seg.key {
+			return matchSegments(segs[1:], keys[1:], childOf(node, keys[0]))
+		}
+		// a key with dots in a flat key is split into multiple keys
+		if n := strings.Count(seg.key, ".") + 1; n > 1 && len(keys) >= n && strings.Join(keys[:n], ".") == seg.key {
+			return matchSegments(segs[1:], keys[n:], nil)
+		}
+		// a key with dots written without quotes (e.g. `metadata.annotations.deprecated.daemonset.template.generation`)
+		// matches when the following key segments joined with "." are the whole key
+		if strings.HasPrefix(keys[0], seg.key+".") {
+			joined := seg.key
+			for n := 1; n < len(segs) && segs[n].kind == segmentKey && len(joined) < len(keys[0]); n++ {
+				joined = joined + "." + segs[n].key
+				if joined == keys[0] {
+					return matchSegments(segs[n+1:], keys[1:], childOf(node, keys[0]))
+				}
+			}
+		}
+		return false
+	case segmentIndex:
+		if keys[0] != strconv.Itoa(seg.index) {
+			return false
+		}
+	case segmentKeyPrefix:
+		if !strings.HasPrefix(keys[0], seg.key) {
+			return false
+		}
+	case segmentFilter:
+		if _, err := strconv.Atoi(keys[0]); err != nil {
+			return false
+		}
+		if !seg.filter.match(childOf(node, keys[0])) {
+			return false
+		}
+	}
+	return matchSegments(segs[1:], keys[1:], childOf(node, keys[0]))
+}
+
+// FindNodes returns nodes which match the path (not including nodes under them)
+func (t *Node) FindNodes(path string) []*Node {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil
+	}
+	return t.recursiveFind(t, []string{}, p)
+}
+
+func (n *Node) recursiveFind(root *Node, currentKeys []string, p *Path) []*Node {
+	if len(currentKeys) > 0 && p.MatchKey(currentKeys, root) {
+		return []*Node{n}
+	}
+	found := []*Node{}
+	if n.IsMap() {
+		children := n.Children.(map[string]*Node)
+		keys := make([]string, 0, len(children))
+		for k := range children {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			found = append(found, children[k].recursiveFind(root, appendKey(currentKeys, k), p)...)
+		}
+	} else if n.IsSlice() {
+		for i, v := range n.Children.([]*Node) {
+			found = append(found, v.recursiveFind(root, appendKey(currentKeys, strconv.Itoa(i)), p)...)
+		}
+	}
+	return found
+}
+
+func childOf(node *Node, key string) *Node {
+	if node == nil {
+		return nil
+	}
+	child, ok := node.GetChild(key)
+	if !ok {
+		return nil
+	}
+	return child
+}
+
+func (f *pathFilter) match(element *Node) bool {
+	if element == nil {
+		return false
+	}
+	target := element
+	for _, k := range f.field {
+		target = childOf(target, k)
+		if target == nil {
+			return f.op == "!="
+		}
+	}
+	if f.op == "" {
+		return true
+	}
+	var valStr string
+	if target.IsValue() && target.Value != nil {
+		valStr = fmt.Sprint(target.Value.Interface())
+	} else if target.IsValue() {
+		valStr = "null"
+	} else {
+		return f.op == "!="
+	}
+	equal := valStr == f.value
+	if !equal {
+		// compare numbers, e.g. `8080` and `8080.0`
+		v1, err1 := strconv.ParseFloat(valStr, 64)
+		v2, err2 := strconv.ParseFloat(f.value, 64)
+		equal = err1 == nil && err2 == nil && v1 == v2
+	}
+	if f.op == "==" {
+		return equal
+	}
+	return !equal
+}
@@ -0,0 +1,169 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package mapnode
+
+import (
+	"testing"
+)
+
+var pathTestYAML = []byte(`
+metadata:
+  labels:
+    app.kubernetes.io/name: sample
+    app: sample
+spec:
+  containers:
+  - name: app
+    image: sample:1.0
+    env:
+    - name: FOO
+      value: bar
+  - name: sidecar
+    image: proxy:2.0
+`)
+
+func TestParsePath(t *testing.T) {
+	valid := []string{
+		"spec.containers[0].image",
+		"spec.containers[].image",
+		"spec.containers.*.image",
+		`metadata.annotations."cosign.sigstore.dev/imageRef"`,
+		"metadata.labels['app.kubernetes.io/name']",
+		`metadata.labels.app\.kubernetes\.io/name`,
+		"$..image",
+		"spec.containers[?(@.name=='sidecar')].image",
+		"metadata.labels.app*",
+	}
+	for _, p := range valid {
+		if _, err := ParsePath(p); err != nil {
+			t.Errorf("path `%s` should be valid; %s", p, err.Error())
+		}
+	}
+	invalid := []string{
+		"",
+		"spec.containers[0.image",
+		"spec.containers[abc]",
+		`metadata.annotations."foo`,
+		"spec.",
+		"spec..",
+		"meta*data.name",
+		"spec.containers[?(name=='sidecar')]",
+		"spec.containers[?(@.name==sidecar)]",
+	}
+	for _, p := range invalid {
+		if _, err := ParsePath(p); err == nil {
+			t.Errorf("path `%s` should be invalid", p)
+		}
+	}
+}
+
+func TestPathMaskAndExtract(t *testing.T) {
+	node, err := NewFromYamlBytes(pathTestYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := node.Mask([]string{
+		"metadata.labels['app.kubernetes.io/name']",
+		"spec.containers[?(@.name=='sidecar')].image",
+		"..env",
+	})
+	if _, ok := masked.GetNode(`metadata.labels."app.kubernetes.io/name"`); ok {
+		t.Errorf("a label with dots should be masked")
+	}
+	if masked.GetString("metadata.labels.app") != "sample" {
+		t.Errorf("a label `app` should not be masked")
+	}
+	if masked.GetString("spec.containers.1.image") != "" || masked.GetString("spec.containers.0.image") != "sample:1.0" {
+		t.Errorf("only an image of sidecar should be masked; %s", masked.ToJson())
+	}
+	if _, ok := masked.GetNode("spec.containers.0.env"); ok {
+		t.Errorf("env should be masked by a recursive descent")
+	}
+
+	extracted := node.Extract([]string{`metadata.labels.app\.kubernetes\.io/name`, "spec.containers[*].name"})
+	expected := `{"metadata":{"labels":{"app.kubernetes.io/name":"sample"}},"spec":{"containers":[{"name":"app"},{"name":"sidecar"}]}}`
+	if extracted.ToJson() != expected {
+		t.Errorf("expected: %s, actual: %s", expected, extracted.ToJson())
+	}
+}
+
+func TestPathFilterDiff(t *testing.T) {
+	node, err := NewFromYamlBytes(pathTestYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr := &DiffResult{Items: []Difference{
+		{Key: "metadata.labels.app.kubernetes.io/name"},
+		{Key: "spec.containers.1.image"},
+		{Key: "spec.containers.0.image"},
+	}}
+	keys := []string{"metadata.labels['app.kubernetes.io/name']", "spec.containers[?(@.name=='sidecar')].image"}
+	filtered, unfiltered, _ := dr.FilterWithNode(keys, node)
+	if filtered.Size() != 2 || unfiltered.Size() != 1 || unfiltered.Items[0].Key != "spec.containers.0.image" {
+		t.Errorf("unexpected filter result; filtered: %s, unfiltered: %s", filtered, unfiltered)
+	}
+	// filters never match without a node
+	filtered, _, _ = dr.Filter(keys)
+	if filtered.Size() != 1 {
+		t.Errorf("unexpected filter result without node; filtered: %s", filtered)
+	}
+}
+
+// keys with dots written without quotes, as in CommonResourceMaskKeys of k8smanifest
+func TestPathMaskUnquotedDottedKeys(t *testing.T) {
+	node, err := NewFromYamlBytes([]byte(`
+metadata:
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{}'
+    deprecated.daemonset.template.generation: "1"
+    deprecated.daemonset: keep
+    kubectl: keep
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := node.Mask([]string{
+		"metadata.annotations.kubectl.\"kubernetes.io/last-applied-configuration\"",
+		"metadata.annotations.deprecated.daemonset.template.generation",
+	})
+	expected := `{"metadata":{"annotations":{"deprecated.daemonset":"keep","kubectl":"keep"}}}`
+	if masked.ToJson() != expected {
+		t.Errorf("expected: %s, actual: %s", expected, masked.ToJson())
+	}
+}
+
+func TestGetNodeAndMultipleSubNodeWithPath(t *testing.T) {
+	node, err := NewFromYamlBytes(pathTestYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"spec.containers[1].image", "spec.containers.1.image", "metadata.labels['app.kubernetes.io/name']", `metadata.labels."app.kubernetes.io/name"`} {
+		if _, ok := node.GetNode(key); !ok {
+			t.Errorf("expected a node for `%s`", key)
+		}
+	}
+	// a path of multiple nodes is not a key of a single node
+	if _, ok := node.GetNode("spec.containers[*].image"); ok {
+		t.Errorf("expected no single node for a wildcard path")
+	}
+	if nodes := node.MultipleSubNode("spec.containers[].image"); len(nodes) != 2 {
+		t.Errorf("expected 2 nodes, got %d", len(nodes))
+	}
+	if nodes := node.MultipleSubNode("spec.containers[?(@.name=='sidecar')].image"); len(nodes) != 1 || nodes[0].String() != "proxy:2.0" {
+		t.Errorf("expected the image of sidecar, got %v", nodes)
+	}
+}
@@ -0,0 +1,295 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package util
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+)
+
+// the original http.DefaultTransport, which is used as a base of registryTransport
+var defaultTransport = http.DefaultTransport
+
+// `DOCKER_CONFIG` env before it is overwritten by SetDockerConfigs
+var defaultDockerConfigDir = os.Getenv("DOCKER_CONFIG")
+
+var registryMirrors = struct {
+	sync.RWMutex
+	mirrors map[string]string
+}{}
+
+// a docker config merged by SetDockerConfigs and the directory where it is written for cosign
+var dockerConfig = struct {
+	sync.RWMutex
+	config *configfile.ConfigFile
+	merged []byte
+	dir    string
+}{}
+
+// registryTransport configures TLS and scheme of connections per registry host.
+// it is installed as http.DefaultTransport on init so that connections by cosign are also configured,
+// and the routes are swapped under the lock instead of replacing the global on every configuration.
+type registryTransport struct {
+	sync.RWMutex
+	routes *registryRoutes
+}
+
+type registryRoutes struct {
+	base           http.RoundTripper
+	insecure       http.RoundTripper
+	insecureHosts  map[string]bool
+	plainHTTPHosts map[string]bool
+}
+
+var sharedTransport = &registryTransport{}
+
+func init() {
+	http.DefaultTransport = sharedTransport
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.RLock()
+	routes := t.routes
+	t.RUnlock()
+	if routes == nil {
+		return defaultTransport.RoundTrip(req)
+	}
+	host := req.URL.Host
+	if routes.plainHTTPHosts[host] && req.URL.Scheme == "https" {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+	}
+	if routes.insecureHosts[host] {
+		return routes.insecure.RoundTrip(req)
+	}
+	return routes.base.RoundTrip(req)
+}
+
+// ConfigureRegistryTransport adds a CA bundle in PEM to trusted roots, and skips TLS verification
+// for insecure registries and uses plain HTTP for plainHTTP registries (`<host>[:<port>]`)
+func ConfigureRegistryTransport(caPEM []byte, insecure, plainHTTP []string) error {
+	baseTr, ok := defaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("default transport is not configurable")
+	}
+	var routes *registryRoutes
+	if len(caPEM) > 0 || len(insecure) > 0 || len(plainHTTP) > 0 {
+		base := baseTr.Clone()
+		if len(caPEM) > 0 {
+			pool, err := x509.SystemCertPool()
+			if err != nil || pool == nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return errors.New("no certificates are found in CA bundle")
+			}
+			if base.TLSClientConfig == nil {
+				base.TLSClientConfig = &tls.Config{}
+			}
+			base.TLSClientConfig.RootCAs = pool
+		}
+		insecureTr := base.Clone()
+		if insecureTr.TLSClientConfig == nil {
+			insecureTr.TLSClientConfig = &tls.Config{}
+		}
+		insecureTr.TLSClientConfig.InsecureSkipVerify = true
+		routes = &registryRoutes{
+			base:           base,
+			insecure:       insecureTr,
+			insecureHosts:  toSet(insecure),
+			plainHTTPHosts: toSet(plainHTTP),
+		}
+	}
+	sharedTransport.Lock()
+	sharedTransport.routes = routes
+	sharedTransport.Unlock()
+	return nil
+}
+
+// RegistryRemoteOptions returns options of registry connections with the transport and the credentials
+// configured by ConfigureRegistryTransport and SetDockerConfigs
+func RegistryRemoteOptions() []remote.Option {
+	return []remote.Option{
+		remote.WithTransport(sharedTransport),
+		remote.WithAuthFromKeychain(RegistryKeychain),
+	}
+}
+
+// RegistryKeychain resolves credentials with the docker config merged by SetDockerConfigs,
+// or with the default keychain if it is not set
+var RegistryKeychain authn.Keychain = registryKeychain{}
+
+type registryKeychain struct{}
+
+func (registryKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	dockerConfig.RLock()
+	cf := dockerConfig.config
+	dockerConfig.RUnlock()
+	if cf == nil {
+		return authn.DefaultKeychain.Resolve(target)
+	}
+	key := target.RegistryStr()
+	if key == name.DefaultRegistry {
+		key = authn.DefaultAuthKey
+	}
+	cfg, err := cf.GetAuthConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == (types.AuthConfig{}) {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
+
+// SetRegistryMirrors sets rewrite rules of registries for pulling images, e.g. `registry.example.com` -> `mirror.local`
+func SetRegistryMirrors(mirrors map[string]string) {
+	registryMirrors.Lock()
+	defer registryMirrors.Unlock()
+	registryMirrors.mirrors = mirrors
+}
+
+// MirrorImageRef returns an image ref whose registry is rewritten to its mirror if configured
+func MirrorImageRef(imageRef string) string {
+	registryMirrors.RLock()
+	defer registryMirrors.RUnlock()
+	for registry, mirror := range registryMirrors.mirrors {
+		if strings.HasPrefix(imageRef, registry+"/") {
+			return mirror + strings.TrimPrefix(imageRef, registry)
+		}
+	}
+	return imageRef
+}
+
+// SetDockerConfigs merges docker config JSONs (later ones take precedence) into a config which is used by RegistryKeychain.
+// `auths`, `credHelpers` and `credsStore` are merged. The config is also written into a file and `DOCKER_CONFIG` env
+// is set to it for cosign, which reads the env; this happens only when the merged config is changed.
+func SetDockerConfigs(configs [][]byte) error {
+	auths := map[string]interface{}{}
+	credHelpers := map[string]interface{}{}
+	credsStore := ""
+	for _, cfg := range configs {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal(cfg, &parsed); err != nil {
+			return errors.Wrap(err, "failed to parse docker config")
+		}
+		cfgAuths, ok := parsed["auths"].(map[string]interface{})
+		_, hasHelpers := parsed["credHelpers"]
+		_, hasStore := parsed["credsStore"]
+		if !ok && !hasHelpers && !hasStore {
+			// legacy `.dockercfg` format has auths at the top level
+			cfgAuths = parsed
+		}
+		for registry, auth := range cfgAuths {
+			auths[registry] = auth
+		}
+		if helpers, ok := parsed["credHelpers"].(map[string]interface{}); ok {
+			for registry, helper := range helpers {
+				credHelpers[registry] = helper
+			}
+		}
+		if store, ok := parsed["credsStore"].(string); ok && store != "" {
+			credsStore = store
+		}
+	}
+	mergedMap := map[string]interface{}{"auths": auths}
+	if len(credHelpers) > 0 {
+		mergedMap["credHelpers"] = credHelpers
+	}
+	if credsStore != "" {
+		mergedMap["credsStore"] = credsStore
+	}
+	merged, _ := json.Marshal(mergedMap)
+
+	dockerConfig.Lock()
+	defer dockerConfig.Unlock()
+	if bytes.Equal(merged, dockerConfig.merged) {
+		return nil
+	}
+	cf, err := config.LoadFromReader(bytes.NewReader(merged))
+	if err != nil {
+		return errors.Wrap(err, "failed to load merged docker config")
+	}
+	if dockerConfig.dir == "" {
+		dir, err := ioutil.TempDir("", "kubectl-sigstore-docker-config")
+		if err != nil {
+			return err
+		}
+		dockerConfig.dir = dir
+	}
+	// write and rename so that a config is not read while writing
+	tmpPath := filepath.Join(dockerConfig.dir, "config.json.tmp")
+	if err := ioutil.WriteFile(tmpPath, merged, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dockerConfig.dir, "config.json")); err != nil {
+		return err
+	}
+	if err := os.Setenv("DOCKER_CONFIG", dockerConfig.dir); err != nil {
+		return err
+	}
+	dockerConfig.config = cf
+	dockerConfig.merged = merged
+	return nil
+}
+
+// DefaultDockerConfig returns a docker config.json of the user (`$DOCKER_CONFIG` or `~/.docker`) if exists
+func DefaultDockerConfig() []byte {
+	dir := defaultDockerConfigDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	cfg, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil
+	}
+	return cfg
+}
+
+func toSet(list []string) map[string]bool {
+	s := map[string]bool{}
+	for _, item := range list {
+		s[item] = true
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestMirrorImageRef(t *testing.T) {
+	SetRegistryMirrors(map[string]string{"registry.example.com": "mirror.local:5000"})
+	defer SetRegistryMirrors(nil)
+
+	testcases := map[string]string{
+		"registry.example.com/bundle:dev":          "mirror.local:5000/bundle:dev",
+		"registry.example.com.evil/bundle:dev":     "registry.example.com.evil/bundle:dev",
+		"other.example.com/registry.example.com/a": "other.example.com/registry.example.com/a",
+	}
+	for imageRef, expected := range testcases {
+		if actual := MirrorImageRef(imageRef); actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+	}
+}
+
+func TestSetDockerConfigs(t *testing.T) {
+	orgEnv, orgEnvFound := os.LookupEnv("DOCKER_CONFIG")
+	defer func() {
+		if orgEnvFound {
+			os.Setenv("DOCKER_CONFIG", orgEnv)
+		} else {
+			os.Unsetenv("DOCKER_CONFIG")
+		}
+	}()
+
+	userConfig := []byte(`{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"},"ghcr.io":{"auth":"b2xkOm9sZA=="}}}`)
+	legacySecret := []byte(`{"ghcr.io":{"auth":"bmV3Om5ldw=="}}`)
+	err := SetDockerConfigs([][]byte{userConfig, legacySecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := ioutil.ReadFile(filepath.Join(os.Getenv("DOCKER_CONFIG"), "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	_ = json.Unmarshal(merged, &cfg)
+	if cfg.Auths["registry.example.com"].Auth != "dXNlcjpwYXNz" || cfg.Auths["ghcr.io"].Auth != "bmV3Om5ldw==" {
+		t.Errorf("unexpected merged config: %s", string(merged))
+	}
+}
+
+func TestSetDockerConfigsWithCredHelpers(t *testing.T) {
+	orgEnv, orgEnvFound := os.LookupEnv("DOCKER_CONFIG")
+	defer func() {
+		if orgEnvFound {
+			os.Setenv("DOCKER_CONFIG", orgEnv)
+		} else {
+			os.Unsetenv("DOCKER_CONFIG")
+		}
+	}()
+
+	userConfig := []byte(`{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}},"credHelpers":{"gcr.io":"gcloud"},"credsStore":"desktop"}`)
+	secret := []byte(`{"auths":{"ghcr.io":{"auth":"bmV3Om5ldw=="}}}`)
+	err := SetDockerConfigs([][]byte{userConfig, secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := ioutil.ReadFile(filepath.Join(os.Getenv("DOCKER_CONFIG"), "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		CredHelpers map[string]string `json:"credHelpers"`
+		CredsStore  string            `json:"credsStore"`
+	}
+	_ = json.Unmarshal(merged, &cfg)
+	if cfg.CredHelpers["gcr.io"] != "gcloud" || cfg.CredsStore != "desktop" {
+		t.Errorf("credHelpers and credsStore should be kept: %s", string(merged))
+	}
+
+	// credentials are resolved with the merged config without reading the env
+	err = SetDockerConfigs([][]byte{[]byte(`{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}},"credHelpers":{"gcr.io":"gcloud"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("DOCKER_CONFIG", t.TempDir())
+	ref, _ := name.ParseReference("registry.example.com/bundle:dev")
+	auth, err := RegistryKeychain.Resolve(ref.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCfg, _ := auth.Authorization()
+	if authCfg == nil || authCfg.Username != "user" || authCfg.Password != "pass" {
+		t.Errorf("unexpected credentials: %v", authCfg)
+	}
+}
@@ -0,0 +1,315 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+var timeType = reflect.TypeOf(time.Time{})
+
+/**********************************************
+
+			Strict YAML Unmarshal
+
+***********************************************/
+
+// StrictUnmarshalYAML unmarshals YAML like yaml.Unmarshal, but returns an error with the line number
+// for an unknown field, a duplicate field or a value of a wrong type, e.g. a typo `ignoreField` of `ignoreFields`
+func StrictUnmarshalYAML(data []byte, out interface{}) error {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if len(root.Content) > 0 {
+		if err := checkYAMLNode(root.Content[0], reflect.TypeOf(out), ""); err != nil {
+			return err
+		}
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+func checkYAMLNode(node *yamlv3.Node, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yamlv3.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	// a type with its own unmarshaler (e.g. time.Time) is not checked
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) || t.Kind() == reflect.Interface {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			return yamlNodeError(node, path, "must be an object")
+		}
+		fields := jsonFields(t)
+		found := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			fieldPath := joinFieldPath(path, key)
+			if found[key] {
+				return yamlNodeError(node.Content[i], fieldPath, "is duplicated")
+			}
+			found[key] = true
+			ft, ok := fields[key]
+			if !ok {
+				msg := fmt.Sprintf("is an unknown field of %s", t.Name())
+				if s := similarFieldName(key, fields); s != "" {
+					msg = fmt.Sprintf("%s (did you mean `%s`?)", msg, s)
+				}
+				return yamlNodeError(node.Content[i], fieldPath, msg)
+			}
+			if err := checkYAMLNode(node.Content[i+1], ft, fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yamlv3.MappingNode {
+			return yamlNodeError(node, path, "must be an object")
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := checkYAMLNode(node.Content[i+1], t.Elem(), joinFieldPath(path, node.Content[i].Value)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		if node.Kind != yamlv3.SequenceNode {
+			return yamlNodeError(node, path, "must be a list")
+		}
+		for i, c := range node.Content {
+			if err := checkYAMLNode(c, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Bool:
+		if node.Kind != yamlv3.ScalarNode || node.Tag != "!!bool" {
+			return yamlNodeError(node, path, "must be a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if node.Kind != yamlv3.ScalarNode || node.Tag != "!!int" {
+			return yamlNodeError(node, path, "must be an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if node.Kind != yamlv3.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") {
+			return yamlNodeError(node, path, "must be a number")
+		}
+	case reflect.String:
+		if node.Kind != yamlv3.ScalarNode {
+			return yamlNodeError(node, path, "must be a string")
+		}
+	}
+	return nil
+}
+
+func yamlNodeError(node *yamlv3.Node, path, msg string) error {
+	if path == "" {
+		path = "config"
+	}
+	return errors.New(fmt.Sprintf("line %d: `%s` %s", node.Line, path, msg))
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// return json field names and types of a struct, including the fields of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range jsonFields(ft) {
+					fields[k] = v
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// return a field name which differs only in case or plural, or within 2 edits
+func similarFieldName(key string, fields map[string]reflect.Type) string {
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.EqualFold(name, key) || strings.EqualFold(name, key+"s") || strings.EqualFold(name+"s", key) {
+			return name
+		}
+	}
+	for _, name := range names {
+		if editDistance(strings.ToLower(name), strings.ToLower(key)) <= 2 {
+			return name
+		}
+	}
+	return ""
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(vals ...int) int {
+	m := vals[0]
+	for _, v := range vals[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+/**********************************************
+
+				JSON Schema
+
+***********************************************/
+
+// GenerateJSONSchema returns a JSON Schema of a config type based on its json tags
+func GenerateJSONSchema(t reflect.Type, title string) ([]byte, error) {
+	defs := map[string]interface{}{}
+	schema := jsonSchemaOf(t, defs)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = title
+	if len(defs) > 0 {
+		schema["$defs"] = defs
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func jsonSchemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchemaOf(t, defs)
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaOf(t.Elem(), defs)}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": jsonSchemaOf(t.Elem(), defs)}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{}
+}
+
+// a named struct is defined in `$defs` once and referred to, and the root struct is inlined
+func structSchemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	isRoot := len(defs) == 0
+	if !isRoot && t.Name() != "" {
+		ref := map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := defs[t.Name()]; ok {
+			return ref
+		}
+		defs[t.Name()] = schema
+		fillStructProperties(t, props, defs)
+		return ref
+	}
+	// a placeholder so that the root is not regarded as a nested struct
+	defs[""] = nil
+	fillStructProperties(t, props, defs)
+	delete(defs, "")
+	return schema
+}
+
+func fillStructProperties(t reflect.Type, props map[string]interface{}, defs map[string]interface{}) {
+	for name, ft := range jsonFields(t) {
+		props[name] = jsonSchemaOf(ft, defs)
+	}
+}